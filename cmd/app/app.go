@@ -3,23 +3,27 @@ package main
 import (
 	"github.com/aerosystems/auth-service/internal/config"
 	HttpServer "github.com/aerosystems/auth-service/internal/presenters/http"
+	"github.com/aerosystems/auth-service/internal/usecases"
 	"github.com/sirupsen/logrus"
 )
 
 type App struct {
-	log        *logrus.Logger
-	cfg        *config.Config
-	httpServer *HttpServer.Server
+	log            *logrus.Logger
+	cfg            *config.Config
+	httpServer     *HttpServer.Server
+	accountUsecase *usecases.AccountUsecase
 }
 
 func NewApp(
 	log *logrus.Logger,
 	cfg *config.Config,
 	httpServer *HttpServer.Server,
+	accountUsecase *usecases.AccountUsecase,
 ) *App {
 	return &App{
-		log:        log,
-		cfg:        cfg,
-		httpServer: httpServer,
+		log:            log,
+		cfg:            cfg,
+		httpServer:     httpServer,
+		accountUsecase: accountUsecase,
 	}
 }
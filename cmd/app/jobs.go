@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+const accountDeletionInterval = 10 * time.Minute
+
+// runAccountDeletion hard-deletes accounts whose grace period is over, failures are retried on the next tick
+func (app *App) runAccountDeletion(ctx context.Context) error {
+	ticker := time.NewTicker(accountDeletionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := app.accountUsecase.DeleteScheduledAccounts(); err != nil {
+				app.log.Errorf("could not delete scheduled accounts: %s", err.Error())
+			}
+		}
+	}
+}
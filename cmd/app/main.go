@@ -35,6 +35,10 @@ func main() {
 		return app.httpServer.Run()
 	})
 
+	group.Go(func() error {
+		return app.runAccountDeletion(ctx)
+	})
+
 	group.Go(func() error {
 		return app.handleSignals(ctx, cancel)
 	})
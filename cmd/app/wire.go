@@ -4,21 +4,32 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"github.com/aerosystems/auth-service/internal/config"
+	OidcAdapter "github.com/aerosystems/auth-service/internal/infrastructure/adapters/oidc"
 	rpcRepo "github.com/aerosystems/auth-service/internal/infrastructure/adapters/rpc"
 	"github.com/aerosystems/auth-service/internal/infrastructure/repository/pg"
 	"github.com/aerosystems/auth-service/internal/models"
 	HttpServer "github.com/aerosystems/auth-service/internal/presenters/http"
 	"github.com/aerosystems/auth-service/internal/presenters/http/handlers"
 	"github.com/aerosystems/auth-service/internal/usecases"
+	"github.com/aerosystems/auth-service/pkg/encryptor"
 	GormPostgres "github.com/aerosystems/auth-service/pkg/gorm_postgres"
+	"github.com/aerosystems/auth-service/pkg/jwk"
 	"github.com/aerosystems/auth-service/pkg/logger"
+	RateLimiter "github.com/aerosystems/auth-service/pkg/rate_limiter"
 	RedisClient "github.com/aerosystems/auth-service/pkg/redis_client"
 	RpcClient "github.com/aerosystems/auth-service/pkg/rpc_client"
 	"github.com/go-redis/redis/v7"
 	"github.com/google/wire"
 	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
+	"net"
+	"strings"
 )
 
 //go:generate wire
@@ -26,8 +37,21 @@ func InitApp() *App {
 	panic(wire.Build(
 		wire.Bind(new(handlers.AuthUsecase), new(*usecases.AuthUsecase)),
 		wire.Bind(new(handlers.TokenUsecase), new(*usecases.TokenUsecase)),
+		wire.Bind(new(handlers.OAuthUsecase), new(*usecases.OAuthUsecase)),
+		wire.Bind(new(handlers.IdentityUsecase), new(*usecases.IdentityUsecase)),
+		wire.Bind(new(handlers.MfaUsecase), new(*usecases.MfaUsecase)),
+		wire.Bind(new(handlers.PasskeyUsecase), new(*usecases.PasskeyUsecase)),
+		wire.Bind(new(handlers.LockoutUsecase), new(*usecases.LockoutUsecase)),
+		wire.Bind(new(handlers.AccountUsecase), new(*usecases.AccountUsecase)),
+		wire.Bind(new(handlers.ExportUsecase), new(*usecases.ExportUsecase)),
 		wire.Bind(new(usecases.CodeRepository), new(*pg.CodeRepo)),
 		wire.Bind(new(usecases.UserRepository), new(*pg.UserRepo)),
+		wire.Bind(new(usecases.SigningKeyRepository), new(*pg.SigningKeyRepo)),
+		wire.Bind(new(usecases.ClientRepository), new(*pg.ClientRepo)),
+		wire.Bind(new(usecases.UserIdentityRepository), new(*pg.UserIdentityRepo)),
+		wire.Bind(new(usecases.TotpRepository), new(*pg.TotpRepo)),
+		wire.Bind(new(usecases.RecoveryCodeRepository), new(*pg.RecoveryCodeRepo)),
+		wire.Bind(new(usecases.PasskeyRepository), new(*pg.PasskeyRepo)),
 		wire.Bind(new(usecases.CheckmailAdapter), new(*rpcRepo.CheckmailAdapter)),
 		wire.Bind(new(usecases.MailAdapter), new(*rpcRepo.MailAdapter)),
 		wire.Bind(new(usecases.CustomerAdapter), new(*rpcRepo.CustomerAdapter)),
@@ -35,6 +59,7 @@ func InitApp() *App {
 		ProvideLogger,
 		ProvideConfig,
 		ProvideHttpServer,
+		ProvideRateLimiter,
 		ProvideLogrusLogger,
 		ProvideLogrusEntry,
 		ProvideGormPostgres,
@@ -42,17 +67,36 @@ func InitApp() *App {
 		ProvideBaseHandler,
 		ProvideUserHandler,
 		ProvideTokenHandler,
+		ProvideSessionHandler,
+		ProvideOAuthHandler,
+		ProvideIdentityHandler,
+		ProvideMfaHandler,
+		ProvidePasskeyHandler,
 		ProvideAuthUsecase,
 		ProvideTokenUsecase,
+		ProvideOAuthUsecase,
+		ProvideIdentityUsecase,
+		ProvideMfaUsecase,
+		ProvidePasskeyUsecase,
+		ProvideLockoutUsecase,
+		ProvideAccountUsecase,
+		ProvideExportUsecase,
 		ProvideCodeRepo,
 		ProvideUserRepo,
+		ProvideSigningKeyRepo,
+		ProvideClientRepo,
+		ProvideUserIdentityRepo,
+		ProvideTotpRepo,
+		ProvideRecoveryCodeRepo,
+		ProvidePasskeyRepo,
 		ProvideCheckmailRepo,
 		ProvideMailRepo,
 		ProvideCustomerRepo,
+		ProvideIdentityProviders,
 	))
 }
 
-func ProvideApp(log *logrus.Logger, cfg *config.Config, httpServer *HttpServer.Server) *App {
+func ProvideApp(log *logrus.Logger, cfg *config.Config, httpServer *HttpServer.Server, accountUsecase *usecases.AccountUsecase) *App {
 	panic(wire.Build(NewApp))
 }
 
@@ -64,8 +108,27 @@ func ProvideConfig() *config.Config {
 	panic(wire.Build(config.NewConfig))
 }
 
-func ProvideHttpServer(log *logrus.Logger, cfg *config.Config, userHandler *handlers.UserHandler, tokenHandler *handlers.TokenHandler) *HttpServer.Server {
-	return HttpServer.NewServer(log, cfg.AccessSecret, userHandler, tokenHandler)
+func ProvideHttpServer(log *logrus.Logger, tokenUsecase handlers.TokenUsecase, userHandler *handlers.UserHandler, tokenHandler *handlers.TokenHandler, sessionHandler *handlers.SessionHandler, oauthHandler *handlers.OAuthHandler, identityHandler *handlers.IdentityHandler, mfaHandler *handlers.MfaHandler, passkeyHandler *handlers.PasskeyHandler, rateLimiter *RateLimiter.Limiter, cfg *config.Config) *HttpServer.Server {
+	rules := cfg.RateLimits
+	if rules == "" {
+		rules = RateLimiter.DefaultRules
+	}
+	rateLimitRules, err := RateLimiter.ParseRules(rules)
+	if err != nil {
+		panic(err)
+	}
+	var trustedProxies []*net.IPNet
+	for _, cidr := range strings.Split(cfg.TrustedProxies, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		trustedProxies = append(trustedProxies, ipNet)
+	}
+	return HttpServer.NewServer(log, tokenUsecase, userHandler, tokenHandler, sessionHandler, oauthHandler, identityHandler, mfaHandler, passkeyHandler, rateLimiter, rateLimitRules, trustedProxies)
 }
 
 func ProvideLogrusEntry(log *logger.Logger) *logrus.Entry {
@@ -78,12 +141,22 @@ func ProvideLogrusLogger(log *logger.Logger) *logrus.Logger {
 
 func ProvideGormPostgres(e *logrus.Entry, cfg *config.Config) *gorm.DB {
 	db := GormPostgres.NewClient(e, cfg.PostgresDSN)
-	if err := db.AutoMigrate(&models.User{}, &models.Code{}); err != nil { // TODO: Move to migration
+	if err := db.AutoMigrate(&models.User{}, &models.Code{}, &pg.SigningKey{}, &pg.Client{}, &pg.UserIdentity{}, &pg.Totp{}, &pg.RecoveryCode{}, &pg.Passkey{}); err != nil { // TODO: Move to migration
+		panic(err)
+	}
+	if err := pg.MigrateGoogleIds(db); err != nil {
+		panic(err)
+	}
+	if err := pg.MigrateActiveCodes(db); err != nil {
 		panic(err)
 	}
 	return db
 }
 
+func ProvideRateLimiter(redisClient *redis.Client) *RateLimiter.Limiter {
+	return RateLimiter.NewLimiter(redisClient)
+}
+
 func ProvideRedisClient(log *logger.Logger, cfg *config.Config) *redis.Client {
 	return RedisClient.NewRedisClient(log, cfg.RedisDSN, cfg.RedisPassword)
 }
@@ -92,7 +165,7 @@ func ProvideBaseHandler(log *logrus.Logger, cfg *config.Config) *handlers.BaseHa
 	return handlers.NewBaseHandler(log, cfg.Mode)
 }
 
-func ProvideUserHandler(baseHandler *handlers.BaseHandler, tokenUsecase handlers.TokenUsecase, authUsecase handlers.AuthUsecase) *handlers.UserHandler {
+func ProvideUserHandler(baseHandler *handlers.BaseHandler, tokenUsecase handlers.TokenUsecase, authUsecase handlers.AuthUsecase, mfaUsecase handlers.MfaUsecase, lockoutUsecase handlers.LockoutUsecase, accountUsecase handlers.AccountUsecase, exportUsecase handlers.ExportUsecase) *handlers.UserHandler {
 	panic(wire.Build(handlers.NewUserHandler))
 }
 
@@ -100,12 +173,135 @@ func ProvideTokenHandler(baseHandler *handlers.BaseHandler, tokenUsecase handler
 	panic(wire.Build(handlers.NewTokenHandler))
 }
 
-func ProvideAuthUsecase(codeRepo usecases.CodeRepository, userRepo usecases.UserRepository, checkmailRepo usecases.CheckmailAdapter, mailRepo usecases.MailAdapter, customerRepo usecases.CustomerAdapter, cfg *config.Config) *usecases.AuthUsecase {
-	return usecases.NewAuthUsecase(codeRepo, userRepo, checkmailRepo, mailRepo, customerRepo, cfg.CodeExpMinutes)
+func ProvideSessionHandler(baseHandler *handlers.BaseHandler, tokenUsecase handlers.TokenUsecase) *handlers.SessionHandler {
+	panic(wire.Build(handlers.NewSessionHandler))
+}
+
+func ProvideOAuthHandler(baseHandler *handlers.BaseHandler, oauthUsecase handlers.OAuthUsecase, authUsecase handlers.AuthUsecase, mfaUsecase handlers.MfaUsecase, lockoutUsecase handlers.LockoutUsecase, accountUsecase handlers.AccountUsecase) *handlers.OAuthHandler {
+	panic(wire.Build(handlers.NewOAuthHandler))
+}
+
+func ProvideIdentityHandler(baseHandler *handlers.BaseHandler, tokenUsecase handlers.TokenUsecase, identityUsecase handlers.IdentityUsecase, mfaUsecase handlers.MfaUsecase, accountUsecase handlers.AccountUsecase) *handlers.IdentityHandler {
+	panic(wire.Build(handlers.NewIdentityHandler))
+}
+
+func ProvideMfaHandler(baseHandler *handlers.BaseHandler, tokenUsecase handlers.TokenUsecase, mfaUsecase handlers.MfaUsecase, accountUsecase handlers.AccountUsecase) *handlers.MfaHandler {
+	panic(wire.Build(handlers.NewMfaHandler))
+}
+
+func ProvidePasskeyHandler(baseHandler *handlers.BaseHandler, tokenUsecase handlers.TokenUsecase, passkeyUsecase handlers.PasskeyUsecase, accountUsecase handlers.AccountUsecase) *handlers.PasskeyHandler {
+	panic(wire.Build(handlers.NewPasskeyHandler))
+}
+
+func ProvideAuthUsecase(codeRepo usecases.CodeRepository, userRepo usecases.UserRepository, checkmailRepo usecases.CheckmailAdapter, mailRepo usecases.MailAdapter, customerRepo usecases.CustomerAdapter, tokenUsecase *usecases.TokenUsecase, lockoutUsecase *usecases.LockoutUsecase, cfg *config.Config) *usecases.AuthUsecase {
+	codeLength, codeAlphabet := cfg.CodeLength, cfg.CodeAlphabet
+	if codeLength <= 0 {
+		codeLength = 6
+	}
+	if len(codeAlphabet) < 2 {
+		codeAlphabet = "0123456789"
+	}
+	codeKey, err := base64.StdEncoding.DecodeString(cfg.CodeHmacKey)
+	if err != nil || len(codeKey) < 32 {
+		panic("code hmac key should be base64 encoded and at least 32 bytes long")
+	}
+	resendCooldown, resendDailyLimit := cfg.CodeResendCooldown, cfg.CodeResendDailyLimit
+	if resendCooldown <= 0 {
+		resendCooldown = 60
+	}
+	if resendDailyLimit <= 0 {
+		resendDailyLimit = 5
+	}
+	return usecases.NewAuthUsecase(codeRepo, userRepo, checkmailRepo, mailRepo, customerRepo, tokenUsecase, lockoutUsecase, cfg.CodeExpMinutes, codeLength, codeAlphabet, codeKey, resendCooldown, resendDailyLimit)
 }
 
-func ProvideTokenUsecase(redisClient *redis.Client, cfg *config.Config) *usecases.TokenUsecase {
-	return usecases.NewTokenUsecase(redisClient, cfg.AccessSecret, cfg.RefreshSecret, cfg.AccessExpMinutes, cfg.RefreshExpMinutes)
+func ProvideTokenUsecase(log *logrus.Logger, redisClient *redis.Client, signingKeyRepo usecases.SigningKeyRepository, cfg *config.Config) *usecases.TokenUsecase {
+	keyEncryptor, err := encryptor.NewEncryptor(cfg.JwtKeysEncryptionKey)
+	if err != nil {
+		panic(err)
+	}
+	// service tokens could not be revoked by signing out, so they are short-lived regardless of the config
+	serviceExpMinutes := cfg.ServiceTokenExpMinutes
+	if serviceExpMinutes <= 0 {
+		serviceExpMinutes = 15
+	}
+	if serviceExpMinutes > 60 {
+		serviceExpMinutes = 60
+	}
+	tokenUsecase := usecases.NewTokenUsecase(log, redisClient, signingKeyRepo, keyEncryptor, cfg.AccessExpMinutes, cfg.RefreshExpMinutes, serviceExpMinutes)
+	if err := tokenUsecase.EncryptSigningKeys(); err != nil {
+		panic(err)
+	}
+	keys, err := jwk.LoadDir(cfg.JwtKeysDir)
+	if err != nil {
+		panic(err)
+	}
+	if err := tokenUsecase.ImportSigningKeys(keys, cfg.JwtActiveKid); err != nil {
+		panic(err)
+	}
+	return tokenUsecase
+}
+
+func ProvideOAuthUsecase(redisClient *redis.Client, clientRepo usecases.ClientRepository, userRepo usecases.UserRepository, tokenUsecase *usecases.TokenUsecase, cfg *config.Config) *usecases.OAuthUsecase {
+	clients := make(map[string]string)
+	for _, client := range strings.Split(cfg.IntrospectionClients, ",") {
+		if clientId, secretHash, ok := strings.Cut(client, ":"); ok {
+			// secrets are never kept in config, only their SHA-256 hashes
+			if hash, err := hex.DecodeString(secretHash); err != nil || len(hash) != sha256.Size {
+				panic(fmt.Sprintf("secret of introspection client %s should be a hex encoded SHA-256 hash", clientId))
+			}
+			clients[clientId] = strings.ToLower(secretHash)
+		}
+	}
+	return usecases.NewOAuthUsecase(redisClient, clientRepo, userRepo, tokenUsecase, clients, cfg.OidcIssuer, cfg.AccessExpMinutes)
+}
+
+func ProvideIdentityUsecase(userRepo usecases.UserRepository, identityRepo usecases.UserIdentityRepository, customerRepo usecases.CustomerAdapter, providers []usecases.IdentityProvider) *usecases.IdentityUsecase {
+	panic(wire.Build(usecases.NewIdentityUsecase))
+}
+
+func ProvideMfaUsecase(redisClient *redis.Client, totpRepo usecases.TotpRepository, recoveryCodeRepo usecases.RecoveryCodeRepository, userRepo usecases.UserRepository, mailRepo usecases.MailAdapter, cfg *config.Config) *usecases.MfaUsecase {
+	mfaEncryptor, err := encryptor.NewEncryptor(cfg.MfaEncryptionKey)
+	if err != nil {
+		panic(err)
+	}
+	return usecases.NewMfaUsecase(redisClient, totpRepo, recoveryCodeRepo, userRepo, mailRepo, mfaEncryptor, cfg.TotpIssuer)
+}
+
+func ProvideLockoutUsecase(redisClient *redis.Client, userRepo usecases.UserRepository, mailRepo usecases.MailAdapter, cfg *config.Config) *usecases.LockoutUsecase {
+	delayAfter, lockThreshold, lockMinutes := cfg.SignInDelayAfter, cfg.SignInLockThreshold, cfg.SignInLockMinutes
+	if delayAfter <= 0 {
+		delayAfter = 3
+	}
+	if lockThreshold <= 0 {
+		lockThreshold = 10
+	}
+	if lockMinutes <= 0 {
+		lockMinutes = 15
+	}
+	return usecases.NewLockoutUsecase(redisClient, userRepo, mailRepo, delayAfter, lockThreshold, lockMinutes)
+}
+
+func ProvideAccountUsecase(log *logrus.Logger, userRepo usecases.UserRepository, mailRepo usecases.MailAdapter, customerRepo usecases.CustomerAdapter, tokenUsecase *usecases.TokenUsecase, cfg *config.Config) *usecases.AccountUsecase {
+	graceDays := cfg.AccountDeletionDays
+	if graceDays <= 0 {
+		graceDays = 14
+	}
+	return usecases.NewAccountUsecase(log, userRepo, mailRepo, customerRepo, tokenUsecase, graceDays)
+}
+
+func ProvideExportUsecase(userRepo usecases.UserRepository, codeRepo usecases.CodeRepository, userIdentityRepo usecases.UserIdentityRepository, tokenUsecase *usecases.TokenUsecase) *usecases.ExportUsecase {
+	panic(wire.Build(usecases.NewExportUsecase))
+}
+
+func ProvidePasskeyUsecase(redisClient *redis.Client, passkeyRepo usecases.PasskeyRepository, userRepo usecases.UserRepository, cfg *config.Config) *usecases.PasskeyUsecase {
+	var origins []string
+	for _, origin := range strings.Split(cfg.WebauthnOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return usecases.NewPasskeyUsecase(redisClient, passkeyRepo, userRepo, cfg.WebauthnRpId, cfg.WebauthnRpName, origins)
 }
 
 func ProvideCodeRepo(db *gorm.DB, cfg *config.Config) *pg.CodeRepo {
@@ -116,6 +312,30 @@ func ProvideUserRepo(db *gorm.DB) *pg.UserRepo {
 	panic(wire.Build(pg.NewUserRepo))
 }
 
+func ProvideSigningKeyRepo(db *gorm.DB) *pg.SigningKeyRepo {
+	panic(wire.Build(pg.NewSigningKeyRepo))
+}
+
+func ProvideClientRepo(db *gorm.DB) *pg.ClientRepo {
+	panic(wire.Build(pg.NewClientRepo))
+}
+
+func ProvideUserIdentityRepo(db *gorm.DB) *pg.UserIdentityRepo {
+	panic(wire.Build(pg.NewUserIdentityRepo))
+}
+
+func ProvideTotpRepo(db *gorm.DB) *pg.TotpRepo {
+	panic(wire.Build(pg.NewTotpRepo))
+}
+
+func ProvideRecoveryCodeRepo(db *gorm.DB) *pg.RecoveryCodeRepo {
+	panic(wire.Build(pg.NewRecoveryCodeRepo))
+}
+
+func ProvidePasskeyRepo(db *gorm.DB) *pg.PasskeyRepo {
+	panic(wire.Build(pg.NewPasskeyRepo))
+}
+
 func ProvideCheckmailRepo(cfg *config.Config) *rpcRepo.CheckmailAdapter {
 	rpcClient := RpcClient.NewClient("tcp", cfg.CheckmailServiceRPCAddr)
 	return rpcRepo.NewCheckmailAdapter(rpcClient)
@@ -130,3 +350,38 @@ func ProvideCustomerRepo(cfg *config.Config) *rpcRepo.CustomerAdapter {
 	rpcClient := RpcClient.NewClient("tcp", cfg.CustomerServiceRPCAddr)
 	return rpcRepo.NewCustomerAdapter(rpcClient)
 }
+
+func ProvideIdentityProviders(cfg *config.Config) []usecases.IdentityProvider {
+	var providers []usecases.IdentityProvider
+	if cfg.GoogleClientId != "" {
+		providers = append(providers, OidcAdapter.NewGoogleProvider(cfg.GoogleClientId, cfg.GoogleJwksUrl))
+	}
+	if cfg.GithubClientId != "" {
+		providers = append(providers, OidcAdapter.NewGitHubProvider(cfg.GithubClientId, cfg.GithubClientSecret, cfg.GithubOAuthUrl, cfg.GithubApiUrl))
+	}
+	if cfg.MicrosoftClientId != "" {
+		var emailDomains []string
+		for _, domain := range strings.Split(cfg.MicrosoftEmailDomains, ",") {
+			if domain = strings.TrimSpace(domain); domain != "" {
+				emailDomains = append(emailDomains, domain)
+			}
+		}
+		providers = append(providers, OidcAdapter.NewMicrosoftProvider(cfg.MicrosoftClientId, cfg.MicrosoftTenant, emailDomains))
+	}
+	if cfg.OidcProviders != "" {
+		var oidcProviders []struct {
+			Name         string   `json:"name"`
+			Issuer       string   `json:"issuer"`
+			ClientId     string   `json:"clientId"`
+			JwksUrl      string   `json:"jwksUrl"`
+			EmailDomains []string `json:"emailDomains"`
+		}
+		if err := json.Unmarshal([]byte(cfg.OidcProviders), &oidcProviders); err != nil {
+			panic(err)
+		}
+		for _, p := range oidcProviders {
+			providers = append(providers, OidcAdapter.NewProvider(p.Name, p.Issuer, p.ClientId, p.JwksUrl, p.EmailDomains))
+		}
+	}
+	return providers
+}
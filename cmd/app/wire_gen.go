@@ -7,20 +7,31 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"github.com/aerosystems/auth-service/internal/config"
+	"github.com/aerosystems/auth-service/internal/infrastructure/adapters/oidc"
 	"github.com/aerosystems/auth-service/internal/infrastructure/adapters/rpc"
 	"github.com/aerosystems/auth-service/internal/infrastructure/repository/pg"
 	"github.com/aerosystems/auth-service/internal/models"
 	"github.com/aerosystems/auth-service/internal/presenters/http"
 	"github.com/aerosystems/auth-service/internal/presenters/http/handlers"
 	"github.com/aerosystems/auth-service/internal/usecases"
+	"github.com/aerosystems/auth-service/pkg/encryptor"
 	"github.com/aerosystems/auth-service/pkg/gorm_postgres"
+	"github.com/aerosystems/auth-service/pkg/jwk"
 	"github.com/aerosystems/auth-service/pkg/logger"
+	"github.com/aerosystems/auth-service/pkg/rate_limiter"
 	"github.com/aerosystems/auth-service/pkg/redis_client"
 	"github.com/aerosystems/auth-service/pkg/rpc_client"
 	"github.com/go-redis/redis/v7"
 	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
+	"net"
+	"strings"
 )
 
 // Injectors from wire.go:
@@ -32,24 +43,44 @@ func InitApp() *App {
 	config := ProvideConfig()
 	baseHandler := ProvideBaseHandler(logrusLogger, config)
 	client := ProvideRedisClient(logger, config)
-	tokenUsecase := ProvideTokenUsecase(client, config)
 	entry := ProvideLogrusEntry(logger)
 	db := ProvideGormPostgres(entry, config)
+	signingKeyRepo := ProvideSigningKeyRepo(db)
+	tokenUsecase := ProvideTokenUsecase(logrusLogger, client, signingKeyRepo, config)
 	codeRepo := ProvideCodeRepo(db, config)
 	userRepo := ProvideUserRepo(db)
 	checkmailAdapter := ProvideCheckmailRepo(config)
 	mailAdapter := ProvideMailRepo(config)
 	customerAdapter := ProvideCustomerRepo(config)
-	authUsecase := ProvideAuthUsecase(codeRepo, userRepo, checkmailAdapter, mailAdapter, customerAdapter, config)
-	userHandler := ProvideUserHandler(baseHandler, tokenUsecase, authUsecase)
+	lockoutUsecase := ProvideLockoutUsecase(client, userRepo, mailAdapter, config)
+	authUsecase := ProvideAuthUsecase(codeRepo, userRepo, checkmailAdapter, mailAdapter, customerAdapter, tokenUsecase, lockoutUsecase, config)
+	totpRepo := ProvideTotpRepo(db)
+	recoveryCodeRepo := ProvideRecoveryCodeRepo(db)
+	mfaUsecase := ProvideMfaUsecase(client, totpRepo, recoveryCodeRepo, userRepo, mailAdapter, config)
+	accountUsecase := ProvideAccountUsecase(logrusLogger, userRepo, mailAdapter, customerAdapter, tokenUsecase, config)
+	userIdentityRepo := ProvideUserIdentityRepo(db)
+	exportUsecase := ProvideExportUsecase(userRepo, codeRepo, userIdentityRepo, tokenUsecase)
+	userHandler := ProvideUserHandler(baseHandler, tokenUsecase, authUsecase, mfaUsecase, lockoutUsecase, accountUsecase, exportUsecase)
 	tokenHandler := ProvideTokenHandler(baseHandler, tokenUsecase)
-	server := ProvideHttpServer(logrusLogger, config, userHandler, tokenHandler)
-	app := ProvideApp(logrusLogger, config, server)
+	sessionHandler := ProvideSessionHandler(baseHandler, tokenUsecase)
+	clientRepo := ProvideClientRepo(db)
+	oAuthUsecase := ProvideOAuthUsecase(client, clientRepo, userRepo, tokenUsecase, config)
+	oAuthHandler := ProvideOAuthHandler(baseHandler, oAuthUsecase, authUsecase, mfaUsecase, lockoutUsecase, accountUsecase)
+	v := ProvideIdentityProviders(config)
+	identityUsecase := ProvideIdentityUsecase(userRepo, userIdentityRepo, customerAdapter, v)
+	identityHandler := ProvideIdentityHandler(baseHandler, tokenUsecase, identityUsecase, mfaUsecase, accountUsecase)
+	mfaHandler := ProvideMfaHandler(baseHandler, tokenUsecase, mfaUsecase, accountUsecase)
+	passkeyRepo := ProvidePasskeyRepo(db)
+	passkeyUsecase := ProvidePasskeyUsecase(client, passkeyRepo, userRepo, config)
+	passkeyHandler := ProvidePasskeyHandler(baseHandler, tokenUsecase, passkeyUsecase, accountUsecase)
+	limiter := ProvideRateLimiter(client)
+	server := ProvideHttpServer(logrusLogger, tokenUsecase, userHandler, tokenHandler, sessionHandler, oAuthHandler, identityHandler, mfaHandler, passkeyHandler, limiter, config)
+	app := ProvideApp(logrusLogger, config, server, accountUsecase)
 	return app
 }
 
-func ProvideApp(log *logrus.Logger, cfg *config.Config, httpServer *HttpServer.Server) *App {
-	app := NewApp(log, cfg, httpServer)
+func ProvideApp(log *logrus.Logger, cfg *config.Config, httpServer *HttpServer.Server, accountUsecase *usecases.AccountUsecase) *App {
+	app := NewApp(log, cfg, httpServer, accountUsecase)
 	return app
 }
 
@@ -63,8 +94,8 @@ func ProvideConfig() *config.Config {
 	return configConfig
 }
 
-func ProvideUserHandler(baseHandler *handlers.BaseHandler, tokenUsecase handlers.TokenUsecase, authUsecase handlers.AuthUsecase) *handlers.UserHandler {
-	userHandler := handlers.NewUserHandler(baseHandler, tokenUsecase, authUsecase)
+func ProvideUserHandler(baseHandler *handlers.BaseHandler, tokenUsecase handlers.TokenUsecase, authUsecase handlers.AuthUsecase, mfaUsecase handlers.MfaUsecase, lockoutUsecase handlers.LockoutUsecase, accountUsecase handlers.AccountUsecase, exportUsecase handlers.ExportUsecase) *handlers.UserHandler {
+	userHandler := handlers.NewUserHandler(baseHandler, tokenUsecase, authUsecase, mfaUsecase, lockoutUsecase, accountUsecase, exportUsecase)
 	return userHandler
 }
 
@@ -73,15 +104,54 @@ func ProvideTokenHandler(baseHandler *handlers.BaseHandler, tokenUsecase handler
 	return tokenHandler
 }
 
+func ProvideSessionHandler(baseHandler *handlers.BaseHandler, tokenUsecase handlers.TokenUsecase) *handlers.SessionHandler {
+	sessionHandler := handlers.NewSessionHandler(baseHandler, tokenUsecase)
+	return sessionHandler
+}
+
+func ProvideOAuthHandler(baseHandler *handlers.BaseHandler, oauthUsecase handlers.OAuthUsecase, authUsecase handlers.AuthUsecase, mfaUsecase handlers.MfaUsecase, lockoutUsecase handlers.LockoutUsecase, accountUsecase handlers.AccountUsecase) *handlers.OAuthHandler {
+	oAuthHandler := handlers.NewOAuthHandler(baseHandler, oauthUsecase, authUsecase, mfaUsecase, lockoutUsecase, accountUsecase)
+	return oAuthHandler
+}
+
 func ProvideUserRepo(db *gorm.DB) *pg.UserRepo {
 	userRepo := pg.NewUserRepo(db)
 	return userRepo
 }
 
+func ProvideSigningKeyRepo(db *gorm.DB) *pg.SigningKeyRepo {
+	signingKeyRepo := pg.NewSigningKeyRepo(db)
+	return signingKeyRepo
+}
+
+func ProvideClientRepo(db *gorm.DB) *pg.ClientRepo {
+	clientRepo := pg.NewClientRepo(db)
+	return clientRepo
+}
+
 // wire.go:
 
-func ProvideHttpServer(log *logrus.Logger, cfg *config.Config, userHandler *handlers.UserHandler, tokenHandler *handlers.TokenHandler) *HttpServer.Server {
-	return HttpServer.NewServer(log, cfg.AccessSecret, userHandler, tokenHandler)
+func ProvideHttpServer(log *logrus.Logger, tokenUsecase handlers.TokenUsecase, userHandler *handlers.UserHandler, tokenHandler *handlers.TokenHandler, sessionHandler *handlers.SessionHandler, oauthHandler *handlers.OAuthHandler, identityHandler *handlers.IdentityHandler, mfaHandler *handlers.MfaHandler, passkeyHandler *handlers.PasskeyHandler, rateLimiter *RateLimiter.Limiter, cfg *config.Config) *HttpServer.Server {
+	rules := cfg.RateLimits
+	if rules == "" {
+		rules = RateLimiter.DefaultRules
+	}
+	rateLimitRules, err := RateLimiter.ParseRules(rules)
+	if err != nil {
+		panic(err)
+	}
+	var trustedProxies []*net.IPNet
+	for _, cidr := range strings.Split(cfg.TrustedProxies, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		trustedProxies = append(trustedProxies, ipNet)
+	}
+	return HttpServer.NewServer(log, tokenUsecase, userHandler, tokenHandler, sessionHandler, oauthHandler, identityHandler, mfaHandler, passkeyHandler, rateLimiter, rateLimitRules, trustedProxies)
 }
 
 func ProvideLogrusEntry(log *logger.Logger) *logrus.Entry {
@@ -94,12 +164,22 @@ func ProvideLogrusLogger(log *logger.Logger) *logrus.Logger {
 
 func ProvideGormPostgres(e *logrus.Entry, cfg *config.Config) *gorm.DB {
 	db := GormPostgres.NewClient(e, cfg.PostgresDSN)
-	if err := db.AutoMigrate(&models.User{}, &models.Code{}); err != nil {
+	if err := db.AutoMigrate(&models.User{}, &models.Code{}, &pg.SigningKey{}, &pg.Client{}, &pg.UserIdentity{}, &pg.Totp{}, &pg.RecoveryCode{}, &pg.Passkey{}); err != nil {
+		panic(err)
+	}
+	if err := pg.MigrateGoogleIds(db); err != nil {
+		panic(err)
+	}
+	if err := pg.MigrateActiveCodes(db); err != nil {
 		panic(err)
 	}
 	return db
 }
 
+func ProvideRateLimiter(redisClient *redis.Client) *RateLimiter.Limiter {
+	return RateLimiter.NewLimiter(redisClient)
+}
+
 func ProvideRedisClient(log *logger.Logger, cfg *config.Config) *redis.Client {
 	return RedisClient.NewRedisClient(log, cfg.RedisDSN, cfg.RedisPassword)
 }
@@ -108,18 +188,158 @@ func ProvideBaseHandler(log *logrus.Logger, cfg *config.Config) *handlers.BaseHa
 	return handlers.NewBaseHandler(log, cfg.Mode)
 }
 
-func ProvideAuthUsecase(codeRepo usecases.CodeRepository, userRepo usecases.UserRepository, checkmailRepo usecases.CheckmailAdapter, mailRepo usecases.MailAdapter, customerRepo usecases.CustomerAdapter, cfg *config.Config) *usecases.AuthUsecase {
-	return usecases.NewAuthUsecase(codeRepo, userRepo, checkmailRepo, mailRepo, customerRepo, cfg.CodeExpMinutes)
+func ProvideIdentityHandler(baseHandler *handlers.BaseHandler, tokenUsecase handlers.TokenUsecase, identityUsecase handlers.IdentityUsecase, mfaUsecase handlers.MfaUsecase, accountUsecase handlers.AccountUsecase) *handlers.IdentityHandler {
+	identityHandler := handlers.NewIdentityHandler(baseHandler, tokenUsecase, identityUsecase, mfaUsecase, accountUsecase)
+	return identityHandler
+}
+
+func ProvideMfaHandler(baseHandler *handlers.BaseHandler, tokenUsecase handlers.TokenUsecase, mfaUsecase handlers.MfaUsecase, accountUsecase handlers.AccountUsecase) *handlers.MfaHandler {
+	mfaHandler := handlers.NewMfaHandler(baseHandler, tokenUsecase, mfaUsecase, accountUsecase)
+	return mfaHandler
+}
+
+func ProvidePasskeyHandler(baseHandler *handlers.BaseHandler, tokenUsecase handlers.TokenUsecase, passkeyUsecase handlers.PasskeyUsecase, accountUsecase handlers.AccountUsecase) *handlers.PasskeyHandler {
+	passkeyHandler := handlers.NewPasskeyHandler(baseHandler, tokenUsecase, passkeyUsecase, accountUsecase)
+	return passkeyHandler
 }
 
-func ProvideTokenUsecase(redisClient *redis.Client, cfg *config.Config) *usecases.TokenUsecase {
-	return usecases.NewTokenUsecase(redisClient, cfg.AccessSecret, cfg.RefreshSecret, cfg.AccessExpMinutes, cfg.RefreshExpMinutes)
+func ProvideAuthUsecase(codeRepo usecases.CodeRepository, userRepo usecases.UserRepository, checkmailRepo usecases.CheckmailAdapter, mailRepo usecases.MailAdapter, customerRepo usecases.CustomerAdapter, tokenUsecase *usecases.TokenUsecase, lockoutUsecase *usecases.LockoutUsecase, cfg *config.Config) *usecases.AuthUsecase {
+	codeLength, codeAlphabet := cfg.CodeLength, cfg.CodeAlphabet
+	if codeLength <= 0 {
+		codeLength = 6
+	}
+	if len(codeAlphabet) < 2 {
+		codeAlphabet = "0123456789"
+	}
+	codeKey, err := base64.StdEncoding.DecodeString(cfg.CodeHmacKey)
+	if err != nil || len(codeKey) < 32 {
+		panic("code hmac key should be base64 encoded and at least 32 bytes long")
+	}
+	resendCooldown, resendDailyLimit := cfg.CodeResendCooldown, cfg.CodeResendDailyLimit
+	if resendCooldown <= 0 {
+		resendCooldown = 60
+	}
+	if resendDailyLimit <= 0 {
+		resendDailyLimit = 5
+	}
+	return usecases.NewAuthUsecase(codeRepo, userRepo, checkmailRepo, mailRepo, customerRepo, tokenUsecase, lockoutUsecase, cfg.CodeExpMinutes, codeLength, codeAlphabet, codeKey, resendCooldown, resendDailyLimit)
+}
+
+func ProvideTokenUsecase(log *logrus.Logger, redisClient *redis.Client, signingKeyRepo usecases.SigningKeyRepository, cfg *config.Config) *usecases.TokenUsecase {
+	keyEncryptor, err := encryptor.NewEncryptor(cfg.JwtKeysEncryptionKey)
+	if err != nil {
+		panic(err)
+	}
+	// service tokens could not be revoked by signing out, so they are short-lived regardless of the config
+	serviceExpMinutes := cfg.ServiceTokenExpMinutes
+	if serviceExpMinutes <= 0 {
+		serviceExpMinutes = 15
+	}
+	if serviceExpMinutes > 60 {
+		serviceExpMinutes = 60
+	}
+	tokenUsecase := usecases.NewTokenUsecase(log, redisClient, signingKeyRepo, keyEncryptor, cfg.AccessExpMinutes, cfg.RefreshExpMinutes, serviceExpMinutes)
+	if err := tokenUsecase.EncryptSigningKeys(); err != nil {
+		panic(err)
+	}
+	keys, err := jwk.LoadDir(cfg.JwtKeysDir)
+	if err != nil {
+		panic(err)
+	}
+	if err := tokenUsecase.ImportSigningKeys(keys, cfg.JwtActiveKid); err != nil {
+		panic(err)
+	}
+	return tokenUsecase
+}
+
+func ProvideOAuthUsecase(redisClient *redis.Client, clientRepo usecases.ClientRepository, userRepo usecases.UserRepository, tokenUsecase *usecases.TokenUsecase, cfg *config.Config) *usecases.OAuthUsecase {
+	clients := make(map[string]string)
+	for _, client := range strings.Split(cfg.IntrospectionClients, ",") {
+		if clientId, secretHash, ok := strings.Cut(client, ":"); ok {
+			// secrets are never kept in config, only their SHA-256 hashes
+			if hash, err := hex.DecodeString(secretHash); err != nil || len(hash) != sha256.Size {
+				panic(fmt.Sprintf("secret of introspection client %s should be a hex encoded SHA-256 hash", clientId))
+			}
+			clients[clientId] = strings.ToLower(secretHash)
+		}
+	}
+	return usecases.NewOAuthUsecase(redisClient, clientRepo, userRepo, tokenUsecase, clients, cfg.OidcIssuer, cfg.AccessExpMinutes)
+}
+
+func ProvideIdentityUsecase(userRepo usecases.UserRepository, identityRepo usecases.UserIdentityRepository, customerRepo usecases.CustomerAdapter, providers []usecases.IdentityProvider) *usecases.IdentityUsecase {
+	identityUsecase := usecases.NewIdentityUsecase(userRepo, identityRepo, customerRepo, providers)
+	return identityUsecase
+}
+
+func ProvideMfaUsecase(redisClient *redis.Client, totpRepo usecases.TotpRepository, recoveryCodeRepo usecases.RecoveryCodeRepository, userRepo usecases.UserRepository, mailRepo usecases.MailAdapter, cfg *config.Config) *usecases.MfaUsecase {
+	mfaEncryptor, err := encryptor.NewEncryptor(cfg.MfaEncryptionKey)
+	if err != nil {
+		panic(err)
+	}
+	return usecases.NewMfaUsecase(redisClient, totpRepo, recoveryCodeRepo, userRepo, mailRepo, mfaEncryptor, cfg.TotpIssuer)
+}
+
+func ProvideLockoutUsecase(redisClient *redis.Client, userRepo usecases.UserRepository, mailRepo usecases.MailAdapter, cfg *config.Config) *usecases.LockoutUsecase {
+	delayAfter, lockThreshold, lockMinutes := cfg.SignInDelayAfter, cfg.SignInLockThreshold, cfg.SignInLockMinutes
+	if delayAfter <= 0 {
+		delayAfter = 3
+	}
+	if lockThreshold <= 0 {
+		lockThreshold = 10
+	}
+	if lockMinutes <= 0 {
+		lockMinutes = 15
+	}
+	return usecases.NewLockoutUsecase(redisClient, userRepo, mailRepo, delayAfter, lockThreshold, lockMinutes)
+}
+
+func ProvideAccountUsecase(log *logrus.Logger, userRepo usecases.UserRepository, mailRepo usecases.MailAdapter, customerRepo usecases.CustomerAdapter, tokenUsecase *usecases.TokenUsecase, cfg *config.Config) *usecases.AccountUsecase {
+	graceDays := cfg.AccountDeletionDays
+	if graceDays <= 0 {
+		graceDays = 14
+	}
+	return usecases.NewAccountUsecase(log, userRepo, mailRepo, customerRepo, tokenUsecase, graceDays)
+}
+
+func ProvideExportUsecase(userRepo usecases.UserRepository, codeRepo usecases.CodeRepository, userIdentityRepo usecases.UserIdentityRepository, tokenUsecase *usecases.TokenUsecase) *usecases.ExportUsecase {
+	exportUsecase := usecases.NewExportUsecase(userRepo, codeRepo, userIdentityRepo, tokenUsecase)
+	return exportUsecase
+}
+
+func ProvidePasskeyUsecase(redisClient *redis.Client, passkeyRepo usecases.PasskeyRepository, userRepo usecases.UserRepository, cfg *config.Config) *usecases.PasskeyUsecase {
+	var origins []string
+	for _, origin := range strings.Split(cfg.WebauthnOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return usecases.NewPasskeyUsecase(redisClient, passkeyRepo, userRepo, cfg.WebauthnRpId, cfg.WebauthnRpName, origins)
 }
 
 func ProvideCodeRepo(db *gorm.DB, cfg *config.Config) *pg.CodeRepo {
 	return pg.NewCodeRepo(db, cfg.CodeExpMinutes)
 }
 
+func ProvideUserIdentityRepo(db *gorm.DB) *pg.UserIdentityRepo {
+	userIdentityRepo := pg.NewUserIdentityRepo(db)
+	return userIdentityRepo
+}
+
+func ProvideTotpRepo(db *gorm.DB) *pg.TotpRepo {
+	totpRepo := pg.NewTotpRepo(db)
+	return totpRepo
+}
+
+func ProvideRecoveryCodeRepo(db *gorm.DB) *pg.RecoveryCodeRepo {
+	recoveryCodeRepo := pg.NewRecoveryCodeRepo(db)
+	return recoveryCodeRepo
+}
+
+func ProvidePasskeyRepo(db *gorm.DB) *pg.PasskeyRepo {
+	passkeyRepo := pg.NewPasskeyRepo(db)
+	return passkeyRepo
+}
+
 func ProvideCheckmailRepo(cfg *config.Config) *RpcRepo.CheckmailAdapter {
 	rpcClient := RpcClient.NewClient("tcp", cfg.CheckmailServiceRPCAddr)
 	return RpcRepo.NewCheckmailAdapter(rpcClient)
@@ -134,3 +354,38 @@ func ProvideCustomerRepo(cfg *config.Config) *RpcRepo.CustomerAdapter {
 	rpcClient := RpcClient.NewClient("tcp", cfg.CustomerServiceRPCAddr)
 	return RpcRepo.NewCustomerAdapter(rpcClient)
 }
+
+func ProvideIdentityProviders(cfg *config.Config) []usecases.IdentityProvider {
+	var providers []usecases.IdentityProvider
+	if cfg.GoogleClientId != "" {
+		providers = append(providers, OidcAdapter.NewGoogleProvider(cfg.GoogleClientId, cfg.GoogleJwksUrl))
+	}
+	if cfg.GithubClientId != "" {
+		providers = append(providers, OidcAdapter.NewGitHubProvider(cfg.GithubClientId, cfg.GithubClientSecret, cfg.GithubOAuthUrl, cfg.GithubApiUrl))
+	}
+	if cfg.MicrosoftClientId != "" {
+		var emailDomains []string
+		for _, domain := range strings.Split(cfg.MicrosoftEmailDomains, ",") {
+			if domain = strings.TrimSpace(domain); domain != "" {
+				emailDomains = append(emailDomains, domain)
+			}
+		}
+		providers = append(providers, OidcAdapter.NewMicrosoftProvider(cfg.MicrosoftClientId, cfg.MicrosoftTenant, emailDomains))
+	}
+	if cfg.OidcProviders != "" {
+		var oidcProviders []struct {
+			Name         string   `json:"name"`
+			Issuer       string   `json:"issuer"`
+			ClientId     string   `json:"clientId"`
+			JwksUrl      string   `json:"jwksUrl"`
+			EmailDomains []string `json:"emailDomains"`
+		}
+		if err := json.Unmarshal([]byte(cfg.OidcProviders), &oidcProviders); err != nil {
+			panic(err)
+		}
+		for _, p := range oidcProviders {
+			providers = append(providers, OidcAdapter.NewProvider(p.Name, p.Issuer, p.ClientId, p.JwksUrl, p.EmailDomains))
+		}
+	}
+	return providers
+}
@@ -14,11 +14,41 @@ type Config struct {
 	CheckmailServiceRPCAddr string `mapstructure:"CHECKMAIL_SERVICE_RPC_ADDR" required:"true"`
 	MailServiceRPCAddr      string `mapstructure:"MAIL_SERVICE_RPC_ADDR" required:"true"`
 	CustomerServiceRPCAddr  string `mapstructure:"CUSTOMER_SERVICE_RPC_ADDR" required:"true"`
-	AccessSecret            string `mapstructure:"ACCESS_SECRET" required:"true"`
+	JwtKeysDir              string `mapstructure:"JWT_KEYS_DIR" required:"true"`
+	JwtActiveKid            string `mapstructure:"JWT_ACTIVE_KID" required:"true"`
+	JwtKeysEncryptionKey    string `mapstructure:"JWT_KEYS_ENCRYPTION_KEY" required:"true"`
 	AccessExpMinutes        int    `mapstructure:"ACCESS_EXP_MINUTES" required:"true"`
-	RefreshSecret           string `mapstructure:"REFRESH_SECRET" required:"true"`
+	ServiceTokenExpMinutes  int    `mapstructure:"SERVICE_TOKEN_EXP_MINUTES"`
 	RefreshExpMinutes       int    `mapstructure:"REFRESH_EXP_MINUTES" required:"true"`
 	CodeExpMinutes          int    `mapstructure:"CODE_EXP_MINUTES" required:"true"`
+	CodeLength              int    `mapstructure:"CODE_LENGTH"`
+	CodeAlphabet            string `mapstructure:"CODE_ALPHABET"`
+	CodeHmacKey             string `mapstructure:"CODE_HMAC_KEY" required:"true"`
+	CodeResendCooldown      int    `mapstructure:"CODE_RESEND_COOLDOWN_SECONDS"`
+	CodeResendDailyLimit    int    `mapstructure:"CODE_RESEND_DAILY_LIMIT"`
+	IntrospectionClients    string `mapstructure:"INTROSPECTION_CLIENTS"`
+	OidcIssuer              string `mapstructure:"OIDC_ISSUER" required:"true"`
+	GoogleClientId          string `mapstructure:"GOOGLE_CLIENT_ID"`
+	GoogleJwksUrl           string `mapstructure:"GOOGLE_JWKS_URL"`
+	GithubClientId          string `mapstructure:"GITHUB_CLIENT_ID"`
+	GithubClientSecret      string `mapstructure:"GITHUB_CLIENT_SECRET"`
+	GithubOAuthUrl          string `mapstructure:"GITHUB_OAUTH_URL"`
+	GithubApiUrl            string `mapstructure:"GITHUB_API_URL"`
+	MicrosoftClientId       string `mapstructure:"MICROSOFT_CLIENT_ID"`
+	MicrosoftTenant         string `mapstructure:"MICROSOFT_TENANT"`
+	MicrosoftEmailDomains   string `mapstructure:"MICROSOFT_EMAIL_DOMAINS"`
+	OidcProviders           string `mapstructure:"OIDC_PROVIDERS"`
+	MfaEncryptionKey        string `mapstructure:"MFA_ENCRYPTION_KEY" required:"true"`
+	TotpIssuer              string `mapstructure:"TOTP_ISSUER" required:"true"`
+	WebauthnRpId            string `mapstructure:"WEBAUTHN_RP_ID" required:"true"`
+	WebauthnRpName          string `mapstructure:"WEBAUTHN_RP_NAME" required:"true"`
+	WebauthnOrigins         string `mapstructure:"WEBAUTHN_ORIGINS" required:"true"`
+	SignInDelayAfter        int    `mapstructure:"SIGN_IN_DELAY_AFTER"`
+	SignInLockThreshold     int    `mapstructure:"SIGN_IN_LOCK_THRESHOLD"`
+	SignInLockMinutes       int    `mapstructure:"SIGN_IN_LOCK_MINUTES"`
+	RateLimits              string `mapstructure:"RATE_LIMITS"`
+	TrustedProxies          string `mapstructure:"TRUSTED_PROXIES"`
+	AccountDeletionDays     int    `mapstructure:"ACCOUNT_DELETION_GRACE_DAYS"`
 }
 
 func NewConfig() *Config {
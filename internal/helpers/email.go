@@ -0,0 +1,35 @@
+package helpers
+
+import (
+	"os"
+	"strings"
+)
+
+// NormalizeEmail lowercases the email and maps Google mail aliases to one address, so an account, a lockout or a rate
+// limit could not be bypassed with another spelling of the same mailbox
+func NormalizeEmail(data string) string {
+	addr := strings.ToLower(strings.TrimSpace(data))
+
+	arrAddr := strings.Split(addr, "@")
+	if len(arrAddr) != 2 {
+		return addr
+	}
+	username := arrAddr[0]
+	domain := arrAddr[1]
+
+	googleDomains := strings.Split(os.Getenv("GOOGLEMAIL_DOMAINS"), ",")
+
+	//checking Google mail aliases
+	if Contains(googleDomains, domain) {
+		//removing all dots from username mail
+		username = strings.ReplaceAll(username, ".", "")
+		//removing all characters after +
+		if strings.Contains(username, "+") {
+			res := strings.Split(username, "+")
+			username = res[0]
+		}
+		addr = username + "@gmail.com"
+	}
+
+	return addr
+}
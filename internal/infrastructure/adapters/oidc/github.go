@@ -0,0 +1,138 @@
+package OidcAdapter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aerosystems/auth-service/internal/models"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GitHubProvider signs users in with GitHub OAuth apps. GitHub is not an OpenID Connect provider, so the authorization
+// code is exchanged for an access token, which is used to get the user from GitHub API.
+type GitHubProvider struct {
+	clientId     string
+	clientSecret string
+	oauthUrl     string
+	apiUrl       string
+	httpClient   *http.Client
+}
+
+const (
+	githubOAuthUrl = "https://github.com"
+	githubApiUrl   = "https://api.github.com"
+)
+
+func NewGitHubProvider(clientId, clientSecret, oauthUrl, apiUrl string) *GitHubProvider {
+	if oauthUrl == "" {
+		oauthUrl = githubOAuthUrl
+	}
+	if apiUrl == "" {
+		apiUrl = githubApiUrl
+	}
+	return &GitHubProvider{
+		clientId:     clientId,
+		clientSecret: clientSecret,
+		oauthUrl:     strings.TrimSuffix(oauthUrl, "/"),
+		apiUrl:       strings.TrimSuffix(apiUrl, "/"),
+		httpClient:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (g *GitHubProvider) Name() string {
+	return "github"
+}
+
+func (g *GitHubProvider) Authenticate(credential *models.ExternalCredential) (*models.ExternalIdentity, error) {
+	if credential.Code == "" {
+		return nil, errors.New("authorization code is required")
+	}
+	accessToken, err := g.exchangeCode(credential.Code, credential.RedirectUri)
+	if err != nil {
+		return nil, err
+	}
+	var user struct {
+		Id int64 `json:"id"`
+	}
+	if err := g.get(accessToken, "/user", &user); err != nil {
+		return nil, err
+	}
+	if user.Id == 0 {
+		return nil, errors.New("github user has no id")
+	}
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := g.get(accessToken, "/user/emails", &emails); err != nil {
+		return nil, err
+	}
+	identity := &models.ExternalIdentity{
+		Provider: g.Name(),
+		Subject:  strconv.FormatInt(user.Id, 10),
+	}
+	for _, email := range emails {
+		if email.Primary {
+			identity.Email = email.Email
+			identity.EmailVerified = email.Verified
+		}
+	}
+	return identity, nil
+}
+
+func (g *GitHubProvider) exchangeCode(code, redirectUri string) (string, error) {
+	form := url.Values{
+		"client_id":     {g.clientId},
+		"client_secret": {g.clientSecret},
+		"code":          {code},
+	}
+	if redirectUri != "" {
+		form.Set("redirect_uri", redirectUri)
+	}
+	req, err := http.NewRequest(http.MethodPost, g.oauthUrl+"/login/oauth/access_token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("could not exchange github code: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	var result struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("could not decode github response: %s", err.Error())
+	}
+	if result.AccessToken == "" {
+		return "", fmt.Errorf("could not exchange github code: %s %s", result.Error, result.ErrorDescription)
+	}
+	return result.AccessToken, nil
+}
+
+func (g *GitHubProvider) get(accessToken, path string, v any) error {
+	req, err := http.NewRequest(http.MethodGet, g.apiUrl+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not get %s from github: %s", path, err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not get %s from github: unexpected status %d", path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
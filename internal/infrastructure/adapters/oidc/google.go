@@ -0,0 +1,14 @@
+package OidcAdapter
+
+const googleJwksUrl = "https://www.googleapis.com/oauth2/v3/certs"
+
+func NewGoogleProvider(clientId, jwksUrl string) *Provider {
+	if jwksUrl == "" {
+		jwksUrl = googleJwksUrl
+	}
+	provider := NewProvider("google", "https://accounts.google.com", clientId, jwksUrl, nil)
+	provider.verifyIssuer = func(claims *idTokenClaims) bool {
+		return claims.Issuer == "accounts.google.com" || claims.Issuer == "https://accounts.google.com"
+	}
+	return provider
+}
@@ -0,0 +1,22 @@
+package OidcAdapter
+
+import "fmt"
+
+const microsoftLoginUrl = "https://login.microsoftonline.com"
+
+// NewMicrosoftProvider creates a provider of Microsoft identity platform. With a tenant id only users of that tenant
+// sign in, and their emails are trusted in emailDomains verified by the tenant only, because the email claim is editable
+// by tenant admins. Multi-tenant apps use common or organizations, then the issuer is checked against the tid claim, and
+// emails are never trusted, as any tenant could put any email.
+func NewMicrosoftProvider(clientId, tenant string, emailDomains []string) *Provider {
+	jwksUrl := fmt.Sprintf("%s/%s/discovery/v2.0/keys", microsoftLoginUrl, tenant)
+	switch tenant {
+	case "common", "organizations", "consumers":
+		provider := NewProvider("microsoft", "", clientId, jwksUrl, nil)
+		provider.verifyIssuer = func(claims *idTokenClaims) bool {
+			return claims.TenantId != "" && claims.Issuer == fmt.Sprintf("%s/%s/v2.0", microsoftLoginUrl, claims.TenantId)
+		}
+		return provider
+	}
+	return NewProvider("microsoft", fmt.Sprintf("%s/%s/v2.0", microsoftLoginUrl, tenant), clientId, jwksUrl, emailDomains)
+}
@@ -0,0 +1,93 @@
+package OidcAdapter
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aerosystems/auth-service/internal/models"
+	"github.com/aerosystems/auth-service/pkg/jwk"
+	"github.com/golang-jwt/jwt"
+	"strings"
+	"time"
+)
+
+const jwksTTL = time.Hour
+
+// Provider signs users in with ID tokens of an OpenID Connect identity provider
+type Provider struct {
+	name         string
+	clientId     string
+	jwks         *jwk.RemoteSet
+	verifyIssuer func(claims *idTokenClaims) bool
+	emailDomains []string
+}
+
+// NewProvider creates a generic OpenID Connect provider. Emails are verified by the email_verified claim of the issuer.
+// Set emailDomains when the issuer does not put this claim, but owns these domains and controls emails of its users in
+// them, like a corporate identity provider. Emails in other domains are never trusted without the claim.
+func NewProvider(name, issuer, clientId, jwksUrl string, emailDomains []string) *Provider {
+	return &Provider{
+		name:     name,
+		clientId: clientId,
+		jwks:     jwk.NewRemoteSet(jwksUrl, jwksTTL),
+		verifyIssuer: func(claims *idTokenClaims) bool {
+			return claims.Issuer == issuer
+		},
+		emailDomains: emailDomains,
+	}
+}
+
+type idTokenClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	TenantId      string `json:"tid"`
+	jwt.StandardClaims
+}
+
+func (p *Provider) Name() string {
+	return p.name
+}
+
+// Authenticate checks the signature, issuer, audience and expiration of the ID token
+func (p *Provider) Authenticate(credential *models.ExternalCredential) (*models.ExternalIdentity, error) {
+	if credential.IdToken == "" {
+		return nil, errors.New("id token is required")
+	}
+	claims := &idTokenClaims{}
+	token, err := jwt.ParseWithClaims(credential.IdToken, claims, p.jwks.Keyfunc)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("invalid id token")
+	}
+	if !claims.VerifyAudience(p.clientId, true) {
+		return nil, errors.New("id token was issued to another client")
+	}
+	if !p.verifyIssuer(claims) {
+		return nil, fmt.Errorf("id token was not issued by %s", p.name)
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("id token has no subject")
+	}
+	return &models.ExternalIdentity{
+		Provider:      p.name,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified || p.ownsEmailDomain(claims.Email),
+	}, nil
+}
+
+// ownsEmailDomain reports whether the email belongs to one of the domains owned by the issuer
+func (p *Provider) ownsEmailDomain(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, d := range p.emailDomains {
+		if strings.ToLower(d) == domain {
+			return true
+		}
+	}
+	return false
+}
@@ -19,6 +19,14 @@ type CustomerRPCPayload struct {
 	Uuid uuid.UUID
 }
 
+func (c *CustomerAdapter) DeleteCustomer(uuid uuid.UUID) error {
+	var result string
+	if err := c.rpcClient.Call("Server.DeleteCustomer", CustomerRPCPayload{Uuid: uuid}, &result); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (c *CustomerAdapter) CreateCustomer() (uuid.UUID, error) {
 	result := CustomerRPCPayload{}
 	if err := c.rpcClient.Call("Server.CreateCustomer", "", &result); err != nil {
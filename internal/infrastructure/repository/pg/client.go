@@ -0,0 +1,103 @@
+package pg
+
+import (
+	"errors"
+	"github.com/aerosystems/auth-service/internal/models"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+type ClientRepo struct {
+	db *gorm.DB
+}
+
+func NewClientRepo(db *gorm.DB) *ClientRepo {
+	return &ClientRepo{
+		db: db,
+	}
+}
+
+type Client struct {
+	Id           int       `gorm:"primaryKey;unique;autoIncrement"`
+	ClientId     string    `gorm:"unique"`
+	SecretHash   string    `gorm:"<-"`
+	Name         string    `gorm:"<-"`
+	RedirectUris string    `gorm:"<-"`
+	Scopes       string    `gorm:"<-"`
+	GrantTypes   string    `gorm:"<-"`
+	CreatedAt    time.Time `gorm:"autoCreateTime"`
+	UpdatedAt    time.Time `gorm:"autoUpdateTime"`
+}
+
+func (c *Client) ToModel() *models.Client {
+	return &models.Client{
+		Id:           c.Id,
+		ClientId:     c.ClientId,
+		SecretHash:   c.SecretHash,
+		Name:         c.Name,
+		RedirectUris: strings.Fields(c.RedirectUris),
+		Scopes:       strings.Fields(c.Scopes),
+		GrantTypes:   strings.Fields(c.GrantTypes),
+		CreatedAt:    c.CreatedAt,
+		UpdatedAt:    c.UpdatedAt,
+	}
+}
+
+func ModelToClientPg(client *models.Client) *Client {
+	return &Client{
+		Id:           client.Id,
+		ClientId:     client.ClientId,
+		SecretHash:   client.SecretHash,
+		Name:         client.Name,
+		RedirectUris: strings.Join(client.RedirectUris, " "),
+		Scopes:       strings.Join(client.Scopes, " "),
+		GrantTypes:   strings.Join(client.GrantTypes, " "),
+		CreatedAt:    client.CreatedAt,
+		UpdatedAt:    client.UpdatedAt,
+	}
+}
+
+func (r *ClientRepo) GetByClientId(ClientId string) (*models.Client, error) {
+	var client Client
+	result := r.db.Where("client_id = ?", ClientId).First(&client)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return client.ToModel(), nil
+}
+
+func (r *ClientRepo) GetAll() ([]models.Client, error) {
+	var clients []Client
+	result := r.db.Order("created_at").Find(&clients)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	res := make([]models.Client, 0, len(clients))
+	for _, client := range clients {
+		res = append(res, *client.ToModel())
+	}
+	return res, nil
+}
+
+func (r *ClientRepo) Create(client *models.Client) error {
+	clientPg := ModelToClientPg(client)
+	result := r.db.Create(&clientPg)
+	if result.Error != nil {
+		return result.Error
+	}
+	*client = *clientPg.ToModel()
+	return nil
+}
+
+func (r *ClientRepo) Delete(client *models.Client) error {
+	clientPg := ModelToClientPg(client)
+	result := r.db.Delete(&clientPg)
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
@@ -27,6 +27,7 @@ type Code struct {
 	User      User      `gorm:"foreignKey:UserId"`
 	Action    string    `gorm:"<-"`
 	Data      string    `gorm:"<-"`
+	Attempts  int       `gorm:"<-"`
 	IsUsed    bool      `gorm:"<-"`
 	ExpireAt  time.Time `gorm:"<-"`
 	CreatedAt time.Time `gorm:"autoCreateTime"`
@@ -41,6 +42,7 @@ func (c *Code) ToModel() *models.Code {
 		User:      *c.User.ToModel(),
 		Action:    models.CodeFromString(c.Action),
 		Data:      c.Data,
+		Attempts:  c.Attempts,
 		IsUsed:    c.IsUsed,
 		ExpireAt:  c.ExpireAt,
 		CreatedAt: c.CreatedAt,
@@ -53,8 +55,10 @@ func ModelToCodePg(code *models.Code) *Code {
 		Id:        code.Id,
 		Code:      code.Code,
 		UserId:    code.UserId,
+		User:      *ModelToUserPg(&code.User),
 		Action:    code.Action.String(),
 		Data:      code.Data,
+		Attempts:  code.Attempts,
 		IsUsed:    code.IsUsed,
 		ExpireAt:  code.ExpireAt,
 		CreatedAt: code.CreatedAt,
@@ -77,11 +81,37 @@ func (r *CodeRepo) GetById(Id int) (*models.Code, error) {
 func (r *CodeRepo) Create(code *models.Code) error {
 	code.ExpireAt = time.Now().Add(time.Minute * time.Duration(r.codeExpMinutes))
 	codePg := ModelToCodePg(code)
-	result := r.db.Create(&codePg)
+	result := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&codePg)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return models.ErrCodeNotUnique
+	}
+	*code = *codePg.ToModel()
+	return nil
+}
+
+// MigrateActiveCodes makes values of active codes unique. Expired codes, duplicates left by the former generator and
+// codes stored in plaintext rather than as a hex encoded digest are invalidated before the partial unique index is
+// created.
+func MigrateActiveCodes(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Exec(`UPDATE codes SET is_used = true WHERE is_used = false
+			AND (expire_at <= now() OR length(code) <> 64 OR id NOT IN (SELECT max(id) FROM codes WHERE is_used = false GROUP BY code))`)
+		if result.Error != nil {
+			return result.Error
+		}
+		return tx.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_codes_active_code ON codes (code) WHERE is_used = false").Error
+	})
+}
+
+// InvalidateExpired marks expired codes as used, so their values could be given to new codes
+func (r *CodeRepo) InvalidateExpired() error {
+	result := r.db.Model(&Code{}).Where("is_used = ? AND expire_at <= ?", false, time.Now()).Update("is_used", true)
 	if result.Error != nil {
 		return result.Error
 	}
-	code = codePg.ToModel()
 	return nil
 }
 
@@ -91,13 +121,14 @@ func (r *CodeRepo) UpdateWithAssociations(code *models.Code) error {
 	if result.Error != nil {
 		return result.Error
 	}
-	code = codePg.ToModel()
+	*code = *codePg.ToModel()
 	return nil
 }
 
-func (r *CodeRepo) GetByCode(value string) (*models.Code, error) {
+// GetByCode returns the code by the digest of its value
+func (r *CodeRepo) GetByCode(digest string) (*models.Code, error) {
 	var codePg Code
-	result := r.db.Preload(clause.Associations).Where("code = ?", value).Find(&codePg)
+	result := r.db.Preload(clause.Associations).Where("code = ?", digest).Find(&codePg)
 	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 		return nil, nil
 	}
@@ -119,12 +150,48 @@ func (r *CodeRepo) GetLastIsActiveCode(UserId int, Action string) (*models.Code,
 	return codePg.ToModel(), nil
 }
 
+// CountCreatedSince returns the number of codes of the action created for the user since the time, used or not
+func (r *CodeRepo) CountCreatedSince(UserId int, Action string, since time.Time) (int64, error) {
+	var count int64
+	result := r.db.Model(&Code{}).Where("user_id = ? AND action = ? AND created_at >= ?", UserId, Action, since).Count(&count)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return count, nil
+}
+
+// GetActiveByUserId returns codes of the user which are neither used nor expired
+func (r *CodeRepo) GetActiveByUserId(UserId int) ([]models.Code, error) {
+	var codesPg []Code
+	result := r.db.Preload(clause.Associations).Where("user_id = ? AND is_used = ? AND expire_at > ?", UserId, false, time.Now()).Find(&codesPg)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	codes := make([]models.Code, 0, len(codesPg))
+	for _, codePg := range codesPg {
+		codes = append(codes, *codePg.ToModel())
+	}
+	return codes, nil
+}
+
+// IncrementAttempts counts a wrong guess of the code and invalidates it once maxAttempts is reached
+func (r *CodeRepo) IncrementAttempts(code *models.Code, maxAttempts int) error {
+	result := r.db.Model(&Code{}).Where("id = ?", code.Id).Updates(map[string]interface{}{
+		"attempts": gorm.Expr("attempts + 1"),
+		"is_used":  gorm.Expr("is_used OR attempts + 1 >= ?", maxAttempts),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
 func (r *CodeRepo) Update(code *models.Code) error {
 	codePg := ModelToCodePg(code)
 	result := r.db.Save(&codePg)
 	if result.Error != nil {
 		return result.Error
 	}
-	code = codePg.ToModel()
+	*code = *codePg.ToModel()
 	return nil
 }
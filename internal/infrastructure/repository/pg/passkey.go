@@ -0,0 +1,126 @@
+package pg
+
+import (
+	"encoding/base64"
+	"errors"
+	"github.com/aerosystems/auth-service/internal/models"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+type PasskeyRepo struct {
+	db *gorm.DB
+}
+
+func NewPasskeyRepo(db *gorm.DB) *PasskeyRepo {
+	return &PasskeyRepo{
+		db: db,
+	}
+}
+
+type Passkey struct {
+	Id           int        `gorm:"primaryKey;unique;autoIncrement"`
+	UserId       int        `gorm:"index"`
+	CredentialId string     `gorm:"unique"`
+	PublicKey    []byte     `gorm:"<-"`
+	SignCount    int64      `gorm:"<-"`
+	Transports   string     `gorm:"<-"`
+	Name         string     `gorm:"<-"`
+	LastUsedAt   *time.Time `gorm:"<-"`
+	CreatedAt    time.Time  `gorm:"autoCreateTime"`
+	UpdatedAt    time.Time  `gorm:"autoUpdateTime"`
+}
+
+func (p *Passkey) ToModel() *models.Passkey {
+	credentialId, _ := base64.RawURLEncoding.DecodeString(p.CredentialId)
+	return &models.Passkey{
+		Id:           p.Id,
+		UserId:       p.UserId,
+		CredentialId: credentialId,
+		PublicKey:    p.PublicKey,
+		SignCount:    uint32(p.SignCount),
+		Transports:   strings.Fields(p.Transports),
+		Name:         p.Name,
+		LastUsedAt:   p.LastUsedAt,
+		CreatedAt:    p.CreatedAt,
+		UpdatedAt:    p.UpdatedAt,
+	}
+}
+
+func ModelToPasskeyPg(passkey *models.Passkey) *Passkey {
+	return &Passkey{
+		Id:           passkey.Id,
+		UserId:       passkey.UserId,
+		CredentialId: base64.RawURLEncoding.EncodeToString(passkey.CredentialId),
+		PublicKey:    passkey.PublicKey,
+		SignCount:    int64(passkey.SignCount),
+		Transports:   strings.Join(passkey.Transports, " "),
+		Name:         passkey.Name,
+		LastUsedAt:   passkey.LastUsedAt,
+		CreatedAt:    passkey.CreatedAt,
+		UpdatedAt:    passkey.UpdatedAt,
+	}
+}
+
+func (r *PasskeyRepo) GetByCredentialId(CredentialId []byte) (*models.Passkey, error) {
+	var passkey Passkey
+	result := r.db.Where("credential_id = ?", base64.RawURLEncoding.EncodeToString(CredentialId)).First(&passkey)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return passkey.ToModel(), nil
+}
+
+func (r *PasskeyRepo) GetByUserId(UserId int) ([]models.Passkey, error) {
+	var passkeys []Passkey
+	result := r.db.Where("user_id = ?", UserId).Order("id").Find(&passkeys)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	res := make([]models.Passkey, 0, len(passkeys))
+	for _, passkey := range passkeys {
+		res = append(res, *passkey.ToModel())
+	}
+	return res, nil
+}
+
+func (r *PasskeyRepo) Create(passkey *models.Passkey) error {
+	passkeyPg := ModelToPasskeyPg(passkey)
+	result := r.db.Create(&passkeyPg)
+	if result.Error != nil {
+		return result.Error
+	}
+	*passkey = *passkeyPg.ToModel()
+	return nil
+}
+
+// UpdateSignCount stores the counter of the last assertion. A counter which did not move forward is rejected, so
+// concurrent requests could not use the same assertion twice; authenticators without a counter always send zero.
+func (r *PasskeyRepo) UpdateSignCount(passkey *models.Passkey, signCount uint32) (bool, error) {
+	now := time.Now()
+	result := r.db.Model(&Passkey{}).
+		Where("id = ? AND (sign_count < ? OR ? = 0)", passkey.Id, int64(signCount), int64(signCount)).
+		Updates(map[string]interface{}{"sign_count": int64(signCount), "last_used_at": now})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return false, nil
+	}
+	passkey.SignCount = signCount
+	passkey.LastUsedAt = &now
+	return true, nil
+}
+
+func (r *PasskeyRepo) Delete(passkey *models.Passkey) error {
+	passkeyPg := ModelToPasskeyPg(passkey)
+	result := r.db.Delete(&passkeyPg)
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
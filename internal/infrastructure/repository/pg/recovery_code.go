@@ -0,0 +1,87 @@
+package pg
+
+import (
+	"github.com/aerosystems/auth-service/internal/models"
+	"gorm.io/gorm"
+	"time"
+)
+
+type RecoveryCodeRepo struct {
+	db *gorm.DB
+}
+
+func NewRecoveryCodeRepo(db *gorm.DB) *RecoveryCodeRepo {
+	return &RecoveryCodeRepo{
+		db: db,
+	}
+}
+
+type RecoveryCode struct {
+	Id        int       `gorm:"primaryKey;unique;autoIncrement"`
+	UserId    int       `gorm:"index"`
+	CodeHash  string    `gorm:"<-"`
+	IsUsed    bool      `gorm:"<-"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
+
+func (r *RecoveryCode) ToModel() *models.RecoveryCode {
+	return &models.RecoveryCode{
+		Id:        r.Id,
+		UserId:    r.UserId,
+		CodeHash:  r.CodeHash,
+		IsUsed:    r.IsUsed,
+		CreatedAt: r.CreatedAt,
+		UpdatedAt: r.UpdatedAt,
+	}
+}
+
+func ModelToRecoveryCodePg(code *models.RecoveryCode) *RecoveryCode {
+	return &RecoveryCode{
+		Id:        code.Id,
+		UserId:    code.UserId,
+		CodeHash:  code.CodeHash,
+		IsUsed:    code.IsUsed,
+		CreatedAt: code.CreatedAt,
+		UpdatedAt: code.UpdatedAt,
+	}
+}
+
+func (r *RecoveryCodeRepo) GetUnusedByUserId(UserId int) ([]models.RecoveryCode, error) {
+	var codes []RecoveryCode
+	result := r.db.Where("user_id = ? AND is_used = ?", UserId, false).Find(&codes)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	res := make([]models.RecoveryCode, 0, len(codes))
+	for _, code := range codes {
+		res = append(res, *code.ToModel())
+	}
+	return res, nil
+}
+
+// Replace drops the previous set of codes of the user and stores the new one
+func (r *RecoveryCodeRepo) Replace(UserId int, codes []models.RecoveryCode) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", UserId).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if len(codes) == 0 {
+			return nil
+		}
+		codesPg := make([]*RecoveryCode, 0, len(codes))
+		for i := range codes {
+			codesPg = append(codesPg, ModelToRecoveryCodePg(&codes[i]))
+		}
+		return tx.Create(&codesPg).Error
+	})
+}
+
+// MarkUsed uses the code only once, it returns false if a concurrent request has already used it
+func (r *RecoveryCodeRepo) MarkUsed(code *models.RecoveryCode) (bool, error) {
+	result := r.db.Model(&RecoveryCode{}).Where("id = ? AND is_used = ?", code.Id, false).Update("is_used", true)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
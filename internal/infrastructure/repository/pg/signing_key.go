@@ -0,0 +1,100 @@
+package pg
+
+import (
+	"errors"
+	"github.com/aerosystems/auth-service/internal/models"
+	"gorm.io/gorm"
+	"time"
+)
+
+type SigningKeyRepo struct {
+	db *gorm.DB
+}
+
+func NewSigningKeyRepo(db *gorm.DB) *SigningKeyRepo {
+	return &SigningKeyRepo{
+		db: db,
+	}
+}
+
+type SigningKey struct {
+	Id                  int       `gorm:"primaryKey;unique;autoIncrement"`
+	Kid                 string    `gorm:"unique"`
+	Alg                 string    `gorm:"<-"`
+	EncryptedPrivateKey string    `gorm:"column:private_key"`
+	Status              string    `gorm:"<-"`
+	ExpireAt            time.Time `gorm:"<-"`
+	CreatedAt           time.Time `gorm:"autoCreateTime"`
+	UpdatedAt           time.Time `gorm:"autoUpdateTime"`
+}
+
+func (k *SigningKey) ToModel() *models.SigningKey {
+	return &models.SigningKey{
+		Id:                  k.Id,
+		Kid:                 k.Kid,
+		Alg:                 k.Alg,
+		EncryptedPrivateKey: k.EncryptedPrivateKey,
+		Status:              models.KeyStatusFromString(k.Status),
+		ExpireAt:            k.ExpireAt,
+		CreatedAt:           k.CreatedAt,
+		UpdatedAt:           k.UpdatedAt,
+	}
+}
+
+func ModelToSigningKeyPg(key *models.SigningKey) *SigningKey {
+	return &SigningKey{
+		Id:                  key.Id,
+		Kid:                 key.Kid,
+		Alg:                 key.Alg,
+		EncryptedPrivateKey: key.EncryptedPrivateKey,
+		Status:              key.Status.String(),
+		ExpireAt:            key.ExpireAt,
+		CreatedAt:           key.CreatedAt,
+		UpdatedAt:           key.UpdatedAt,
+	}
+}
+
+func (r *SigningKeyRepo) GetByKid(Kid string) (*models.SigningKey, error) {
+	var key SigningKey
+	result := r.db.Where("kid = ?", Kid).First(&key)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return key.ToModel(), nil
+}
+
+func (r *SigningKeyRepo) GetAll() ([]models.SigningKey, error) {
+	var keys []SigningKey
+	result := r.db.Order("created_at").Find(&keys)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	res := make([]models.SigningKey, 0, len(keys))
+	for _, key := range keys {
+		res = append(res, *key.ToModel())
+	}
+	return res, nil
+}
+
+func (r *SigningKeyRepo) Create(key *models.SigningKey) error {
+	keyPg := ModelToSigningKeyPg(key)
+	result := r.db.Create(&keyPg)
+	if result.Error != nil {
+		return result.Error
+	}
+	*key = *keyPg.ToModel()
+	return nil
+}
+
+func (r *SigningKeyRepo) Update(key *models.SigningKey) error {
+	keyPg := ModelToSigningKeyPg(key)
+	result := r.db.Save(&keyPg)
+	if result.Error != nil {
+		return result.Error
+	}
+	*key = *keyPg.ToModel()
+	return nil
+}
@@ -0,0 +1,102 @@
+package pg
+
+import (
+	"errors"
+	"github.com/aerosystems/auth-service/internal/models"
+	"gorm.io/gorm"
+	"time"
+)
+
+type TotpRepo struct {
+	db *gorm.DB
+}
+
+func NewTotpRepo(db *gorm.DB) *TotpRepo {
+	return &TotpRepo{
+		db: db,
+	}
+}
+
+type Totp struct {
+	Id              int       `gorm:"primaryKey;unique;autoIncrement"`
+	UserId          int       `gorm:"unique"`
+	EncryptedSecret string    `gorm:"<-"`
+	IsConfirmed     bool      `gorm:"<-"`
+	LastUsedCounter int64     `gorm:"<-"`
+	CreatedAt       time.Time `gorm:"autoCreateTime"`
+	UpdatedAt       time.Time `gorm:"autoUpdateTime"`
+}
+
+func (t *Totp) ToModel() *models.Totp {
+	return &models.Totp{
+		Id:              t.Id,
+		UserId:          t.UserId,
+		EncryptedSecret: t.EncryptedSecret,
+		IsConfirmed:     t.IsConfirmed,
+		LastUsedCounter: t.LastUsedCounter,
+		CreatedAt:       t.CreatedAt,
+		UpdatedAt:       t.UpdatedAt,
+	}
+}
+
+func ModelToTotpPg(totp *models.Totp) *Totp {
+	return &Totp{
+		Id:              totp.Id,
+		UserId:          totp.UserId,
+		EncryptedSecret: totp.EncryptedSecret,
+		IsConfirmed:     totp.IsConfirmed,
+		LastUsedCounter: totp.LastUsedCounter,
+		CreatedAt:       totp.CreatedAt,
+		UpdatedAt:       totp.UpdatedAt,
+	}
+}
+
+func (r *TotpRepo) GetByUserId(UserId int) (*models.Totp, error) {
+	var totp Totp
+	result := r.db.Where("user_id = ?", UserId).First(&totp)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return totp.ToModel(), nil
+}
+
+func (r *TotpRepo) Create(totp *models.Totp) error {
+	totpPg := ModelToTotpPg(totp)
+	result := r.db.Create(&totpPg)
+	if result.Error != nil {
+		return result.Error
+	}
+	*totp = *totpPg.ToModel()
+	return nil
+}
+
+func (r *TotpRepo) Update(totp *models.Totp) error {
+	totpPg := ModelToTotpPg(totp)
+	result := r.db.Save(&totpPg)
+	if result.Error != nil {
+		return result.Error
+	}
+	*totp = *totpPg.ToModel()
+	return nil
+}
+
+// UpdateLastUsedCounter moves the counter forward only, so concurrent requests could not use the same code twice
+func (r *TotpRepo) UpdateLastUsedCounter(totp *models.Totp, counter int64) (bool, error) {
+	result := r.db.Model(&Totp{}).Where("id = ? AND last_used_counter < ?", totp.Id, counter).Update("last_used_counter", counter)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *TotpRepo) Delete(totp *models.Totp) error {
+	totpPg := ModelToTotpPg(totp)
+	result := r.db.Delete(&totpPg)
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
@@ -19,42 +19,45 @@ func NewUserRepo(db *gorm.DB) *UserRepo {
 }
 
 type User struct {
-	Id           int       `gorm:"primaryKey;unique;autoIncrement"`
-	Uuid         string    `gorm:"unique"`
-	Email        string    `gorm:"unique"`
-	PasswordHash string    `gorm:"<-"`
-	Role         string    `gorm:"<-"`
-	IsActive     bool      `gorm:"<-"`
-	GoogleId     string    `gorm:"unique"`
-	CreatedAt    time.Time `gorm:"autoCreateTime"`
-	UpdatedAt    time.Time `gorm:"autoUpdateTime"`
+	Id            int        `gorm:"primaryKey;unique;autoIncrement"`
+	Uuid          string     `gorm:"unique"`
+	Email         string     `gorm:"unique"`
+	PasswordHash  string     `gorm:"<-"`
+	Role          string     `gorm:"<-"`
+	IsActive      bool       `gorm:"<-"`
+	DeleteAt      *time.Time `gorm:"<-"`
+	DeactivatedAt *time.Time `gorm:"<-"`
+	CreatedAt     time.Time  `gorm:"autoCreateTime"`
+	UpdatedAt     time.Time  `gorm:"autoUpdateTime"`
 }
 
 func (u *User) ToModel() *models.User {
 	return &models.User{
-		Id:           u.Id,
-		Uuid:         uuid.MustParse(u.Uuid),
-		Email:        u.Email,
-		PasswordHash: u.PasswordHash,
-		Role:         models.RoleFromString(u.Role),
-		IsActive:     u.IsActive,
-		GoogleId:     u.GoogleId,
-		CreatedAt:    u.CreatedAt,
-		UpdatedAt:    u.UpdatedAt,
+		Id:            u.Id,
+		Uuid:          uuid.MustParse(u.Uuid),
+		Email:         u.Email,
+		PasswordHash:  u.PasswordHash,
+		Role:          models.RoleFromString(u.Role),
+		IsActive:      u.IsActive,
+		DeleteAt:      u.DeleteAt,
+		DeactivatedAt: u.DeactivatedAt,
+		CreatedAt:     u.CreatedAt,
+		UpdatedAt:     u.UpdatedAt,
 	}
 }
 
 func ModelToUserPg(user *models.User) *User {
 	return &User{
-		Id:           user.Id,
-		Uuid:         user.Uuid.String(),
-		Email:        user.Email,
-		PasswordHash: user.PasswordHash,
-		Role:         user.Role.String(),
-		IsActive:     user.IsActive,
-		GoogleId:     user.GoogleId,
-		CreatedAt:    user.CreatedAt,
-		UpdatedAt:    user.UpdatedAt,
+		Id:            user.Id,
+		Uuid:          user.Uuid.String(),
+		Email:         user.Email,
+		PasswordHash:  user.PasswordHash,
+		Role:          user.Role.String(),
+		IsActive:      user.IsActive,
+		DeleteAt:      user.DeleteAt,
+		DeactivatedAt: user.DeactivatedAt,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
 	}
 }
 
@@ -70,6 +73,18 @@ func (r *UserRepo) GetByEmail(Email string) (*models.User, error) {
 	return user.ToModel(), nil
 }
 
+func (r *UserRepo) GetById(Id int) (*models.User, error) {
+	var user User
+	result := r.db.Where("id = ?", Id).First(&user)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return user.ToModel(), nil
+}
+
 func (r *UserRepo) GetByUuid(Uuid uuid.UUID) (*models.User, error) {
 	var user User
 	result := r.db.Where("uuid = ?", Uuid.String()).First(&user)
@@ -88,7 +103,7 @@ func (r *UserRepo) Create(user *models.User) error {
 	if result.Error != nil {
 		return result.Error
 	}
-	user = userPg.ToModel()
+	*user = *userPg.ToModel()
 	return nil
 }
 
@@ -98,15 +113,32 @@ func (r *UserRepo) Update(user *models.User) error {
 	if result.Error != nil {
 		return result.Error
 	}
-	user = userPg.ToModel()
+	*user = *userPg.ToModel()
 	return nil
 }
 
+// Delete removes the user together with codes, identities, second factors and passkeys of the user
 func (r *UserRepo) Delete(user *models.User) error {
-	userPg := ModelToUserPg(user)
-	result := r.db.Delete(&userPg)
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&Code{}, &UserIdentity{}, &Totp{}, &RecoveryCode{}, &Passkey{}} {
+			if err := tx.Where("user_id = ?", user.Id).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(ModelToUserPg(user)).Error
+	})
+}
+
+// GetDueForDeletion returns users whose grace period of the account deletion is over
+func (r *UserRepo) GetDueForDeletion(before time.Time) ([]models.User, error) {
+	var usersPg []User
+	result := r.db.Where("delete_at IS NOT NULL AND delete_at <= ?", before).Find(&usersPg)
 	if result.Error != nil {
-		return result.Error
+		return nil, result.Error
 	}
-	return nil
+	users := make([]models.User, 0, len(usersPg))
+	for _, userPg := range usersPg {
+		users = append(users, *userPg.ToModel())
+	}
+	return users, nil
 }
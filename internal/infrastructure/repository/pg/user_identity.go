@@ -0,0 +1,112 @@
+package pg
+
+import (
+	"errors"
+	"github.com/aerosystems/auth-service/internal/models"
+	"gorm.io/gorm"
+	"time"
+)
+
+type UserIdentityRepo struct {
+	db *gorm.DB
+}
+
+func NewUserIdentityRepo(db *gorm.DB) *UserIdentityRepo {
+	return &UserIdentityRepo{
+		db: db,
+	}
+}
+
+type UserIdentity struct {
+	Id        int       `gorm:"primaryKey;unique;autoIncrement"`
+	UserId    int       `gorm:"uniqueIndex:idx_user_identities_user_provider"`
+	Provider  string    `gorm:"uniqueIndex:idx_user_identities_provider_subject;uniqueIndex:idx_user_identities_user_provider"`
+	Subject   string    `gorm:"uniqueIndex:idx_user_identities_provider_subject"`
+	Email     string    `gorm:"<-"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
+
+func (i *UserIdentity) ToModel() *models.UserIdentity {
+	return &models.UserIdentity{
+		Id:        i.Id,
+		UserId:    i.UserId,
+		Provider:  i.Provider,
+		Subject:   i.Subject,
+		Email:     i.Email,
+		CreatedAt: i.CreatedAt,
+		UpdatedAt: i.UpdatedAt,
+	}
+}
+
+func ModelToUserIdentityPg(identity *models.UserIdentity) *UserIdentity {
+	return &UserIdentity{
+		Id:        identity.Id,
+		UserId:    identity.UserId,
+		Provider:  identity.Provider,
+		Subject:   identity.Subject,
+		Email:     identity.Email,
+		CreatedAt: identity.CreatedAt,
+		UpdatedAt: identity.UpdatedAt,
+	}
+}
+
+// MigrateGoogleIds moves links to Google accounts from the former google_id column of users to user_identities
+func MigrateGoogleIds(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&User{}, "google_id") {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Exec(`INSERT INTO user_identities (user_id, provider, subject, email, created_at, updated_at)
+			SELECT id, 'google', google_id, email, now(), now() FROM users WHERE google_id IS NOT NULL AND google_id <> ''
+			ON CONFLICT DO NOTHING`)
+		if result.Error != nil {
+			return result.Error
+		}
+		return tx.Migrator().DropColumn(&User{}, "google_id")
+	})
+}
+
+func (r *UserIdentityRepo) GetByProviderSubject(Provider, Subject string) (*models.UserIdentity, error) {
+	var identity UserIdentity
+	result := r.db.Where("provider = ? AND subject = ?", Provider, Subject).First(&identity)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return identity.ToModel(), nil
+}
+
+func (r *UserIdentityRepo) GetByUserId(UserId int) ([]models.UserIdentity, error) {
+	var identities []UserIdentity
+	result := r.db.Where("user_id = ?", UserId).Order("created_at").Find(&identities)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	res := make([]models.UserIdentity, 0, len(identities))
+	for _, identity := range identities {
+		res = append(res, *identity.ToModel())
+	}
+	return res, nil
+}
+
+func (r *UserIdentityRepo) Create(identity *models.UserIdentity) error {
+	identityPg := ModelToUserIdentityPg(identity)
+	result := r.db.Create(&identityPg)
+	if result.Error != nil {
+		return result.Error
+	}
+	*identity = *identityPg.ToModel()
+	return nil
+}
+
+func (r *UserIdentityRepo) Delete(identity *models.UserIdentity) error {
+	identityPg := ModelToUserIdentityPg(identity)
+	result := r.db.Delete(&identityPg)
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// Client is an application registered to sign users in with OpenID Connect
+type Client struct {
+	Id           int
+	ClientId     string
+	SecretHash   string
+	Name         string
+	RedirectUris []string
+	Scopes       []string
+	GrantTypes   []string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// IsPublic tells whether the client could not keep a secret, like a SPA or a mobile app
+func (c *Client) IsPublic() bool {
+	return c.SecretHash == ""
+}
+
+// HasGrantType tells whether the client is allowed to get tokens with the grant type. Clients registered without grant
+// types are OpenID Connect clients.
+func (c *Client) HasGrantType(grantType string) bool {
+	grantTypes := c.GrantTypes
+	if len(grantTypes) == 0 {
+		grantTypes = []string{AuthorizationCodeGrantType, RefreshTokenGrantType}
+	}
+	for _, g := range grantTypes {
+		if g == grantType {
+			return true
+		}
+	}
+	return false
+}
@@ -1,9 +1,13 @@
 package models
 
 import (
+	"errors"
 	"time"
 )
 
+// ErrCodeNotUnique is returned when the value of a new code is already taken by an active code
+var ErrCodeNotUnique = errors.New("code is not unique among active codes")
+
 type Code struct {
 	Id        int
 	Code      string
@@ -11,6 +15,7 @@ type Code struct {
 	User      User
 	Action    KindCode
 	Data      string
+	Attempts  int
 	IsUsed    bool
 	ExpireAt  time.Time
 	CreatedAt time.Time
@@ -25,6 +30,8 @@ var (
 	UnknownCode       = KindCode{"unknown"}
 	RegistrationCode  = KindCode{"registration"}
 	ResetPasswordCode = KindCode{"resetPassword"}
+	LoginCode         = KindCode{"login"}
+	ChangeEmailCode   = KindCode{"changeEmail"}
 )
 
 func (k KindCode) String() string {
@@ -37,6 +44,10 @@ func CodeFromString(s string) KindCode {
 		return RegistrationCode
 	case "resetPassword":
 		return ResetPasswordCode
+	case "login":
+		return LoginCode
+	case "changeEmail":
+		return ChangeEmailCode
 	default:
 		return UnknownCode
 	}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// UserExport is the personal data kept about the user, it answers a data subject access request
+type UserExport struct {
+	User         User
+	Identities   []UserIdentity
+	Sessions     []Session
+	LoginHistory []LoginEvent
+	PendingCodes []Code
+	ExportedAt   time.Time
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// ExternalIdentity is the user asserted by an external identity provider
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// ExternalCredential is what the client got from an identity provider: an ID token of OpenID Connect providers, or an
+// authorization code of OAuth 2.0 providers like GitHub
+type ExternalCredential struct {
+	IdToken     string
+	Code        string
+	RedirectUri string
+}
+
+// UserIdentity links a user to an account of an external identity provider
+type UserIdentity struct {
+	Id        int
+	UserId    int
+	Provider  string
+	Subject   string
+	Email     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
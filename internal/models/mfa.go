@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// Totp is the authenticator app enrolled by the user as the second factor. The secret is encrypted at rest.
+type Totp struct {
+	Id              int
+	UserId          int
+	EncryptedSecret string
+	IsConfirmed     bool
+	LastUsedCounter int64
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// TotpEnrollment is shown to the user once to set up an authenticator app
+type TotpEnrollment struct {
+	Secret string
+	Uri    string
+}
+
+// MfaChallenge is the state of a sign in which passed the password and waits for the second factor
+type MfaChallenge struct {
+	UserUuid string `json:"userUuid"`
+}
+
+// RecoveryCode is a single-use code which replaces the authenticator app when the user lost the device. Only its bcrypt hash is stored.
+type RecoveryCode struct {
+	Id        int
+	UserId    int
+	CodeHash  string
+	IsUsed    bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
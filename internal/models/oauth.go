@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+const (
+	AuthorizationCodeGrantType = "authorization_code"
+	RefreshTokenGrantType      = "refresh_token"
+	ClientCredentialsGrantType = "client_credentials"
+)
+
+// AuthorizationCode is a one-time code issued by the authorization endpoint of the OIDC flow
+type AuthorizationCode struct {
+	ClientId      string    `json:"clientId"`
+	RedirectUri   string    `json:"redirectUri"`
+	UserUuid      string    `json:"userUuid"`
+	Scope         string    `json:"scope"`
+	Nonce         string    `json:"nonce"`
+	CodeChallenge string    `json:"codeChallenge"`
+	AuthTime      time.Time `json:"authTime"`
+}
+
+// OAuthError is an error with one of the codes defined by RFC 6749
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func NewOAuthError(code, description string) *OAuthError {
+	return &OAuthError{
+		Code:        code,
+		Description: description,
+	}
+}
+
+func (e *OAuthError) Error() string {
+	return e.Code + ": " + e.Description
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Passkey is a WebAuthn credential of the user, the public key is kept as a COSE_Key
+type Passkey struct {
+	Id           int
+	UserId       int
+	CredentialId []byte
+	PublicKey    []byte
+	SignCount    uint32
+	Transports   []string
+	Name         string
+	LastUsedAt   *time.Time
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// WebAuthnSession is the pending ceremony kept in Redis by its challenge, UserUuid is empty on sign in
+type WebAuthnSession struct {
+	Ceremony string `json:"ceremony"`
+	UserUuid string `json:"userUuid,omitempty"`
+}
@@ -8,6 +8,7 @@ var (
 	UnknownRole  = KindRole{"unknown"}
 	CustomerRole = KindRole{"customer"}
 	StaffRole    = KindRole{"staff"}
+	ServiceRole  = KindRole{"service"}
 )
 
 func (k KindRole) String() string {
@@ -20,6 +21,8 @@ func RoleFromString(s string) KindRole {
 		return CustomerRole
 	case "staff":
 		return StaffRole
+	case "service":
+		return ServiceRole
 	default:
 		return UnknownRole
 	}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Session is a sign-in on one device, it lives as long as its family of refresh tokens
+type Session struct {
+	Uuid          uuid.UUID `json:"uuid"`
+	UserUuid      string    `json:"userUuid"`
+	UserAgent     string    `json:"userAgent"`
+	Ip            string    `json:"ip"`
+	ClientId      string    `json:"clientId,omitempty"` // the OpenID Connect client which the tokens of the session are issued to
+	Scope         string    `json:"scope,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+	LastRefreshAt time.Time `json:"lastRefreshAt"`
+}
+
+// LoginEvent is a sign in of the user, the login history outlives the session
+type LoginEvent struct {
+	SessionUuid uuid.UUID `json:"sessionUuid"`
+	UserAgent   string    `json:"userAgent"`
+	Ip          string    `json:"ip"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
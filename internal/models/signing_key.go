@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+type SigningKey struct {
+	Id                  int
+	Kid                 string
+	Alg                 string
+	EncryptedPrivateKey string // PEM encoded private key encrypted with AES-256-GCM
+	Status              KindKeyStatus
+	ExpireAt            time.Time
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+}
+
+type KindKeyStatus struct {
+	slug string
+}
+
+var (
+	UnknownKeyStatus = KindKeyStatus{"unknown"}
+	PendingKeyStatus = KindKeyStatus{"pending"}
+	ActiveKeyStatus  = KindKeyStatus{"active"}
+	RetiredKeyStatus = KindKeyStatus{"retired"}
+)
+
+func (k KindKeyStatus) String() string {
+	return k.slug
+}
+
+func KeyStatusFromString(s string) KindKeyStatus {
+	switch s {
+	case "pending":
+		return PendingKeyStatus
+	case "active":
+		return ActiveKeyStatus
+	case "retired":
+		return RetiredKeyStatus
+	default:
+		return UnknownKeyStatus
+	}
+}
@@ -9,7 +9,7 @@ type AccessTokenClaims struct {
 	AccessUuid string `json:"accessUuid"`
 	UserUuid   string `json:"userUuid"`
 	UserRole   string `json:"userRole"`
-	Exp        int    `json:"exp"`
+	Scope      string `json:"scope,omitempty"`
 	jwt.StandardClaims
 }
 
@@ -17,7 +17,14 @@ type RefreshTokenClaims struct {
 	RefreshUuid string `json:"refreshUuid"`
 	UserUuid    string `json:"userUuid"`
 	UserRole    string `json:"userRole"`
-	Exp         int    `json:"exp"`
+	jwt.StandardClaims
+}
+
+type IdTokenClaims struct {
+	Nonce         string `json:"nonce,omitempty"`
+	AuthTime      int64  `json:"auth_time"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"email_verified,omitempty"`
 	jwt.StandardClaims
 }
 
@@ -25,8 +32,29 @@ type RefreshTokenClaims struct {
 type TokenDetails struct {
 	AccessToken  string
 	RefreshToken string
+	IdToken      string
+	Scope        string
 	AccessUuid   uuid.UUID
 	RefreshUuid  uuid.UUID
+	FamilyUuid   uuid.UUID
 	AtExpires    int64
 	RtExpires    int64
 }
+
+const (
+	AccessTokenType  = "access_token"
+	RefreshTokenType = "refresh_token"
+)
+
+// TokenIntrospection is the state of a token as described by RFC 7662
+type TokenIntrospection struct {
+	Active    bool
+	Subject   string
+	Role      string
+	ExpiresAt int64
+	IssuedAt  int64
+	Jti       string
+	Scope     string
+	ClientId  string
+	TokenType string
+}
@@ -1,18 +1,32 @@
 package models
 
 import (
+	"errors"
 	"github.com/google/uuid"
 	"time"
 )
 
+var (
+	// ErrInvalidCredentials is returned when the password does not match
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrUserNotFound is returned when there is no user with the email or uuid
+	ErrUserNotFound = errors.New("user does not exist")
+)
+
 type User struct {
-	Id           int
-	Uuid         uuid.UUID
-	Email        string
-	PasswordHash string
-	Role         KindRole
-	IsActive     bool
-	GoogleId     string
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
+	Id            int
+	Uuid          uuid.UUID
+	Email         string
+	PasswordHash  string
+	Role          KindRole
+	IsActive      bool
+	DeleteAt      *time.Time // the account is deleted after this time unless the user signs in before
+	DeactivatedAt *time.Time // the account was disabled by staff and could not be activated again by its user
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// IsDeactivated reports whether the account was disabled by staff, unlike an inactive account of an unconfirmed registration
+func (u *User) IsDeactivated() bool {
+	return u.DeactivatedAt != nil
 }
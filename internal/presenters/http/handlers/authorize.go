@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/aerosystems/auth-service/internal/models"
+	"github.com/labstack/echo/v4"
+	"html/template"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var authorizeTemplate = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in to {{.ClientName}}</title></head>
+<body>
+<h1>Sign in to {{.ClientName}}</h1>
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+<form method="post" action="authorize">
+<input type="hidden" name="response_type" value="{{.Request.ResponseType}}">
+<input type="hidden" name="client_id" value="{{.Request.ClientId}}">
+<input type="hidden" name="redirect_uri" value="{{.Request.RedirectUri}}">
+<input type="hidden" name="scope" value="{{.Request.Scope}}">
+<input type="hidden" name="state" value="{{.Request.State}}">
+<input type="hidden" name="nonce" value="{{.Request.Nonce}}">
+<input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.Request.CodeChallengeMethod}}">
+{{if .Request.MfaToken}}<input type="hidden" name="mfa_token" value="{{.Request.MfaToken}}">
+<input type="text" name="otp" placeholder="Authentication or recovery code" autocomplete="one-time-code" required>
+<button type="submit">Verify</button>{{else}}<input type="email" name="email" placeholder="Email" value="{{.Request.Email}}" required>
+<input type="password" name="password" placeholder="Password" required>
+<button type="submit">Sign in</button>{{end}}
+</form>
+</body>
+</html>
+`))
+
+type AuthorizeRequest struct {
+	ResponseType        string `query:"response_type" form:"response_type"`
+	ClientId            string `query:"client_id" form:"client_id"`
+	RedirectUri         string `query:"redirect_uri" form:"redirect_uri"`
+	Scope               string `query:"scope" form:"scope"`
+	State               string `query:"state" form:"state"`
+	Nonce               string `query:"nonce" form:"nonce"`
+	CodeChallenge       string `query:"code_challenge" form:"code_challenge"`
+	CodeChallengeMethod string `query:"code_challenge_method" form:"code_challenge_method"`
+	Email               string `form:"email"`
+	Password            string `form:"password"`
+	MfaToken            string `form:"mfa_token"`
+	Otp                 string `form:"otp"`
+}
+
+type authorizePage struct {
+	ClientName string
+	Error      string
+	Request    AuthorizeRequest
+}
+
+// Authorize godoc
+// @Summary OpenID Connect authorization endpoint
+// @Description Renders the sign in form for the authorization code flow, PKCE with S256 is required
+// @Tags oidc
+// @Produce html
+// @Param response_type query string true "code"
+// @Param client_id query string true "client id"
+// @Param redirect_uri query string true "registered redirect uri"
+// @Param scope query string true "openid email"
+// @Param state query string false "opaque value returned to the client"
+// @Param nonce query string false "value put into ID token"
+// @Param code_challenge query string true "PKCE code challenge"
+// @Param code_challenge_method query string true "S256"
+// @Success 200
+// @Success 302
+// @Failure 400 {object} Response
+// @Router /authorize [get]
+func (oh OAuthHandler) Authorize(c echo.Context) error {
+	var request AuthorizeRequest
+	if err := c.Bind(&request); err != nil {
+		return oh.ErrorResponse(c, http.StatusBadRequest, "could not read request", err)
+	}
+	client, err := oh.oauthUsecase.GetClient(request.ClientId, request.RedirectUri)
+	if err != nil {
+		return oh.ErrorResponse(c, http.StatusBadRequest, "invalid client or redirect uri", err)
+	}
+	if err := oh.oauthUsecase.CheckAuthorizationRequest(client, request.ResponseType, request.Scope, request.CodeChallenge, request.CodeChallengeMethod); err != nil {
+		return redirectWithError(c, request, err)
+	}
+	return renderAuthorizePage(c, http.StatusOK, authorizePage{ClientName: client.Name, Request: request})
+}
+
+// AuthorizeSubmit godoc
+// @Summary OpenID Connect authorization endpoint, sign in form submission
+// @Description Checks credentials like sign in does and redirects back to the client with an authorization code
+// @Tags oidc
+// @Accept  x-www-form-urlencoded
+// @Produce html
+// @Param email formData string false "email"
+// @Param password formData string false "password"
+// @Param mfa_token formData string false "mfa token of the second step for users with two-factor authentication"
+// @Param otp formData string false "code of the authenticator app or a recovery code"
+// @Success 302
+// @Failure 400 {object} Response
+// @Failure 401
+// @Router /authorize [post]
+func (oh OAuthHandler) AuthorizeSubmit(c echo.Context) error {
+	var request AuthorizeRequest
+	if err := c.Bind(&request); err != nil {
+		return oh.ErrorResponse(c, http.StatusBadRequest, "could not read request", err)
+	}
+	client, err := oh.oauthUsecase.GetClient(request.ClientId, request.RedirectUri)
+	if err != nil {
+		return oh.ErrorResponse(c, http.StatusBadRequest, "invalid client or redirect uri", err)
+	}
+	if err := oh.oauthUsecase.CheckAuthorizationRequest(client, request.ResponseType, request.Scope, request.CodeChallenge, request.CodeChallengeMethod); err != nil {
+		return redirectWithError(c, request, err)
+	}
+	user, err := oh.authenticateUser(c, client, &request)
+	if user == nil {
+		return err
+	}
+	oh.cancelAccountDeletion(oh.accountUsecase, user)
+	code, err := oh.oauthUsecase.CreateAuthorizationCode(&models.AuthorizationCode{
+		ClientId:      client.ClientId,
+		RedirectUri:   request.RedirectUri,
+		UserUuid:      user.Uuid.String(),
+		Scope:         request.Scope,
+		Nonce:         request.Nonce,
+		CodeChallenge: request.CodeChallenge,
+		AuthTime:      time.Now(),
+	})
+	if err != nil {
+		return redirectWithError(c, request, models.NewOAuthError("server_error", "could not create authorization code"))
+	}
+	return redirectWithParams(c, request.RedirectUri, url.Values{"code": {code}, "state": {request.State}})
+}
+
+// authenticateUser checks the password, then the second factor for users with two-factor authentication. It returns
+// no user when the page has been rendered again.
+func (oh OAuthHandler) authenticateUser(c echo.Context, client *models.Client, request *AuthorizeRequest) (*models.User, error) {
+	page := authorizePage{ClientName: client.Name, Request: *request}
+	page.Request.Password, page.Request.Otp = "", ""
+	if request.MfaToken != "" {
+		user, err := oh.mfaUsecase.VerifyMfaChallenge(request.MfaToken, request.Otp)
+		if err != nil {
+			page.Error = "invalid authentication code"
+			return nil, renderAuthorizePage(c, http.StatusUnauthorized, page)
+		}
+		return user, nil
+	}
+	if retryAfter, err := oh.lockoutUsecase.CheckSignIn(request.Email, c.RealIP()); err != nil {
+		if retryAfter <= 0 {
+			return nil, oh.ErrorResponse(c, http.StatusInternalServerError, "could not check failed sign in attempts", err)
+		}
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		page.Error = err.Error()
+		return nil, renderAuthorizePage(c, http.StatusTooManyRequests, page)
+	}
+	user, err := oh.authUsecase.GetActiveUserByEmail(request.Email)
+	if err == nil {
+		_, err = oh.authUsecase.CheckPassword(user, request.Password)
+	}
+	if err != nil {
+		oh.registerSignInFailure(oh.lockoutUsecase, request.Email, c.RealIP())
+		page.Error = "invalid credentials"
+		return nil, renderAuthorizePage(c, http.StatusUnauthorized, page)
+	}
+	oh.lockoutUsecase.RegisterSuccess(request.Email)
+	mfaEnabled, err := oh.mfaUsecase.IsMfaEnabled(user)
+	if err != nil {
+		return nil, oh.ErrorResponse(c, http.StatusInternalServerError, "could not check second factor", err)
+	}
+	if !mfaEnabled {
+		return user, nil
+	}
+	if page.Request.MfaToken, err = oh.mfaUsecase.CreateMfaChallenge(user); err != nil {
+		return nil, oh.ErrorResponse(c, http.StatusInternalServerError, "could not create mfa challenge", err)
+	}
+	return nil, renderAuthorizePage(c, http.StatusOK, page)
+}
+
+func renderAuthorizePage(c echo.Context, statusCode int, page authorizePage) error {
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	c.Response().Header().Set("X-Frame-Options", "DENY")
+	c.Response().WriteHeader(statusCode)
+	return authorizeTemplate.Execute(c.Response(), page)
+}
+
+func redirectWithError(c echo.Context, request AuthorizeRequest, err error) error {
+	var oauthErr *models.OAuthError
+	if !errors.As(err, &oauthErr) {
+		oauthErr = models.NewOAuthError("server_error", err.Error())
+	}
+	return redirectWithParams(c, request.RedirectUri, url.Values{
+		"error":             {oauthErr.Code},
+		"error_description": {oauthErr.Description},
+		"state":             {request.State},
+	})
+}
+
+func redirectWithParams(c echo.Context, redirectUri string, params url.Values) error {
+	u, err := url.Parse(redirectUri)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, OAuthErrorResponse{Error: "invalid_request", ErrorDescription: "invalid redirect uri"})
+	}
+	query := u.Query()
+	for key, values := range params {
+		if len(values) > 0 && values[0] != "" {
+			query.Set(key, values[0])
+		}
+	}
+	u.RawQuery = query.Encode()
+	return c.Redirect(http.StatusFound, u.String())
+}
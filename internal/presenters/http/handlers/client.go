@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"github.com/aerosystems/auth-service/internal/models"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+type ClientRequestBody struct {
+	Name         string   `json:"name" validate:"required" example:"Dashboard"`
+	RedirectUris []string `json:"redirectUris" validate:"omitempty,dive,url" example:"https://dashboard.verifire.dev/callback"`
+	Scopes       []string `json:"scopes" example:"openid,email"`
+	GrantTypes   []string `json:"grantTypes" validate:"omitempty,dive,oneof=authorization_code refresh_token client_credentials" example:"authorization_code,refresh_token"`
+	IsPublic     bool     `json:"isPublic" example:"false"`
+}
+
+type ClientResponseBody struct {
+	ClientId     string    `json:"clientId" example:"550e8400-e29b-41d4-a716-446655440000"`
+	ClientSecret string    `json:"clientSecret,omitempty" example:"kQ1Xr7v2Q3b2dYb1T6xJ2l0v5b5cT9J4k1n3m8p0q2s"`
+	Name         string    `json:"name" example:"Dashboard"`
+	RedirectUris []string  `json:"redirectUris" example:"https://dashboard.verifire.dev/callback"`
+	Scopes       []string  `json:"scopes" example:"openid,email"`
+	GrantTypes   []string  `json:"grantTypes" example:"authorization_code,refresh_token"`
+	IsPublic     bool      `json:"isPublic" example:"false"`
+	CreatedAt    time.Time `json:"createdAt" example:"2024-01-01T00:00:00Z"`
+}
+
+func ModelToResponseClient(client *models.Client) *ClientResponseBody {
+	return &ClientResponseBody{
+		ClientId:     client.ClientId,
+		Name:         client.Name,
+		RedirectUris: client.RedirectUris,
+		Scopes:       client.Scopes,
+		GrantTypes:   client.GrantTypes,
+		IsPublic:     client.IsPublic(),
+		CreatedAt:    client.CreatedAt,
+	}
+}
+
+// GetClients godoc
+// @Summary list registered OAuth clients
+// @Tags clients
+// @Produce application/json
+// @Security BearerAuth
+// @Success 200 {object} Response{data=[]ClientResponseBody}
+// @Failure 401 {object} Response
+// @Failure 403 {object} Response
+// @Failure 500 {object} Response
+// @Router /v1/clients [get]
+func (oh OAuthHandler) GetClients(c echo.Context) error {
+	clients, err := oh.oauthUsecase.GetClients()
+	if err != nil {
+		return oh.ErrorResponse(c, http.StatusInternalServerError, "could not get clients", err)
+	}
+	res := make([]*ClientResponseBody, 0, len(clients))
+	for i := range clients {
+		res = append(res, ModelToResponseClient(&clients[i]))
+	}
+	return oh.SuccessResponse(c, http.StatusOK, "clients were successfully found", res)
+}
+
+// CreateClient godoc
+// @Summary register an OAuth client
+// @Description Client secret is returned only once, public clients get no secret.
+// @Description Service clients use client_credentials grant type and may define their own scopes, e.g. users:read
+// @Tags clients
+// @Accept  json
+// @Produce application/json
+// @Security BearerAuth
+// @Param client body ClientRequestBody true "raw request body"
+// @Success 201 {object} Response{data=ClientResponseBody}
+// @Failure 400 {object} Response
+// @Failure 401 {object} Response
+// @Failure 403 {object} Response
+// @Failure 422 {object} Response
+// @Router /v1/clients [post]
+func (oh OAuthHandler) CreateClient(c echo.Context) error {
+	var requestPayload ClientRequestBody
+	if err := c.Bind(&requestPayload); err != nil {
+		return oh.ErrorResponse(c, http.StatusUnprocessableEntity, "could not read request body", err)
+	}
+	if err := c.Validate(requestPayload); err != nil {
+		return err
+	}
+	client, secret, err := oh.oauthUsecase.CreateClient(requestPayload.Name, requestPayload.RedirectUris, requestPayload.Scopes, requestPayload.GrantTypes, requestPayload.IsPublic)
+	if err != nil {
+		return oh.ErrorResponse(c, http.StatusBadRequest, "could not create client", err)
+	}
+	res := ModelToResponseClient(client)
+	res.ClientSecret = secret
+	return oh.SuccessResponse(c, http.StatusCreated, "client was successfully created", res)
+}
+
+// DeleteClient godoc
+// @Summary delete an OAuth client
+// @Tags clients
+// @Produce application/json
+// @Security BearerAuth
+// @Param clientId path string true "client id"
+// @Success 200 {object} Response
+// @Failure 401 {object} Response
+// @Failure 403 {object} Response
+// @Failure 404 {object} Response
+// @Router /v1/clients/{clientId} [delete]
+func (oh OAuthHandler) DeleteClient(c echo.Context) error {
+	if err := oh.oauthUsecase.DeleteClient(c.Param("clientId")); err != nil {
+		return oh.ErrorResponse(c, http.StatusNotFound, "could not delete client", err)
+	}
+	return oh.SuccessResponse(c, http.StatusOK, "client was successfully deleted", nil)
+}
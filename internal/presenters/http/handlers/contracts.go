@@ -1,15 +1,28 @@
 package handlers
 
-import "github.com/aerosystems/auth-service/internal/models"
+import (
+	"github.com/aerosystems/auth-service/internal/models"
+	"github.com/aerosystems/auth-service/pkg/jwk"
+	"github.com/aerosystems/auth-service/pkg/webauthn"
+	"time"
+)
 
 type TokenUsecase interface {
-	GetAccessSecret() string
-	CreateToken(userUuid string, userRole string) (*models.TokenDetails, error)
+	GetJWKS() (jwk.Set, error)
+	GetSigningKeys() ([]models.SigningKey, error)
+	AddSigningKey(alg string) (*models.SigningKey, error)
+	PromoteSigningKey(kid string) error
+	CreateToken(userUuid string, userRole string, userAgent string, ip string) (*models.TokenDetails, error)
+	RefreshTokens(refreshToken string) (*models.TokenDetails, error)
 	DecodeRefreshToken(tokenString string) (*models.RefreshTokenClaims, error)
 	DecodeAccessToken(tokenString string) (*models.AccessTokenClaims, error)
 	DropCacheTokens(accessUuid string) error
 	DropCacheKey(Uuid string) error
 	GetCacheValue(Uuid string) (*string, error)
+	GetSessions(userUuid string) ([]models.Session, error)
+	GetSessionUuid(accessUuid string) (string, error)
+	RevokeSession(userUuid string, sessionUuid string) error
+	RevokeSessions(userUuid string) error
 }
 
 type AuthUsecase interface {
@@ -18,6 +31,73 @@ type AuthUsecase interface {
 	ResetPassword(email, password string) error
 	CheckPassword(user *models.User, password string) (bool, error)
 	GetActiveUserByEmail(email string) (*models.User, error)
+	SendLoginCode(email string) error
+	ResendCode(email string, action models.KindCode) error
+	SignInWithCode(email, code string) (*models.User, error)
 	GetUserByUuid(uuid string) (*models.User, error)
-	GetCode(code string) (*models.Code, error)
+	GetCode(email, code string) (*models.Code, error)
+	ChangeRole(userUuid string, role models.KindRole) error
+	Deactivate(userUuid string) error
+	ChangeEmail(userUuid, newEmail, clientIp string) error
+	ChangePassword(userUuid, accessUuid, clientIp, currentPassword, newPassword string) (time.Duration, error)
+}
+
+type OAuthUsecase interface {
+	AuthenticateClient(clientId, clientSecret string) error
+	Introspect(token, tokenTypeHint string) *models.TokenIntrospection
+	Revoke(clientId, clientSecret, token, tokenTypeHint string) error
+	GetIssuer() string
+	CreateClient(name string, redirectUris []string, scopes []string, grantTypes []string, isPublic bool) (*models.Client, string, error)
+	GetClients() ([]models.Client, error)
+	DeleteClient(clientId string) error
+	GetClient(clientId, redirectUri string) (*models.Client, error)
+	CheckAuthorizationRequest(client *models.Client, responseType, scope, codeChallenge, codeChallengeMethod string) error
+	CreateAuthorizationCode(authorizationCode *models.AuthorizationCode) (string, error)
+	ExchangeAuthorizationCode(clientId, clientSecret, code, redirectUri, codeVerifier, userAgent, ip string) (*models.TokenDetails, error)
+	RefreshTokens(clientId, clientSecret, refreshToken string) (*models.TokenDetails, error)
+	IssueServiceToken(clientId, clientSecret, scope string) (*models.TokenDetails, error)
+	GetUserInfo(userUuid string) (*models.User, error)
+}
+
+type IdentityUsecase interface {
+	GetProviders() []string
+	SignIn(providerName string, credential *models.ExternalCredential) (*models.User, error)
+	GetIdentities(userUuid string) ([]models.UserIdentity, error)
+	LinkIdentity(userUuid, providerName string, credential *models.ExternalCredential) (*models.UserIdentity, error)
+	UnlinkIdentity(userUuid, providerName string) error
+}
+
+type MfaUsecase interface {
+	EnrollTotp(userUuid string) (*models.TotpEnrollment, error)
+	ConfirmTotp(userUuid, code string) ([]string, error)
+	RegenerateRecoveryCodes(userUuid, code string) ([]string, error)
+	DisableTotp(userUuid, code string) error
+	IsMfaEnabled(user *models.User) (bool, error)
+	CreateMfaChallenge(user *models.User) (string, error)
+	VerifyMfaChallenge(mfaToken, code string) (*models.User, error)
+}
+
+type PasskeyUsecase interface {
+	BeginRegistration(userUuid string) (*webauthn.CreationOptions, error)
+	FinishRegistration(userUuid, name string, response *webauthn.AttestationResponse, transports []string) (*models.Passkey, error)
+	BeginLogin() (*webauthn.RequestOptions, error)
+	FinishLogin(response *webauthn.AssertionResponse) (*models.User, error)
+	GetPasskeys(userUuid string) ([]models.Passkey, error)
+	DeletePasskey(userUuid string, passkeyId int) error
+}
+
+type ExportUsecase interface {
+	ExportUser(userUuid string) (*models.UserExport, error)
+}
+
+type AccountUsecase interface {
+	ScheduleDeletion(userUuid, accessUuid, password string) (time.Time, error)
+	CancelDeletion(user *models.User) error
+}
+
+type LockoutUsecase interface {
+	CheckSignIn(email, clientIp string) (time.Duration, error)
+	RegisterFailure(email, clientIp string) error
+	RegisterSuccess(email string)
+	Unlock(userUuid string) error
 }
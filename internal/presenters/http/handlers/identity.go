@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/aerosystems/auth-service/internal/helpers"
+	"github.com/aerosystems/auth-service/internal/models"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+type IdentityHandler struct {
+	*BaseHandler
+	tokenUsecase    TokenUsecase
+	identityUsecase IdentityUsecase
+	mfaUsecase      MfaUsecase
+	accountUsecase  AccountUsecase
+}
+
+func NewIdentityHandler(baseHandler *BaseHandler, tokenUsecase TokenUsecase, identityUsecase IdentityUsecase, mfaUsecase MfaUsecase, accountUsecase AccountUsecase) *IdentityHandler {
+	return &IdentityHandler{
+		BaseHandler:     baseHandler,
+		tokenUsecase:    tokenUsecase,
+		identityUsecase: identityUsecase,
+		mfaUsecase:      mfaUsecase,
+		accountUsecase:  accountUsecase,
+	}
+}
+
+type ExternalCredentialRequestBody struct {
+	IdToken     string `json:"idToken" validate:"required_without=Code" example:"eyJhbGciOiJSUzI1NiIsImtpZCI6IjZmNzI1NDEwMWY1NmU0MWNmMzVjOTkyNmRlODRhMmQ1NTJiNGM2ZjEiLCJ0eXAiOiJKV1QifQ"`
+	Code        string `json:"code" validate:"required_without=IdToken" example:"4d2f1e9a0b7c3e5f8a1b"`
+	RedirectUri string `json:"redirectUri" validate:"omitempty,url" example:"https://verifire.dev/auth/github/callback"`
+}
+
+func (r ExternalCredentialRequestBody) ToModel() *models.ExternalCredential {
+	return &models.ExternalCredential{
+		IdToken:     r.IdToken,
+		Code:        r.Code,
+		RedirectUri: r.RedirectUri,
+	}
+}
+
+type IdentityResponseBody struct {
+	Provider  string    `json:"provider" example:"google"`
+	Email     string    `json:"email" example:"example@gmail.com"`
+	CreatedAt time.Time `json:"createdAt" example:"2024-01-01T00:00:00Z"`
+}
+
+func ModelToResponseIdentity(identity *models.UserIdentity) *IdentityResponseBody {
+	return &IdentityResponseBody{
+		Provider:  identity.Provider,
+		Email:     identity.Email,
+		CreatedAt: identity.CreatedAt,
+	}
+}
+
+// GetProviders godoc
+// @Summary list identity providers available for sign in
+// @Tags identities
+// @Produce application/json
+// @Success 200 {object} Response{data=[]string}
+// @Router /v1/identity-providers [get]
+func (ih IdentityHandler) GetProviders(c echo.Context) error {
+	return ih.SuccessResponse(c, http.StatusOK, "identity providers were successfully found", ih.identityUsecase.GetProviders())
+}
+
+// SignIn godoc
+// @Summary login user with an external identity provider
+// @Description OpenID Connect providers (google, microsoft, corporate issuers) take the ID token got by the client.
+// @Description OAuth 2.0 providers (github) take the authorization code and the redirect uri used to get it.
+// @Description User is found by linked account or verified email, unknown users are registered as customers.
+// @Description Response contain pair JWT tokens, or an mfa token for users with two-factor authentication
+// @Tags auth
+// @Accept  json
+// @Produce application/json
+// @Param provider path string true "identity provider" example(google)
+// @Param login body ExternalCredentialRequestBody true "raw request body"
+// @Success 200 {object} Response{data=TokensResponseBody}
+// @Success 202 {object} Response{data=MfaChallengeResponseBody}
+// @Failure 401 {object} Response
+// @Failure 404 {object} Response
+// @Failure 422 {object} Response
+// @Failure 500 {object} Response
+// @Router /v1/sign-in/{provider} [post]
+func (ih IdentityHandler) SignIn(c echo.Context) error {
+	var requestPayload ExternalCredentialRequestBody
+	if err := c.Bind(&requestPayload); err != nil {
+		return ih.ErrorResponse(c, http.StatusUnprocessableEntity, "could not read request body", err)
+	}
+	if err := c.Validate(requestPayload); err != nil {
+		return err
+	}
+	if !helpers.Contains(ih.identityUsecase.GetProviders(), c.Param("provider")) {
+		return ih.ErrorResponse(c, http.StatusNotFound, "unknown identity provider", errors.New("identity provider is not configured"))
+	}
+	user, err := ih.identityUsecase.SignIn(c.Param("provider"), requestPayload.ToModel())
+	if err != nil {
+		return ih.ErrorResponse(c, http.StatusUnauthorized, "could not sign in with identity provider", err)
+	}
+	return ih.signInResponse(c, ih.tokenUsecase, ih.mfaUsecase, ih.accountUsecase, user)
+}
+
+// GetIdentities godoc
+// @Summary list external identity providers linked to the user
+// @Tags identities
+// @Produce application/json
+// @Security BearerAuth
+// @Success 200 {object} Response{data=[]IdentityResponseBody}
+// @Failure 401 {object} Response
+// @Failure 500 {object} Response
+// @Router /v1/users/identities [get]
+func (ih IdentityHandler) GetIdentities(c echo.Context) error {
+	accessTokenClaims := c.Get("accessTokenClaims").(*models.AccessTokenClaims)
+	identities, err := ih.identityUsecase.GetIdentities(accessTokenClaims.UserUuid)
+	if err != nil {
+		return ih.ErrorResponse(c, http.StatusInternalServerError, "could not get identities", err)
+	}
+	res := make([]*IdentityResponseBody, 0, len(identities))
+	for i := range identities {
+		res = append(res, ModelToResponseIdentity(&identities[i]))
+	}
+	return ih.SuccessResponse(c, http.StatusOK, "identities were successfully found", res)
+}
+
+// LinkIdentity godoc
+// @Summary link an external identity provider to the user
+// @Description Takes the same credential as sign in with the provider
+// @Tags identities
+// @Accept  json
+// @Produce application/json
+// @Security BearerAuth
+// @Param provider path string true "identity provider" example(github)
+// @Param credential body ExternalCredentialRequestBody true "raw request body"
+// @Success 201 {object} Response{data=IdentityResponseBody}
+// @Failure 400 {object} Response
+// @Failure 401 {object} Response
+// @Failure 404 {object} Response
+// @Failure 422 {object} Response
+// @Router /v1/users/identities/{provider} [post]
+func (ih IdentityHandler) LinkIdentity(c echo.Context) error {
+	accessTokenClaims := c.Get("accessTokenClaims").(*models.AccessTokenClaims)
+	var requestPayload ExternalCredentialRequestBody
+	if err := c.Bind(&requestPayload); err != nil {
+		return ih.ErrorResponse(c, http.StatusUnprocessableEntity, "could not read request body", err)
+	}
+	if err := c.Validate(requestPayload); err != nil {
+		return err
+	}
+	if !helpers.Contains(ih.identityUsecase.GetProviders(), c.Param("provider")) {
+		return ih.ErrorResponse(c, http.StatusNotFound, "unknown identity provider", errors.New("identity provider is not configured"))
+	}
+	identity, err := ih.identityUsecase.LinkIdentity(accessTokenClaims.UserUuid, c.Param("provider"), requestPayload.ToModel())
+	if err != nil {
+		return ih.ErrorResponse(c, http.StatusBadRequest, "could not link identity", err)
+	}
+	return ih.SuccessResponse(c, http.StatusCreated, "identity was successfully linked", ModelToResponseIdentity(identity))
+}
+
+// UnlinkIdentity godoc
+// @Summary unlink an external identity provider from the user
+// @Description The last linked provider of a user without password could not be unlinked
+// @Tags identities
+// @Produce application/json
+// @Security BearerAuth
+// @Param provider path string true "identity provider" example(github)
+// @Success 200 {object} Response
+// @Failure 400 {object} Response
+// @Failure 401 {object} Response
+// @Router /v1/users/identities/{provider} [delete]
+func (ih IdentityHandler) UnlinkIdentity(c echo.Context) error {
+	accessTokenClaims := c.Get("accessTokenClaims").(*models.AccessTokenClaims)
+	if err := ih.identityUsecase.UnlinkIdentity(accessTokenClaims.UserUuid, c.Param("provider")); err != nil {
+		return ih.ErrorResponse(c, http.StatusBadRequest, "could not unlink identity", err)
+	}
+	return ih.SuccessResponse(c, http.StatusOK, "identity was successfully unlinked", nil)
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"github.com/aerosystems/auth-service/internal/models"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+type MfaHandler struct {
+	*BaseHandler
+	tokenUsecase   TokenUsecase
+	mfaUsecase     MfaUsecase
+	accountUsecase AccountUsecase
+}
+
+func NewMfaHandler(baseHandler *BaseHandler, tokenUsecase TokenUsecase, mfaUsecase MfaUsecase, accountUsecase AccountUsecase) *MfaHandler {
+	return &MfaHandler{
+		BaseHandler:    baseHandler,
+		tokenUsecase:   tokenUsecase,
+		mfaUsecase:     mfaUsecase,
+		accountUsecase: accountUsecase,
+	}
+}
+
+type TotpCodeRequestBody struct {
+	Code string `json:"code" validate:"required,numeric,len=6" example:"123456"`
+}
+
+type MfaSignInRequestBody struct {
+	MfaToken string `json:"mfaToken" validate:"required" example:"kQ1Xr7v2Q3b2dYb1T6xJ2l0v5b5cT9J4k1n3m8p0q2s"`
+	Code     string `json:"code" validate:"required" example:"123456"` // code of the authenticator app or a recovery code
+}
+
+type TotpEnrollmentResponseBody struct {
+	Secret string `json:"secret" example:"JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"`
+	Uri    string `json:"uri" example:"otpauth://totp/Verifire:example%40gmail.com?algorithm=SHA1&digits=6&issuer=Verifire&period=30&secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"`
+}
+
+type RecoveryCodesResponseBody struct {
+	RecoveryCodes []string `json:"recoveryCodes" example:"k7m2p-x9q4r,a3b8c-d2e6f"`
+}
+
+type MfaChallengeResponseBody struct {
+	MfaRequired bool   `json:"mfaRequired" example:"true"`
+	MfaToken    string `json:"mfaToken" example:"kQ1Xr7v2Q3b2dYb1T6xJ2l0v5b5cT9J4k1n3m8p0q2s"`
+}
+
+// signInResponse completes the first step of sign in. Users with the second factor get an MFA challenge instead of tokens.
+func (h BaseHandler) signInResponse(c echo.Context, tokenUsecase TokenUsecase, mfaUsecase MfaUsecase, accountUsecase AccountUsecase, user *models.User) error {
+	mfaEnabled, err := mfaUsecase.IsMfaEnabled(user)
+	if err != nil {
+		return h.ErrorResponse(c, http.StatusInternalServerError, "could not check second factor", err)
+	}
+	if mfaEnabled {
+		mfaToken, err := mfaUsecase.CreateMfaChallenge(user)
+		if err != nil {
+			return h.ErrorResponse(c, http.StatusInternalServerError, "could not create mfa challenge", err)
+		}
+		return h.SuccessResponse(c, http.StatusAccepted, "second factor is required", MfaChallengeResponseBody{MfaRequired: true, MfaToken: mfaToken})
+	}
+	h.cancelAccountDeletion(accountUsecase, user)
+	ts, err := tokenUsecase.CreateToken(user.Uuid.String(), user.Role.String(), c.Request().UserAgent(), c.RealIP())
+	if err != nil {
+		return h.ErrorResponse(c, http.StatusInternalServerError, "could not create a pair of JWT tokens", err)
+	}
+	return h.SuccessResponse(c, http.StatusOK, "user was successfully logged in", ModelToResponseTokenDetails(ts))
+}
+
+// cancelAccountDeletion keeps the account of a user who signs in during the grace period of its deletion. A failure
+// does not stop the sign in, it is only logged.
+func (h BaseHandler) cancelAccountDeletion(accountUsecase AccountUsecase, user *models.User) {
+	if err := accountUsecase.CancelDeletion(user); err != nil {
+		h.log.Errorf("could not cancel account deletion: %s", err.Error())
+	}
+}
+
+// SignInMfa godoc
+// @Summary second step of login for users with two-factor authentication
+// @Description Takes the mfa token returned by sign in and the code of the authenticator app or one of the recovery codes.
+// @Description A recovery code is single use, the user is notified by email when it is used.
+// @Description The mfa token expires in 5 minutes and is dropped after 5 invalid codes.
+// @Description Response contain pair JWT tokens
+// @Tags auth
+// @Accept  json
+// @Produce application/json
+// @Param login body MfaSignInRequestBody true "raw request body"
+// @Success 200 {object} Response{data=TokensResponseBody}
+// @Failure 401 {object} Response
+// @Failure 422 {object} Response
+// @Failure 500 {object} Response
+// @Router /v1/sign-in/mfa [post]
+func (mh MfaHandler) SignInMfa(c echo.Context) error {
+	var requestPayload MfaSignInRequestBody
+	if err := c.Bind(&requestPayload); err != nil {
+		return mh.ErrorResponse(c, http.StatusUnprocessableEntity, "could not read request body", err)
+	}
+	if err := c.Validate(requestPayload); err != nil {
+		return err
+	}
+	user, err := mh.mfaUsecase.VerifyMfaChallenge(requestPayload.MfaToken, requestPayload.Code)
+	if err != nil {
+		return mh.ErrorResponse(c, http.StatusUnauthorized, "invalid authentication code", err)
+	}
+	mh.cancelAccountDeletion(mh.accountUsecase, user)
+	ts, err := mh.tokenUsecase.CreateToken(user.Uuid.String(), user.Role.String(), c.Request().UserAgent(), c.RealIP())
+	if err != nil {
+		return mh.ErrorResponse(c, http.StatusInternalServerError, "could not create a pair of JWT tokens", err)
+	}
+	return mh.SuccessResponse(c, http.StatusOK, "user was successfully logged in", ModelToResponseTokenDetails(ts))
+}
+
+// EnrollTotp godoc
+// @Summary start two-factor authentication setup
+// @Description Returns the secret and the otpauth uri for an authenticator app, the second factor is enabled after confirmation with the first code
+// @Tags mfa
+// @Produce application/json
+// @Security BearerAuth
+// @Success 201 {object} Response{data=TotpEnrollmentResponseBody}
+// @Failure 400 {object} Response
+// @Failure 401 {object} Response
+// @Router /v1/users/mfa/totp [post]
+func (mh MfaHandler) EnrollTotp(c echo.Context) error {
+	accessTokenClaims := c.Get("accessTokenClaims").(*models.AccessTokenClaims)
+	enrollment, err := mh.mfaUsecase.EnrollTotp(accessTokenClaims.UserUuid)
+	if err != nil {
+		return mh.ErrorResponse(c, http.StatusBadRequest, "could not enroll totp", err)
+	}
+	return mh.SuccessResponse(c, http.StatusCreated, "scan the uri with an authenticator app and confirm it with the first code", TotpEnrollmentResponseBody{
+		Secret: enrollment.Secret,
+		Uri:    enrollment.Uri,
+	})
+}
+
+// ConfirmTotp godoc
+// @Summary enable two-factor authentication
+// @Description Returns the recovery codes, they are shown only once
+// @Tags mfa
+// @Accept  json
+// @Produce application/json
+// @Security BearerAuth
+// @Param code body TotpCodeRequestBody true "raw request body"
+// @Success 200 {object} Response{data=RecoveryCodesResponseBody}
+// @Failure 400 {object} Response
+// @Failure 401 {object} Response
+// @Failure 422 {object} Response
+// @Router /v1/users/mfa/totp/confirm [post]
+func (mh MfaHandler) ConfirmTotp(c echo.Context) error {
+	accessTokenClaims := c.Get("accessTokenClaims").(*models.AccessTokenClaims)
+	var requestPayload TotpCodeRequestBody
+	if err := c.Bind(&requestPayload); err != nil {
+		return mh.ErrorResponse(c, http.StatusUnprocessableEntity, "could not read request body", err)
+	}
+	if err := c.Validate(requestPayload); err != nil {
+		return err
+	}
+	recoveryCodes, err := mh.mfaUsecase.ConfirmTotp(accessTokenClaims.UserUuid, requestPayload.Code)
+	if err != nil {
+		return mh.ErrorResponse(c, http.StatusBadRequest, "could not enable totp", err)
+	}
+	return mh.SuccessResponse(c, http.StatusOK, "two-factor authentication was successfully enabled, save the recovery codes", RecoveryCodesResponseBody{RecoveryCodes: recoveryCodes})
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary regenerate recovery codes
+// @Description Replaces the recovery codes, the previous ones are no longer valid
+// @Tags mfa
+// @Accept  json
+// @Produce application/json
+// @Security BearerAuth
+// @Param code body TotpCodeRequestBody true "raw request body"
+// @Success 200 {object} Response{data=RecoveryCodesResponseBody}
+// @Failure 400 {object} Response
+// @Failure 401 {object} Response
+// @Failure 422 {object} Response
+// @Router /v1/users/mfa/recovery-codes [post]
+func (mh MfaHandler) RegenerateRecoveryCodes(c echo.Context) error {
+	accessTokenClaims := c.Get("accessTokenClaims").(*models.AccessTokenClaims)
+	var requestPayload TotpCodeRequestBody
+	if err := c.Bind(&requestPayload); err != nil {
+		return mh.ErrorResponse(c, http.StatusUnprocessableEntity, "could not read request body", err)
+	}
+	if err := c.Validate(requestPayload); err != nil {
+		return err
+	}
+	recoveryCodes, err := mh.mfaUsecase.RegenerateRecoveryCodes(accessTokenClaims.UserUuid, requestPayload.Code)
+	if err != nil {
+		return mh.ErrorResponse(c, http.StatusBadRequest, "could not regenerate recovery codes", err)
+	}
+	return mh.SuccessResponse(c, http.StatusOK, "recovery codes were successfully regenerated", RecoveryCodesResponseBody{RecoveryCodes: recoveryCodes})
+}
+
+// DisableTotp godoc
+// @Summary disable two-factor authentication
+// @Tags mfa
+// @Accept  json
+// @Produce application/json
+// @Security BearerAuth
+// @Param code body TotpCodeRequestBody true "raw request body"
+// @Success 200 {object} Response
+// @Failure 400 {object} Response
+// @Failure 401 {object} Response
+// @Failure 422 {object} Response
+// @Router /v1/users/mfa/totp [delete]
+func (mh MfaHandler) DisableTotp(c echo.Context) error {
+	accessTokenClaims := c.Get("accessTokenClaims").(*models.AccessTokenClaims)
+	var requestPayload TotpCodeRequestBody
+	if err := c.Bind(&requestPayload); err != nil {
+		return mh.ErrorResponse(c, http.StatusUnprocessableEntity, "could not read request body", err)
+	}
+	if err := c.Validate(requestPayload); err != nil {
+		return err
+	}
+	if err := mh.mfaUsecase.DisableTotp(accessTokenClaims.UserUuid, requestPayload.Code); err != nil {
+		return mh.ErrorResponse(c, http.StatusBadRequest, "could not disable totp", err)
+	}
+	return mh.SuccessResponse(c, http.StatusOK, "two-factor authentication was successfully disabled", nil)
+}
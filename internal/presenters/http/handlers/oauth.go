@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/aerosystems/auth-service/internal/helpers"
+	"github.com/aerosystems/auth-service/internal/models"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type OAuthHandler struct {
+	*BaseHandler
+	oauthUsecase   OAuthUsecase
+	authUsecase    AuthUsecase
+	mfaUsecase     MfaUsecase
+	lockoutUsecase LockoutUsecase
+	accountUsecase AccountUsecase
+}
+
+func NewOAuthHandler(baseHandler *BaseHandler, oauthUsecase OAuthUsecase, authUsecase AuthUsecase, mfaUsecase MfaUsecase, lockoutUsecase LockoutUsecase, accountUsecase AccountUsecase) *OAuthHandler {
+	return &OAuthHandler{
+		BaseHandler:    baseHandler,
+		oauthUsecase:   oauthUsecase,
+		authUsecase:    authUsecase,
+		mfaUsecase:     mfaUsecase,
+		lockoutUsecase: lockoutUsecase,
+		accountUsecase: accountUsecase,
+	}
+}
+
+// OAuthErrorResponse is the error format defined by RFC 6749
+type OAuthErrorResponse struct {
+	Error            string `json:"error" example:"invalid_request"`
+	ErrorDescription string `json:"error_description,omitempty" example:"token is required"`
+}
+
+type IntrospectionResponseBody struct {
+	Active    bool   `json:"active" example:"true"`
+	Sub       string `json:"sub,omitempty" example:"550e8400-e29b-41d4-a716-446655440000"`
+	Role      string `json:"role,omitempty" example:"customer"`
+	Exp       int64  `json:"exp,omitempty" example:"1704067200"`
+	Iat       int64  `json:"iat,omitempty" example:"1704066300"`
+	Jti       string `json:"jti,omitempty" example:"550e8400-e29b-41d4-a716-446655440000"`
+	Scope     string `json:"scope,omitempty" example:"openid"`
+	ClientId  string `json:"client_id,omitempty" example:"3f2a9c"`
+	TokenType string `json:"token_type,omitempty" example:"access_token"`
+}
+
+// Introspect godoc
+// @Summary OAuth 2.0 token introspection (RFC 7662)
+// @Description Client should authenticate with HTTP Basic or client_id/client_secret form fields
+// @Tags oauth
+// @Accept  x-www-form-urlencoded
+// @Produce application/json
+// @Param token formData string true "access or refresh token"
+// @Param token_type_hint formData string false "access_token or refresh_token"
+// @Success 200 {object} IntrospectionResponseBody
+// @Failure 400 {object} OAuthErrorResponse
+// @Failure 401 {object} OAuthErrorResponse
+// @Router /oauth/introspect [post]
+func (oh OAuthHandler) Introspect(c echo.Context) error {
+	if err := oh.authenticateClient(c); err != nil {
+		c.Response().Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		return c.JSON(http.StatusUnauthorized, OAuthErrorResponse{Error: "invalid_client"})
+	}
+	token := c.FormValue("token")
+	if token == "" {
+		return c.JSON(http.StatusBadRequest, OAuthErrorResponse{Error: "invalid_request", ErrorDescription: "token is required"})
+	}
+	introspection := oh.oauthUsecase.Introspect(token, c.FormValue("token_type_hint"))
+	if !introspection.Active {
+		return c.JSON(http.StatusOK, IntrospectionResponseBody{Active: false})
+	}
+	return c.JSON(http.StatusOK, IntrospectionResponseBody{
+		Active:    true,
+		Sub:       introspection.Subject,
+		Role:      introspection.Role,
+		Exp:       introspection.ExpiresAt,
+		Iat:       introspection.IssuedAt,
+		Jti:       introspection.Jti,
+		Scope:     introspection.Scope,
+		ClientId:  introspection.ClientId,
+		TokenType: introspection.TokenType,
+	})
+}
+
+// Revoke godoc
+// @Summary OAuth 2.0 token revocation (RFC 7009)
+// @Description Revokes the pair of tokens and the whole session by either access or refresh token.
+// @Description Tokens issued to a confidential client require its credentials with HTTP Basic or client_id/client_secret form fields, a client could revoke only its own tokens.
+// @Description Unknown, invalid and already revoked tokens are answered with 200 as well.
+// @Tags oauth
+// @Accept  x-www-form-urlencoded
+// @Produce application/json
+// @Param token formData string true "access or refresh token"
+// @Param token_type_hint formData string false "access_token or refresh_token"
+// @Success 200
+// @Failure 400 {object} OAuthErrorResponse
+// @Failure 401 {object} OAuthErrorResponse
+// @Failure 500 {object} OAuthErrorResponse
+// @Router /oauth/revoke [post]
+func (oh OAuthHandler) Revoke(c echo.Context) error {
+	token := c.FormValue("token")
+	if token == "" {
+		return c.JSON(http.StatusBadRequest, OAuthErrorResponse{Error: "invalid_request", ErrorDescription: "token is required"})
+	}
+	clientId, clientSecret := clientCredentials(c)
+	if err := oh.oauthUsecase.Revoke(clientId, clientSecret, token, c.FormValue("token_type_hint")); err != nil {
+		return oh.oauthErrorResponse(c, err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// authenticateClient accepts client credentials from HTTP Basic authentication or from form fields
+func (oh OAuthHandler) authenticateClient(c echo.Context) error {
+	clientId, clientSecret := clientCredentials(c)
+	return oh.oauthUsecase.AuthenticateClient(clientId, clientSecret)
+}
+
+// clientCredentials returns credentials from HTTP Basic authentication or from form fields
+func clientCredentials(c echo.Context) (string, string) {
+	clientId, clientSecret, ok := c.Request().BasicAuth()
+	if !ok {
+		clientId, clientSecret = c.FormValue("client_id"), c.FormValue("client_secret")
+	}
+	return clientId, clientSecret
+}
+
+type OAuthTokenResponseBody struct {
+	AccessToken  string `json:"access_token" example:"eyJhbGciOiJFUzI1NiIsImtpZCI6IjIwMjQtMDEiLCJ0eXAiOiJKV1QifQ"`
+	TokenType    string `json:"token_type" example:"Bearer"`
+	ExpiresIn    int64  `json:"expires_in" example:"900"`
+	RefreshToken string `json:"refresh_token,omitempty" example:"eyJhbGciOiJFUzI1NiIsImtpZCI6IjIwMjQtMDEiLCJ0eXAiOiJKV1QifQ"`
+	IdToken      string `json:"id_token,omitempty" example:"eyJhbGciOiJFUzI1NiIsImtpZCI6IjIwMjQtMDEiLCJ0eXAiOiJKV1QifQ"`
+	Scope        string `json:"scope,omitempty" example:"users:read"`
+}
+
+func ModelToResponseOAuthToken(tokenDetails *models.TokenDetails) *OAuthTokenResponseBody {
+	return &OAuthTokenResponseBody{
+		AccessToken:  tokenDetails.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    tokenDetails.AtExpires - time.Now().Unix(),
+		RefreshToken: tokenDetails.RefreshToken,
+		IdToken:      tokenDetails.IdToken,
+		Scope:        tokenDetails.Scope,
+	}
+}
+
+// Token godoc
+// @Summary OAuth 2.0 token endpoint
+// @Description Supports authorization_code grant with PKCE, refresh_token grant and client_credentials grant for services.
+// @Description Confidential clients should authenticate with HTTP Basic or client_id/client_secret form fields.
+// @Tags oidc
+// @Accept  x-www-form-urlencoded
+// @Produce application/json
+// @Param grant_type formData string true "authorization_code, refresh_token or client_credentials"
+// @Param code formData string false "authorization code"
+// @Param redirect_uri formData string false "redirect uri of the authorization request"
+// @Param code_verifier formData string false "PKCE code verifier"
+// @Param refresh_token formData string false "refresh token"
+// @Param scope formData string false "space separated scopes of client_credentials grant"
+// @Success 200 {object} OAuthTokenResponseBody
+// @Failure 400 {object} OAuthErrorResponse
+// @Failure 401 {object} OAuthErrorResponse
+// @Failure 500 {object} OAuthErrorResponse
+// @Router /token [post]
+func (oh OAuthHandler) Token(c echo.Context) error {
+	clientId, clientSecret := clientCredentials(c)
+	var td *models.TokenDetails
+	var err error
+	switch c.FormValue("grant_type") {
+	case models.AuthorizationCodeGrantType:
+		td, err = oh.oauthUsecase.ExchangeAuthorizationCode(clientId, clientSecret, c.FormValue("code"), c.FormValue("redirect_uri"), c.FormValue("code_verifier"), c.Request().UserAgent(), c.RealIP())
+	case models.RefreshTokenGrantType:
+		td, err = oh.oauthUsecase.RefreshTokens(clientId, clientSecret, c.FormValue("refresh_token"))
+	case models.ClientCredentialsGrantType:
+		td, err = oh.oauthUsecase.IssueServiceToken(clientId, clientSecret, c.FormValue("scope"))
+	default:
+		return c.JSON(http.StatusBadRequest, OAuthErrorResponse{Error: "unsupported_grant_type"})
+	}
+	if err != nil {
+		return oh.oauthErrorResponse(c, err)
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, ModelToResponseOAuthToken(td))
+}
+
+type UserInfoResponseBody struct {
+	Sub           string `json:"sub" example:"550e8400-e29b-41d4-a716-446655440000"`
+	Email         string `json:"email,omitempty" example:"example@gmail.com"`
+	EmailVerified bool   `json:"email_verified,omitempty" example:"true"`
+	Role          string `json:"role" example:"customer"`
+}
+
+// UserInfo godoc
+// @Summary OpenID Connect userinfo endpoint
+// @Tags oidc
+// @Produce application/json
+// @Security BearerAuth
+// @Success 200 {object} UserInfoResponseBody
+// @Failure 401 {object} Response
+// @Failure 500 {object} Response
+// @Router /userinfo [get]
+func (oh OAuthHandler) UserInfo(c echo.Context) error {
+	accessTokenClaims := c.Get("accessTokenClaims").(*models.AccessTokenClaims)
+	user, err := oh.oauthUsecase.GetUserInfo(accessTokenClaims.UserUuid)
+	if err != nil {
+		return oh.ErrorResponse(c, http.StatusInternalServerError, "could not get user", err)
+	}
+	response := UserInfoResponseBody{
+		Sub:  user.Uuid.String(),
+		Role: user.Role.String(),
+	}
+	// tokens of OpenID Connect clients get the email only with the email scope
+	if accessTokenClaims.Audience == "" || helpers.Contains(strings.Fields(accessTokenClaims.Scope), "email") {
+		response.Email = user.Email
+		response.EmailVerified = user.IsActive
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+type OpenidConfigurationResponseBody struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JwksUri                           string   `json:"jwks_uri"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IdTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// OpenidConfiguration godoc
+// @Summary OpenID Connect discovery document
+// @Tags oidc
+// @Produce application/json
+// @Success 200 {object} OpenidConfigurationResponseBody
+// @Router /.well-known/openid-configuration [get]
+func (oh OAuthHandler) OpenidConfiguration(c echo.Context) error {
+	issuer := oh.oauthUsecase.GetIssuer()
+	c.Response().Header().Set("Cache-Control", "public, max-age=3600")
+	return c.JSON(http.StatusOK, OpenidConfigurationResponseBody{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/authorize",
+		TokenEndpoint:                     issuer + "/token",
+		UserinfoEndpoint:                  issuer + "/userinfo",
+		JwksUri:                           issuer + "/.well-known/jwks.json",
+		IntrospectionEndpoint:             issuer + "/oauth/introspect",
+		RevocationEndpoint:                issuer + "/oauth/revoke",
+		ScopesSupported:                   []string{"openid", "email"},
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{models.AuthorizationCodeGrantType, models.RefreshTokenGrantType, models.ClientCredentialsGrantType},
+		SubjectTypesSupported:             []string{"public"},
+		IdTokenSigningAlgValuesSupported:  []string{"RS256", "ES256", "ES384", "ES512", "EdDSA"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "email", "email_verified"},
+	})
+}
+
+// oauthErrorResponse writes errors of OAuth endpoints in the RFC 6749 format
+func (oh OAuthHandler) oauthErrorResponse(c echo.Context, err error) error {
+	var oauthErr *models.OAuthError
+	if !errors.As(err, &oauthErr) {
+		oh.log.Errorf("oauth request failed: %s", err.Error())
+		return c.JSON(http.StatusInternalServerError, OAuthErrorResponse{Error: "server_error"})
+	}
+	statusCode := http.StatusBadRequest
+	if oauthErr.Code == "invalid_client" {
+		statusCode = http.StatusUnauthorized
+	}
+	return c.JSON(statusCode, OAuthErrorResponse{Error: oauthErr.Code, ErrorDescription: oauthErr.Description})
+}
@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/aerosystems/auth-service/internal/models"
+	"github.com/aerosystems/auth-service/pkg/webauthn"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type PasskeyHandler struct {
+	*BaseHandler
+	tokenUsecase   TokenUsecase
+	passkeyUsecase PasskeyUsecase
+	accountUsecase AccountUsecase
+}
+
+func NewPasskeyHandler(baseHandler *BaseHandler, tokenUsecase TokenUsecase, passkeyUsecase PasskeyUsecase, accountUsecase AccountUsecase) *PasskeyHandler {
+	return &PasskeyHandler{
+		BaseHandler:    baseHandler,
+		tokenUsecase:   tokenUsecase,
+		passkeyUsecase: passkeyUsecase,
+		accountUsecase: accountUsecase,
+	}
+}
+
+// PasskeyRegistrationRequestBody is PublicKeyCredential.toJSON() of navigator.credentials.create(), binary values are base64url encoded
+type PasskeyRegistrationRequestBody struct {
+	Name     string                         `json:"name" validate:"max=64" example:"MacBook Touch ID"`
+	Id       string                         `json:"id" validate:"required" example:"3q2-7w"`
+	Type     string                         `json:"type" validate:"required,eq=public-key" example:"public-key"`
+	Response AttestationResponseRequestBody `json:"response"`
+}
+
+type AttestationResponseRequestBody struct {
+	ClientDataJSON    string   `json:"clientDataJSON" validate:"required" example:"eyJ0eXBlIjoid2ViYXV0aG4uY3JlYXRlIn0"`
+	AttestationObject string   `json:"attestationObject" validate:"required" example:"o2NmbXRkbm9uZQ"`
+	Transports        []string `json:"transports" validate:"max=8,dive,max=16" example:"internal,hybrid"`
+}
+
+func (r PasskeyRegistrationRequestBody) ToModel() (*webauthn.AttestationResponse, error) {
+	clientDataJSON, err := decodeBase64Url(r.Response.ClientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+	attestationObject, err := decodeBase64Url(r.Response.AttestationObject)
+	if err != nil {
+		return nil, err
+	}
+	return &webauthn.AttestationResponse{
+		ClientDataJSON:    clientDataJSON,
+		AttestationObject: attestationObject,
+	}, nil
+}
+
+// PasskeySignInRequestBody is PublicKeyCredential.toJSON() of navigator.credentials.get(), binary values are base64url encoded
+type PasskeySignInRequestBody struct {
+	Id       string                       `json:"id" validate:"required" example:"3q2-7w"`
+	Type     string                       `json:"type" validate:"required,eq=public-key" example:"public-key"`
+	Response AssertionResponseRequestBody `json:"response"`
+}
+
+type AssertionResponseRequestBody struct {
+	ClientDataJSON    string `json:"clientDataJSON" validate:"required" example:"eyJ0eXBlIjoid2ViYXV0aG4uZ2V0In0"`
+	AuthenticatorData string `json:"authenticatorData" validate:"required" example:"SZYN5YgOjGh0NBcPZHZgW4_krrmihjLHmVzzuoMdl2MFAAAAAQ"`
+	Signature         string `json:"signature" validate:"required" example:"MEUCIQDk"`
+	UserHandle        string `json:"userHandle" example:"Ft5bLhLMQ2mYxTgTfVgYyA"`
+}
+
+func (r PasskeySignInRequestBody) ToModel() (*webauthn.AssertionResponse, error) {
+	var err error
+	response := new(webauthn.AssertionResponse)
+	if response.CredentialId, err = decodeBase64Url(r.Id); err != nil {
+		return nil, err
+	}
+	if response.ClientDataJSON, err = decodeBase64Url(r.Response.ClientDataJSON); err != nil {
+		return nil, err
+	}
+	if response.AuthenticatorData, err = decodeBase64Url(r.Response.AuthenticatorData); err != nil {
+		return nil, err
+	}
+	if response.Signature, err = decodeBase64Url(r.Response.Signature); err != nil {
+		return nil, err
+	}
+	if response.UserHandle, err = decodeBase64Url(r.Response.UserHandle); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+type PasskeyResponseBody struct {
+	Id         int        `json:"id" example:"1"`
+	Name       string     `json:"name" example:"MacBook Touch ID"`
+	Transports []string   `json:"transports" example:"internal,hybrid"`
+	LastUsedAt *time.Time `json:"lastUsedAt" example:"2024-01-01T00:00:00Z"`
+	CreatedAt  time.Time  `json:"createdAt" example:"2024-01-01T00:00:00Z"`
+}
+
+func ModelToResponsePasskey(passkey *models.Passkey) *PasskeyResponseBody {
+	transports := passkey.Transports
+	if transports == nil {
+		transports = []string{}
+	}
+	return &PasskeyResponseBody{
+		Id:         passkey.Id,
+		Name:       passkey.Name,
+		Transports: transports,
+		LastUsedAt: passkey.LastUsedAt,
+		CreatedAt:  passkey.CreatedAt,
+	}
+}
+
+func decodeBase64Url(value string) ([]byte, error) {
+	decoded, err := webauthn.Encoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return nil, errors.New("invalid base64url value")
+	}
+	return decoded, nil
+}
+
+// BeginPasskeyRegistration godoc
+// @Summary start passkey registration
+// @Description Returns options for navigator.credentials.create(), the challenge expires in 5 minutes
+// @Tags passkeys
+// @Produce application/json
+// @Security BearerAuth
+// @Success 200 {object} Response{data=webauthn.CreationOptions}
+// @Failure 400 {object} Response
+// @Failure 401 {object} Response
+// @Router /v1/users/passkeys/options [post]
+func (ph PasskeyHandler) BeginPasskeyRegistration(c echo.Context) error {
+	accessTokenClaims := c.Get("accessTokenClaims").(*models.AccessTokenClaims)
+	options, err := ph.passkeyUsecase.BeginRegistration(accessTokenClaims.UserUuid)
+	if err != nil {
+		return ph.ErrorResponse(c, http.StatusBadRequest, "could not start passkey registration", err)
+	}
+	return ph.SuccessResponse(c, http.StatusOK, "passkey registration was successfully started", options)
+}
+
+// FinishPasskeyRegistration godoc
+// @Summary register a passkey
+// @Tags passkeys
+// @Accept  json
+// @Produce application/json
+// @Security BearerAuth
+// @Param credential body PasskeyRegistrationRequestBody true "raw request body"
+// @Success 201 {object} Response{data=PasskeyResponseBody}
+// @Failure 400 {object} Response
+// @Failure 401 {object} Response
+// @Failure 422 {object} Response
+// @Router /v1/users/passkeys [post]
+func (ph PasskeyHandler) FinishPasskeyRegistration(c echo.Context) error {
+	accessTokenClaims := c.Get("accessTokenClaims").(*models.AccessTokenClaims)
+	var requestPayload PasskeyRegistrationRequestBody
+	if err := c.Bind(&requestPayload); err != nil {
+		return ph.ErrorResponse(c, http.StatusUnprocessableEntity, "could not read request body", err)
+	}
+	if err := c.Validate(requestPayload); err != nil {
+		return err
+	}
+	response, err := requestPayload.ToModel()
+	if err != nil {
+		return ph.ErrorResponse(c, http.StatusUnprocessableEntity, "could not read request body", err)
+	}
+	passkey, err := ph.passkeyUsecase.FinishRegistration(accessTokenClaims.UserUuid, requestPayload.Name, response, requestPayload.Response.Transports)
+	if err != nil {
+		return ph.ErrorResponse(c, http.StatusBadRequest, "could not register passkey", err)
+	}
+	return ph.SuccessResponse(c, http.StatusCreated, "passkey was successfully registered", ModelToResponsePasskey(passkey))
+}
+
+// GetPasskeys godoc
+// @Summary list passkeys of the user
+// @Tags passkeys
+// @Produce application/json
+// @Security BearerAuth
+// @Success 200 {object} Response{data=[]PasskeyResponseBody}
+// @Failure 401 {object} Response
+// @Failure 500 {object} Response
+// @Router /v1/users/passkeys [get]
+func (ph PasskeyHandler) GetPasskeys(c echo.Context) error {
+	accessTokenClaims := c.Get("accessTokenClaims").(*models.AccessTokenClaims)
+	passkeys, err := ph.passkeyUsecase.GetPasskeys(accessTokenClaims.UserUuid)
+	if err != nil {
+		return ph.ErrorResponse(c, http.StatusInternalServerError, "could not get passkeys", err)
+	}
+	res := make([]*PasskeyResponseBody, 0, len(passkeys))
+	for i := range passkeys {
+		res = append(res, ModelToResponsePasskey(&passkeys[i]))
+	}
+	return ph.SuccessResponse(c, http.StatusOK, "passkeys were successfully found", res)
+}
+
+// DeletePasskey godoc
+// @Summary delete a passkey of the user
+// @Tags passkeys
+// @Produce application/json
+// @Security BearerAuth
+// @Param passkeyId path int true "passkey id"
+// @Success 200 {object} Response
+// @Failure 400 {object} Response
+// @Failure 401 {object} Response
+// @Router /v1/users/passkeys/{passkeyId} [delete]
+func (ph PasskeyHandler) DeletePasskey(c echo.Context) error {
+	accessTokenClaims := c.Get("accessTokenClaims").(*models.AccessTokenClaims)
+	passkeyId, err := strconv.Atoi(c.Param("passkeyId"))
+	if err != nil {
+		return ph.ErrorResponse(c, http.StatusBadRequest, "invalid passkey id", err)
+	}
+	if err := ph.passkeyUsecase.DeletePasskey(accessTokenClaims.UserUuid, passkeyId); err != nil {
+		return ph.ErrorResponse(c, http.StatusBadRequest, "could not delete passkey", err)
+	}
+	return ph.SuccessResponse(c, http.StatusOK, "passkey was successfully deleted", nil)
+}
+
+// BeginPasskeySignIn godoc
+// @Summary start login with a passkey
+// @Description Returns options for navigator.credentials.get(), the challenge expires in 5 minutes
+// @Tags auth
+// @Produce application/json
+// @Success 200 {object} Response{data=webauthn.RequestOptions}
+// @Failure 500 {object} Response
+// @Router /v1/sign-in/passkey/options [post]
+func (ph PasskeyHandler) BeginPasskeySignIn(c echo.Context) error {
+	options, err := ph.passkeyUsecase.BeginLogin()
+	if err != nil {
+		return ph.ErrorResponse(c, http.StatusInternalServerError, "could not start passkey login", err)
+	}
+	return ph.SuccessResponse(c, http.StatusOK, "passkey login was successfully started", options)
+}
+
+// PasskeySignIn godoc
+// @Summary login with a passkey
+// @Description Takes the assertion of navigator.credentials.get(). Passkeys require user verification, so the second factor is not asked.
+// @Description Response contain pair JWT tokens
+// @Tags auth
+// @Accept  json
+// @Produce application/json
+// @Param credential body PasskeySignInRequestBody true "raw request body"
+// @Success 200 {object} Response{data=TokensResponseBody}
+// @Failure 401 {object} Response
+// @Failure 422 {object} Response
+// @Failure 500 {object} Response
+// @Router /v1/sign-in/passkey [post]
+func (ph PasskeyHandler) PasskeySignIn(c echo.Context) error {
+	var requestPayload PasskeySignInRequestBody
+	if err := c.Bind(&requestPayload); err != nil {
+		return ph.ErrorResponse(c, http.StatusUnprocessableEntity, "could not read request body", err)
+	}
+	if err := c.Validate(requestPayload); err != nil {
+		return err
+	}
+	response, err := requestPayload.ToModel()
+	if err != nil {
+		return ph.ErrorResponse(c, http.StatusUnprocessableEntity, "could not read request body", err)
+	}
+	user, err := ph.passkeyUsecase.FinishLogin(response)
+	if err != nil {
+		return ph.ErrorResponse(c, http.StatusUnauthorized, "could not verify passkey", err)
+	}
+	ph.cancelAccountDeletion(ph.accountUsecase, user)
+	ts, err := ph.tokenUsecase.CreateToken(user.Uuid.String(), user.Role.String(), c.Request().UserAgent(), c.RealIP())
+	if err != nil {
+		return ph.ErrorResponse(c, http.StatusInternalServerError, "could not create a pair of JWT tokens", err)
+	}
+	return ph.SuccessResponse(c, http.StatusOK, "user was successfully logged in", ModelToResponseTokenDetails(ts))
+}
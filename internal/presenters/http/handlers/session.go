@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"github.com/aerosystems/auth-service/internal/models"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+type SessionHandler struct {
+	*BaseHandler
+	tokenUsecase TokenUsecase
+}
+
+func NewSessionHandler(baseHandler *BaseHandler, tokenUsecase TokenUsecase) *SessionHandler {
+	return &SessionHandler{
+		BaseHandler:  baseHandler,
+		tokenUsecase: tokenUsecase,
+	}
+}
+
+type SessionResponseBody struct {
+	Uuid          string    `json:"uuid" example:"550e8400-e29b-41d4-a716-446655440000"`
+	UserAgent     string    `json:"userAgent" example:"Mozilla/5.0 (X11; Linux x86_64)"`
+	Ip            string    `json:"ip" example:"192.168.0.1"`
+	CreatedAt     time.Time `json:"createdAt" example:"2024-01-01T00:00:00Z"`
+	LastRefreshAt time.Time `json:"lastRefreshAt" example:"2024-01-01T00:00:00Z"`
+	IsCurrent     bool      `json:"isCurrent" example:"true"`
+}
+
+func ModelToResponseSession(session *models.Session, currentSessionUuid string) *SessionResponseBody {
+	return &SessionResponseBody{
+		Uuid:          session.Uuid.String(),
+		UserAgent:     session.UserAgent,
+		Ip:            session.Ip,
+		CreatedAt:     session.CreatedAt,
+		LastRefreshAt: session.LastRefreshAt,
+		IsCurrent:     session.Uuid.String() == currentSessionUuid,
+	}
+}
+
+// GetSessions godoc
+// @Summary list active sessions of the user
+// @Tags sessions
+// @Produce application/json
+// @Security BearerAuth
+// @Success 200 {object} Response{data=[]SessionResponseBody}
+// @Failure 401 {object} Response
+// @Failure 500 {object} Response
+// @Router /v1/sessions [get]
+func (sh SessionHandler) GetSessions(c echo.Context) error {
+	accessTokenClaims := c.Get("accessTokenClaims").(*models.AccessTokenClaims)
+	sessions, err := sh.tokenUsecase.GetSessions(accessTokenClaims.UserUuid)
+	if err != nil {
+		return sh.ErrorResponse(c, http.StatusInternalServerError, "could not get sessions", err)
+	}
+	currentSessionUuid, _ := sh.tokenUsecase.GetSessionUuid(accessTokenClaims.AccessUuid)
+	res := make([]*SessionResponseBody, 0, len(sessions))
+	for i := range sessions {
+		res = append(res, ModelToResponseSession(&sessions[i], currentSessionUuid))
+	}
+	return sh.SuccessResponse(c, http.StatusOK, "sessions were successfully found", res)
+}
+
+// RevokeSession godoc
+// @Summary sign out of one session
+// @Tags sessions
+// @Produce application/json
+// @Security BearerAuth
+// @Param id path string true "session uuid"
+// @Success 200 {object} Response
+// @Failure 401 {object} Response
+// @Failure 404 {object} Response
+// @Router /v1/sessions/{id} [delete]
+func (sh SessionHandler) RevokeSession(c echo.Context) error {
+	accessTokenClaims := c.Get("accessTokenClaims").(*models.AccessTokenClaims)
+	if err := sh.tokenUsecase.RevokeSession(accessTokenClaims.UserUuid, c.Param("id")); err != nil {
+		return sh.ErrorResponse(c, http.StatusNotFound, "session not found", err)
+	}
+	return sh.SuccessResponse(c, http.StatusOK, "session was successfully revoked", nil)
+}
+
+// RevokeSessions godoc
+// @Summary sign out everywhere
+// @Tags sessions
+// @Produce application/json
+// @Security BearerAuth
+// @Success 200 {object} Response
+// @Failure 401 {object} Response
+// @Failure 500 {object} Response
+// @Router /v1/sessions [delete]
+func (sh SessionHandler) RevokeSessions(c echo.Context) error {
+	accessTokenClaims := c.Get("accessTokenClaims").(*models.AccessTokenClaims)
+	if err := sh.tokenUsecase.RevokeSessions(accessTokenClaims.UserUuid); err != nil {
+		return sh.ErrorResponse(c, http.StatusInternalServerError, "could not revoke sessions", err)
+	}
+	return sh.SuccessResponse(c, http.StatusOK, "sessions were successfully revoked", nil)
+}
@@ -1,27 +1,41 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"github.com/aerosystems/auth-service/internal/models"
 	"github.com/labstack/echo/v4"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type UserHandler struct {
 	*BaseHandler
-	tokenUsecase TokenUsecase
-	authUsecase  AuthUsecase
+	tokenUsecase   TokenUsecase
+	authUsecase    AuthUsecase
+	mfaUsecase     MfaUsecase
+	lockoutUsecase LockoutUsecase
+	accountUsecase AccountUsecase
+	exportUsecase  ExportUsecase
 }
 
-func NewUserHandler(baseHandler *BaseHandler, tokenUsecase TokenUsecase, userUsecase AuthUsecase) *UserHandler {
+func NewUserHandler(baseHandler *BaseHandler, tokenUsecase TokenUsecase, userUsecase AuthUsecase, mfaUsecase MfaUsecase, lockoutUsecase LockoutUsecase, accountUsecase AccountUsecase, exportUsecase ExportUsecase) *UserHandler {
 	return &UserHandler{
-		BaseHandler:  baseHandler,
-		tokenUsecase: tokenUsecase,
-		authUsecase:  userUsecase,
+		BaseHandler:    baseHandler,
+		tokenUsecase:   tokenUsecase,
+		authUsecase:    userUsecase,
+		mfaUsecase:     mfaUsecase,
+		lockoutUsecase: lockoutUsecase,
+		accountUsecase: accountUsecase,
+		exportUsecase:  exportUsecase,
 	}
 }
 
 type CodeRequestBody struct {
-	Code string `json:"code" validate:"required,numeric,len=6" example:"012345"`
+	Email string `json:"email" validate:"required,email" example:"example@gmail.com"`
+	Code  string `json:"code" validate:"required,max=64" example:"012345"`
 }
 
 type UserRequestBody struct {
@@ -29,6 +43,37 @@ type UserRequestBody struct {
 	Password string `json:"password" validate:"required,customPasswordRule" example:"P@ssw0rd"`
 }
 
+type EmailRequestBody struct {
+	Email string `json:"email" validate:"required,email" example:"example@gmail.com"`
+}
+
+type SignInCodeRequestBody struct {
+	Email string `json:"email" validate:"required,email" example:"example@gmail.com"`
+	Code  string `json:"code" validate:"required,max=64" example:"012345"`
+}
+
+type ResendCodeRequestBody struct {
+	Email string `json:"email" validate:"required,email" example:"example@gmail.com"`
+	Kind  string `json:"kind" validate:"required,oneof=registration login" example:"registration"`
+}
+
+type ChangePasswordRequestBody struct {
+	CurrentPassword string `json:"currentPassword" validate:"required" example:"P@ssw0rd"`
+	NewPassword     string `json:"newPassword" validate:"required,customPasswordRule" example:"N3wP@ssw0rd"`
+}
+
+type PasswordRequestBody struct {
+	Password string `json:"password" example:"P@ssw0rd"`
+}
+
+type AccountDeletionResponseBody struct {
+	DeleteAt time.Time `json:"deleteAt" example:"2024-01-15T10:00:00Z"`
+}
+
+type RoleRequestBody struct {
+	Role string `json:"role" validate:"required,oneof=customer staff" example:"staff"`
+}
+
 type UserResponseBody struct {
 	Uuid  string `json:"uuid" example:"550e8400-e29b-41d4-a716-446655440000"`
 	Email string `json:"email" example:"example@gmail.com"`
@@ -43,6 +88,82 @@ func ModelToResponse(user *models.User) *UserResponseBody {
 	}
 }
 
+type ProfileExportResponseBody struct {
+	Uuid      string     `json:"uuid" example:"550e8400-e29b-41d4-a716-446655440000"`
+	Email     string     `json:"email" example:"example@gmail.com"`
+	Role      string     `json:"role" example:"customer"`
+	IsActive  bool       `json:"isActive" example:"true"`
+	DeleteAt  *time.Time `json:"deleteAt,omitempty" example:"2024-01-15T10:00:00Z"`
+	CreatedAt time.Time  `json:"createdAt" example:"2024-01-01T00:00:00Z"`
+	UpdatedAt time.Time  `json:"updatedAt" example:"2024-01-01T00:00:00Z"`
+}
+
+type LoginEventResponseBody struct {
+	SessionUuid string    `json:"sessionUuid" example:"550e8400-e29b-41d4-a716-446655440000"`
+	UserAgent   string    `json:"userAgent" example:"Mozilla/5.0 (X11; Linux x86_64)"`
+	Ip          string    `json:"ip" example:"192.168.0.1"`
+	CreatedAt   time.Time `json:"createdAt" example:"2024-01-01T00:00:00Z"`
+}
+
+// PendingCodeResponseBody describes a code sent to the user, the value itself is not kept
+type PendingCodeResponseBody struct {
+	Kind      string    `json:"kind" example:"registration"`
+	Attempts  int       `json:"attempts" example:"0"`
+	ExpireAt  time.Time `json:"expireAt" example:"2024-01-01T00:10:00Z"`
+	CreatedAt time.Time `json:"createdAt" example:"2024-01-01T00:00:00Z"`
+}
+
+type UserExportResponseBody struct {
+	Profile      ProfileExportResponseBody `json:"profile"`
+	Identities   []IdentityResponseBody    `json:"identities"`
+	Sessions     []SessionResponseBody     `json:"sessions"`
+	LoginHistory []LoginEventResponseBody  `json:"loginHistory"`
+	PendingCodes []PendingCodeResponseBody `json:"pendingCodes"`
+	ExportedAt   time.Time                 `json:"exportedAt" example:"2024-01-01T00:00:00Z"`
+}
+
+func ModelToResponseUserExport(export *models.UserExport) *UserExportResponseBody {
+	response := &UserExportResponseBody{
+		Profile: ProfileExportResponseBody{
+			Uuid:      export.User.Uuid.String(),
+			Email:     export.User.Email,
+			Role:      export.User.Role.String(),
+			IsActive:  export.User.IsActive,
+			DeleteAt:  export.User.DeleteAt,
+			CreatedAt: export.User.CreatedAt,
+			UpdatedAt: export.User.UpdatedAt,
+		},
+		Identities:   make([]IdentityResponseBody, 0, len(export.Identities)),
+		Sessions:     make([]SessionResponseBody, 0, len(export.Sessions)),
+		LoginHistory: make([]LoginEventResponseBody, 0, len(export.LoginHistory)),
+		PendingCodes: make([]PendingCodeResponseBody, 0, len(export.PendingCodes)),
+		ExportedAt:   export.ExportedAt,
+	}
+	for i := range export.Identities {
+		response.Identities = append(response.Identities, *ModelToResponseIdentity(&export.Identities[i]))
+	}
+	for i := range export.Sessions {
+		response.Sessions = append(response.Sessions, *ModelToResponseSession(&export.Sessions[i], ""))
+	}
+	for _, event := range export.LoginHistory {
+		response.LoginHistory = append(response.LoginHistory, LoginEventResponseBody{
+			SessionUuid: event.SessionUuid.String(),
+			UserAgent:   event.UserAgent,
+			Ip:          event.Ip,
+			CreatedAt:   event.CreatedAt,
+		})
+	}
+	for _, code := range export.PendingCodes {
+		response.PendingCodes = append(response.PendingCodes, PendingCodeResponseBody{
+			Kind:      code.Action.String(),
+			Attempts:  code.Attempts,
+			ExpireAt:  code.ExpireAt,
+			CreatedAt: code.CreatedAt,
+		})
+	}
+	return response
+}
+
 // SignUp godoc
 // @Summary registration user by credentials
 // @Description Password should contain:
@@ -82,16 +203,18 @@ func (uh UserHandler) SignUp(c echo.Context) error {
 // @Description - minimum of one digit
 // @Description - minimum of one special character
 // @Description - minimum 8 characters length
-// @Description Response contain pair JWT tokens
+// @Description Response contain pair JWT tokens, or an mfa token for users with two-factor authentication, see /v1/sign-in/mfa
 // @Tags auth
 // @Accept  json
 // @Produce application/json
 // @Param login body UserRequestBody true "raw request body"
 // @Success 200 {object} Response{data=TokensResponseBody}
+// @Success 202 {object} Response{data=MfaChallengeResponseBody}
 // @Failure 400 {object} Response
 // @Failure 401 {object} Response
 // @Failure 404 {object} Response
 // @Failure 422 {object} Response
+// @Failure 429 {object} Response
 // @Failure 500 {object} Response
 // @Router /v1/sign-in [post]
 func (uh UserHandler) SignIn(c echo.Context) error {
@@ -99,18 +222,104 @@ func (uh UserHandler) SignIn(c echo.Context) error {
 	if err := c.Bind(&requestPayload); err != nil {
 		return uh.ErrorResponse(c, http.StatusUnprocessableEntity, "could not read request body", err)
 	}
+	if retryAfter, err := uh.lockoutUsecase.CheckSignIn(requestPayload.Email, c.RealIP()); err != nil {
+		return uh.signInRejectedResponse(c, retryAfter, err)
+	}
 	user, err := uh.authUsecase.GetActiveUserByEmail(requestPayload.Email)
 	if err != nil {
+		uh.registerSignInFailure(uh.lockoutUsecase, requestPayload.Email, c.RealIP())
 		return uh.ErrorResponse(c, http.StatusNotFound, "user not found", err)
 	}
 	if _, err := uh.authUsecase.CheckPassword(user, requestPayload.Password); err != nil {
+		uh.registerSignInFailure(uh.lockoutUsecase, requestPayload.Email, c.RealIP())
 		return uh.ErrorResponse(c, http.StatusUnauthorized, "invalid credentials", err)
 	}
-	ts, err := uh.tokenUsecase.CreateToken(user.Uuid.String(), user.Role.String())
+	uh.lockoutUsecase.RegisterSuccess(requestPayload.Email)
+	return uh.signInResponse(c, uh.tokenUsecase, uh.mfaUsecase, uh.accountUsecase, user)
+}
+
+// SendSignInCode godoc
+// @Summary send a one-time sign in code by email
+// @Description The response is the same whether the email is registered or not. A new request makes the previous code invalid.
+// @Tags auth
+// @Accept  json
+// @Produce application/json
+// @Param login body EmailRequestBody true "raw request body"
+// @Success 200 {object} Response
+// @Failure 422 {object} Response
+// @Failure 500 {object} Response
+// @Router /v1/sign-in/code [post]
+func (uh UserHandler) SendSignInCode(c echo.Context) error {
+	var requestPayload EmailRequestBody
+	if err := c.Bind(&requestPayload); err != nil {
+		return uh.ErrorResponse(c, http.StatusUnprocessableEntity, "could not read request body", err)
+	}
+	if err := c.Validate(requestPayload); err != nil {
+		return err
+	}
+	if err := uh.authUsecase.SendLoginCode(requestPayload.Email); err != nil {
+		return uh.ErrorResponse(c, http.StatusInternalServerError, "could not send sign in code", err)
+	}
+	return uh.SuccessResponse(c, http.StatusOK, "if the email is registered, a sign in code was sent", nil)
+}
+
+// ResendCode godoc
+// @Summary send a new registration or sign in code by email
+// @Description The response is the same whether the email is registered or not. A code could be resent once a minute and 5 times a day by default. A new code makes the previous one invalid.
+// @Tags auth
+// @Accept  json
+// @Produce application/json
+// @Param code body ResendCodeRequestBody true "raw request body"
+// @Success 200 {object} Response
+// @Failure 422 {object} Response
+// @Router /v1/confirm/resend [post]
+func (uh UserHandler) ResendCode(c echo.Context) error {
+	var requestPayload ResendCodeRequestBody
+	if err := c.Bind(&requestPayload); err != nil {
+		return uh.ErrorResponse(c, http.StatusUnprocessableEntity, "could not read request body", err)
+	}
+	if err := c.Validate(requestPayload); err != nil {
+		return err
+	}
+	// failures are only logged, an error response would reveal that the email is registered
+	if err := uh.authUsecase.ResendCode(requestPayload.Email, models.CodeFromString(requestPayload.Kind)); err != nil {
+		uh.log.Errorf("could not resend code: %s", err.Error())
+	}
+	return uh.SuccessResponse(c, http.StatusOK, "if the email is waiting for a code, a new code was sent", nil)
+}
+
+// SignInWithCode godoc
+// @Summary login user by one-time code from email
+// @Description Response contain pair JWT tokens, or an mfa token for users with two-factor authentication, see /v1/sign-in/mfa
+// @Tags auth
+// @Accept  json
+// @Produce application/json
+// @Param login body SignInCodeRequestBody true "raw request body"
+// @Success 200 {object} Response{data=TokensResponseBody}
+// @Success 202 {object} Response{data=MfaChallengeResponseBody}
+// @Failure 401 {object} Response
+// @Failure 422 {object} Response
+// @Failure 429 {object} Response
+// @Failure 500 {object} Response
+// @Router /v1/sign-in/code/verify [post]
+func (uh UserHandler) SignInWithCode(c echo.Context) error {
+	var requestPayload SignInCodeRequestBody
+	if err := c.Bind(&requestPayload); err != nil {
+		return uh.ErrorResponse(c, http.StatusUnprocessableEntity, "could not read request body", err)
+	}
+	if err := c.Validate(requestPayload); err != nil {
+		return err
+	}
+	if retryAfter, err := uh.lockoutUsecase.CheckSignIn(requestPayload.Email, c.RealIP()); err != nil {
+		return uh.signInRejectedResponse(c, retryAfter, err)
+	}
+	user, err := uh.authUsecase.SignInWithCode(requestPayload.Email, requestPayload.Code)
 	if err != nil {
-		return uh.ErrorResponse(c, http.StatusInternalServerError, "could not create a pair of JWT tokens", err)
+		uh.registerSignInFailure(uh.lockoutUsecase, requestPayload.Email, c.RealIP())
+		return uh.ErrorResponse(c, http.StatusUnauthorized, "invalid code", err)
 	}
-	return uh.SuccessResponse(c, http.StatusOK, "user was successfully logged in", ModelToResponseTokenDetails(ts))
+	uh.lockoutUsecase.RegisterSuccess(requestPayload.Email)
+	return uh.signInResponse(c, uh.tokenUsecase, uh.mfaUsecase, uh.accountUsecase, user)
 }
 
 // SignOut godoc
@@ -152,8 +361,49 @@ func (uh UserHandler) GetUser(c echo.Context) error {
 	return uh.SuccessResponse(c, http.StatusOK, "user was successfully found", ModelToResponse(user))
 }
 
+// ExportUser godoc
+// @Summary export personal data of the user
+// @Description Returns the profile, linked identities, active sessions, login history and pending codes as a JSON attachment
+// @Tags users
+// @Produce application/json
+// @Security BearerAuth
+// @Success 200 {object} Response{data=UserExportResponseBody}
+// @Failure 401 {object} Response
+// @Failure 500 {object} Response
+// @Router /v1/users/export [get]
+func (uh UserHandler) ExportUser(c echo.Context) error {
+	accessTokenClaims := c.Get("accessTokenClaims").(*models.AccessTokenClaims)
+	return uh.exportUserResponse(c, accessTokenClaims.UserUuid)
+}
+
+// ExportUserByUuid godoc
+// @Summary export personal data of any user
+// @Description Returns the profile, linked identities, active sessions, login history and pending codes as a JSON attachment
+// @Tags users
+// @Produce application/json
+// @Security BearerAuth
+// @Param uuid path string true "user uuid"
+// @Success 200 {object} Response{data=UserExportResponseBody}
+// @Failure 400 {object} Response
+// @Failure 401 {object} Response
+// @Failure 403 {object} Response
+// @Router /v1/users/{uuid}/export [get]
+func (uh UserHandler) ExportUserByUuid(c echo.Context) error {
+	return uh.exportUserResponse(c, c.Param("uuid"))
+}
+
+func (uh UserHandler) exportUserResponse(c echo.Context, userUuid string) error {
+	export, err := uh.exportUsecase.ExportUser(userUuid)
+	if err != nil {
+		return uh.ErrorResponse(c, http.StatusBadRequest, "could not export user", err)
+	}
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"user-%s.json\"", export.User.Uuid.String()))
+	return uh.SuccessResponse(c, http.StatusOK, "user was successfully exported", ModelToResponseUserExport(export))
+}
+
 // Confirm godoc
-// @Summary confirm registration/reset password with 6-digit code from email/sms
+// @Summary confirm registration/reset password/email change with the code sent by email
+// @Description The code is invalidated after 5 wrong attempts
 // @Tags auth
 // @Accept  json
 // @Produce application/json
@@ -171,7 +421,7 @@ func (uh UserHandler) Confirm(c echo.Context) error {
 	if err := c.Validate(requestPayload); err != nil {
 		return err
 	}
-	code, err := uh.authUsecase.GetCode(requestPayload.Code)
+	code, err := uh.authUsecase.GetCode(requestPayload.Email, requestPayload.Code)
 	if err != nil {
 		return uh.ErrorResponse(c, http.StatusBadRequest, err.Error(), err)
 	}
@@ -181,6 +431,107 @@ func (uh UserHandler) Confirm(c echo.Context) error {
 	return uh.SuccessResponse(c, http.StatusOK, "code was successfully confirmed", nil)
 }
 
+// ChangePassword godoc
+// @Summary change password of the user
+// @Description New password should contain:
+// @Description - minimum of one small case letter
+// @Description - minimum of one upper case letter
+// @Description - minimum of one digit
+// @Description - minimum of one special character
+// @Description - minimum 8 characters length
+// @Description Other sessions of the user are signed out and a pending password reset is cancelled. Wrong current passwords count as failed sign ins, the same lockout applies.
+// @Tags users
+// @Accept  json
+// @Produce application/json
+// @Security BearerAuth
+// @Param password body ChangePasswordRequestBody true "raw request body"
+// @Success 200 {object} Response
+// @Failure 400 {object} Response
+// @Failure 401 {object} Response
+// @Failure 404 {object} Response
+// @Failure 422 {object} Response
+// @Failure 429 {object} Response
+// @Router /v1/users/password [put]
+func (uh UserHandler) ChangePassword(c echo.Context) error {
+	accessTokenClaims := c.Get("accessTokenClaims").(*models.AccessTokenClaims)
+	var requestPayload ChangePasswordRequestBody
+	if err := c.Bind(&requestPayload); err != nil {
+		return uh.ErrorResponse(c, http.StatusUnprocessableEntity, "could not read request body", err)
+	}
+	if err := c.Validate(requestPayload); err != nil {
+		return err
+	}
+	retryAfter, err := uh.authUsecase.ChangePassword(accessTokenClaims.UserUuid, accessTokenClaims.AccessUuid, c.RealIP(), requestPayload.CurrentPassword, requestPayload.NewPassword)
+	if err != nil {
+		switch {
+		case retryAfter > 0:
+			return uh.signInRejectedResponse(c, retryAfter, err)
+		case errors.Is(err, models.ErrUserNotFound):
+			return uh.ErrorResponse(c, http.StatusNotFound, "user not found", err)
+		default:
+			return uh.ErrorResponse(c, http.StatusBadRequest, "could not change password", err)
+		}
+	}
+	return uh.SuccessResponse(c, http.StatusOK, "password was successfully changed", nil)
+}
+
+// DeleteUser godoc
+// @Summary schedule deletion of the account
+// @Description The account is deleted after a grace period, 14 days by default, signing in before cancels the deletion. All sessions are signed out. The password is required if the account has one, otherwise the user must have signed in within the last 10 minutes.
+// @Tags users
+// @Accept  json
+// @Produce application/json
+// @Security BearerAuth
+// @Param password body PasswordRequestBody true "raw request body"
+// @Success 202 {object} Response{data=AccountDeletionResponseBody}
+// @Failure 400 {object} Response
+// @Failure 401 {object} Response
+// @Failure 422 {object} Response
+// @Router /v1/users [delete]
+func (uh UserHandler) DeleteUser(c echo.Context) error {
+	accessTokenClaims := c.Get("accessTokenClaims").(*models.AccessTokenClaims)
+	var requestPayload PasswordRequestBody
+	if err := c.Bind(&requestPayload); err != nil {
+		return uh.ErrorResponse(c, http.StatusUnprocessableEntity, "could not read request body", err)
+	}
+	if err := c.Validate(requestPayload); err != nil {
+		return err
+	}
+	deleteAt, err := uh.accountUsecase.ScheduleDeletion(accessTokenClaims.UserUuid, accessTokenClaims.AccessUuid, requestPayload.Password)
+	if err != nil {
+		return uh.ErrorResponse(c, http.StatusBadRequest, "could not delete account", err)
+	}
+	return uh.SuccessResponse(c, http.StatusAccepted, "account is scheduled for deletion", AccountDeletionResponseBody{DeleteAt: deleteAt})
+}
+
+// ChangeEmail godoc
+// @Summary change email of the user
+// @Description A confirmation code is sent to the new email and a security notice to the current one. The email is changed once the code is confirmed with /v1/confirm together with the current email.
+// @Tags users
+// @Accept  json
+// @Produce application/json
+// @Security BearerAuth
+// @Param email body EmailRequestBody true "raw request body"
+// @Success 200 {object} Response
+// @Failure 400 {object} Response
+// @Failure 401 {object} Response
+// @Failure 422 {object} Response
+// @Router /v1/users/email [post]
+func (uh UserHandler) ChangeEmail(c echo.Context) error {
+	accessTokenClaims := c.Get("accessTokenClaims").(*models.AccessTokenClaims)
+	var requestPayload EmailRequestBody
+	if err := c.Bind(&requestPayload); err != nil {
+		return uh.ErrorResponse(c, http.StatusUnprocessableEntity, "could not read request body", err)
+	}
+	if err := c.Validate(requestPayload); err != nil {
+		return err
+	}
+	if err := uh.authUsecase.ChangeEmail(accessTokenClaims.UserUuid, requestPayload.Email, c.RealIP()); err != nil {
+		return uh.ErrorResponse(c, http.StatusBadRequest, "could not change email", err)
+	}
+	return uh.SuccessResponse(c, http.StatusOK, "confirmation code was sent to the new email", nil)
+}
+
 // ResetPassword godoc
 // @Summary resetting password
 // @Description Password should contain:
@@ -208,3 +559,85 @@ func (uh UserHandler) ResetPassword(c echo.Context) error {
 	}
 	return uh.SuccessResponse(c, http.StatusOK, "password was successfully reset", nil)
 }
+
+// ChangeRole godoc
+// @Summary change role of the user
+// @Description All sessions of the user are revoked, because their tokens carry the previous role
+// @Tags users
+// @Accept  json
+// @Produce application/json
+// @Security BearerAuth
+// @Param uuid path string true "user uuid"
+// @Param role body RoleRequestBody true "raw request body"
+// @Success 200 {object} Response
+// @Failure 400 {object} Response
+// @Failure 401 {object} Response
+// @Failure 403 {object} Response
+// @Failure 422 {object} Response
+// @Router /v1/users/{uuid}/role [put]
+func (uh UserHandler) ChangeRole(c echo.Context) error {
+	var requestPayload RoleRequestBody
+	if err := c.Bind(&requestPayload); err != nil {
+		return uh.ErrorResponse(c, http.StatusUnprocessableEntity, "could not read request body", err)
+	}
+	if err := c.Validate(requestPayload); err != nil {
+		return err
+	}
+	if err := uh.authUsecase.ChangeRole(c.Param("uuid"), models.RoleFromString(requestPayload.Role)); err != nil {
+		return uh.ErrorResponse(c, http.StatusBadRequest, "could not change user role", err)
+	}
+	return uh.SuccessResponse(c, http.StatusOK, "user role was successfully changed", nil)
+}
+
+// Deactivate godoc
+// @Summary deactivate user
+// @Description All sessions of the user are revoked
+// @Tags users
+// @Produce application/json
+// @Security BearerAuth
+// @Param uuid path string true "user uuid"
+// @Success 200 {object} Response
+// @Failure 400 {object} Response
+// @Failure 401 {object} Response
+// @Failure 403 {object} Response
+// @Router /v1/users/{uuid}/deactivate [post]
+func (uh UserHandler) Deactivate(c echo.Context) error {
+	if err := uh.authUsecase.Deactivate(c.Param("uuid")); err != nil {
+		return uh.ErrorResponse(c, http.StatusBadRequest, "could not deactivate user", err)
+	}
+	return uh.SuccessResponse(c, http.StatusOK, "user was successfully deactivated", nil)
+}
+
+// Unlock godoc
+// @Summary unlock user
+// @Description Lifts the lock after too many failed sign in attempts before it expires
+// @Tags users
+// @Produce application/json
+// @Security BearerAuth
+// @Param uuid path string true "user uuid"
+// @Success 200 {object} Response
+// @Failure 400 {object} Response
+// @Failure 401 {object} Response
+// @Failure 403 {object} Response
+// @Router /v1/users/{uuid}/unlock [post]
+func (uh UserHandler) Unlock(c echo.Context) error {
+	if err := uh.lockoutUsecase.Unlock(c.Param("uuid")); err != nil {
+		return uh.ErrorResponse(c, http.StatusBadRequest, "could not unlock user", err)
+	}
+	return uh.SuccessResponse(c, http.StatusOK, "user was successfully unlocked", nil)
+}
+
+// signInRejectedResponse answers an attempt rejected before credentials are checked, the client should wait retryAfter
+func (h BaseHandler) signInRejectedResponse(c echo.Context, retryAfter time.Duration, err error) error {
+	if retryAfter <= 0 {
+		return h.ErrorResponse(c, http.StatusInternalServerError, "could not check failed sign in attempts", err)
+	}
+	c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	return h.ErrorResponse(c, http.StatusTooManyRequests, err.Error(), err)
+}
+
+func (h BaseHandler) registerSignInFailure(lockoutUsecase LockoutUsecase, email, clientIp string) {
+	if err := lockoutUsecase.RegisterFailure(email, clientIp); err != nil {
+		h.log.Errorf("could not register failed sign in: %s", err.Error())
+	}
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"github.com/aerosystems/auth-service/internal/models"
+	"github.com/aerosystems/auth-service/internal/usecases"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// emptyUserRepo has no users, methods which are not overridden panic
+type emptyUserRepo struct {
+	usecases.UserRepository
+}
+
+func (emptyUserRepo) GetByEmail(Email string) (*models.User, error) {
+	return nil, nil
+}
+
+func (emptyUserRepo) GetByUuid(Uuid uuid.UUID) (*models.User, error) {
+	return nil, nil
+}
+
+func newTestBaseHandler() *BaseHandler {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return NewBaseHandler(log, "dev")
+}
+
+func TestSignInUnknownEmail(t *testing.T) {
+	cache := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	userRepo := emptyUserRepo{}
+	authUsecase := usecases.NewAuthUsecase(nil, userRepo, nil, nil, nil, nil, nil, 0, 6, "0123456789", make([]byte, 32), 0, 0)
+	lockoutUsecase := usecases.NewLockoutUsecase(cache, userRepo, nil, 1, 10, 15)
+	uh := NewUserHandler(newTestBaseHandler(), nil, authUsecase, nil, lockoutUsecase, nil, nil)
+
+	signIn := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/v1/sign-in", strings.NewReader(`{"email":"unknown@example.com","password":"P@ssw0rd"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		if err := uh.SignIn(echo.New().NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code
+	}
+
+	if code := signIn(); code != http.StatusNotFound {
+		t.Fatalf("expected %d for an unknown email, got %d", http.StatusNotFound, code)
+	}
+	// the miss counts as a failed sign in, so guessing emails is throttled like guessing passwords
+	if code := signIn(); code != http.StatusTooManyRequests {
+		t.Fatalf("expected %d after a failed sign in, got %d", http.StatusTooManyRequests, code)
+	}
+}
+
+func TestChangePasswordOfMissingUser(t *testing.T) {
+	authUsecase := usecases.NewAuthUsecase(nil, emptyUserRepo{}, nil, nil, nil, nil, nil, 0, 6, "0123456789", make([]byte, 32), 0, 0)
+	uh := NewUserHandler(newTestBaseHandler(), nil, authUsecase, nil, nil, nil, nil)
+
+	req := httptest.NewRequest(http.MethodPut, "/v1/users/password", strings.NewReader(`{"currentPassword":"P@ssw0rd","newPassword":"N3w-P@ssw0rd"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e := echo.New()
+	e.Validator = noopValidator{}
+	c := e.NewContext(req, rec)
+	c.Set("accessTokenClaims", &models.AccessTokenClaims{UserUuid: uuid.NewString(), AccessUuid: uuid.NewString()})
+	if err := uh.ChangePassword(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected %d for a deleted user, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+type noopValidator struct{}
+
+func (noopValidator) Validate(i interface{}) error {
+	return nil
+}
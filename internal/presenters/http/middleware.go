@@ -2,8 +2,8 @@ package HttpServer
 
 import (
 	"errors"
+	"github.com/aerosystems/auth-service/internal/helpers"
 	"github.com/aerosystems/auth-service/internal/models"
-	"github.com/golang-jwt/jwt"
 	echojwt "github.com/labstack/echo-jwt/v4"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -13,7 +13,23 @@ import (
 )
 
 func (s *Server) setupMiddleware() {
+	s.setupIPExtractor()
 	s.addLog(s.log)
+	s.addRateLimit()
+}
+
+// setupIPExtractor makes RealIP return the address of the connection, unless it is one of the trusted proxies, then
+// the client address is taken from X-Forwarded-For. Otherwise any client could spoof its IP for rate limits and lockouts.
+func (s *Server) setupIPExtractor() {
+	if len(s.trustedProxies) == 0 {
+		s.echo.IPExtractor = echo.ExtractIPDirect()
+		return
+	}
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, ipNet := range s.trustedProxies {
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+	s.echo.IPExtractor = echo.ExtractIPFromXFFHeader(options...)
 }
 
 func (s *Server) addLog(log *logrus.Logger) {
@@ -41,9 +57,19 @@ func (s *Server) addCORS() {
 	s.echo.Use(middleware.CORSWithConfig(DefaultCORSConfig))
 }
 
+// AuthTokenMiddleware accepts first-party access tokens of the roles, tokens issued to OpenID Connect clients are rejected
 func (s *Server) AuthTokenMiddleware(roles ...models.KindRole) echo.MiddlewareFunc {
+	return s.tokenMiddleware(false, roles...)
+}
+
+// OidcTokenMiddleware accepts first-party access tokens of the roles and tokens issued to OpenID Connect clients with
+// the openid scope
+func (s *Server) OidcTokenMiddleware(roles ...models.KindRole) echo.MiddlewareFunc {
+	return s.tokenMiddleware(true, roles...)
+}
+
+func (s *Server) tokenMiddleware(allowClients bool, roles ...models.KindRole) echo.MiddlewareFunc {
 	AuthorizationConfig := echojwt.Config{
-		SigningKey:     []byte(s.accessSecret),
 		ParseTokenFunc: s.parseToken,
 		ErrorHandler: func(c echo.Context, err error) error {
 			return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
@@ -55,14 +81,26 @@ func (s *Server) AuthTokenMiddleware(roles ...models.KindRole) echo.MiddlewareFu
 			if err != nil {
 				return AuthorizationConfig.ErrorHandler(c, err)
 			}
-			accessTokenClaims, err := s.DecodeAccessToken(token)
+			accessTokenClaims, err := s.tokenUsecase.DecodeAccessToken(token)
 			if err != nil {
 				return AuthorizationConfig.ErrorHandler(c, err)
 			}
+			// a revoked or expired session has no access entry in the cache anymore
+			if _, err := s.tokenUsecase.GetCacheValue(accessTokenClaims.AccessUuid); err != nil {
+				return AuthorizationConfig.ErrorHandler(c, errors.New("token is revoked"))
+			}
+			if accessTokenClaims.Audience != "" {
+				if !allowClients {
+					return AuthorizationConfig.ErrorHandler(c, errors.New("token is issued to a client"))
+				}
+				if !helpers.Contains(strings.Fields(accessTokenClaims.Scope), "openid") {
+					return echo.NewHTTPError(http.StatusForbidden, "insufficient scope")
+				}
+			}
 			if !isAccess(roles, accessTokenClaims.UserRole) {
 				return echo.NewHTTPError(http.StatusForbidden, "access denied")
 			}
-			echo.Context(c).Set("accessTokenClaims", *accessTokenClaims)
+			echo.Context(c).Set("accessTokenClaims", accessTokenClaims)
 			return next(c)
 		}
 	}
@@ -70,27 +108,13 @@ func (s *Server) AuthTokenMiddleware(roles ...models.KindRole) echo.MiddlewareFu
 
 func (s *Server) parseToken(c echo.Context, auth string) (interface{}, error) {
 	_ = c
-	accessTokenClaims, err := s.DecodeAccessToken(auth)
+	accessTokenClaims, err := s.tokenUsecase.DecodeAccessToken(auth)
 	if err != nil {
 		return nil, err
 	}
 	return accessTokenClaims, nil
 }
 
-func (s *Server) DecodeAccessToken(tokenString string) (*models.AccessTokenClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &models.AccessTokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(s.accessSecret), nil
-	})
-	if err != nil {
-		return nil, err
-	}
-	if claims, ok := token.Claims.(*models.AccessTokenClaims); ok && token.Valid {
-		return claims, nil
-	} else {
-		return nil, err
-	}
-}
-
 func isAccess(roles []models.KindRole, role string) bool {
 	for _, r := range roles {
 		if r.String() == role {
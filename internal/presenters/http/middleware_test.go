@@ -0,0 +1,89 @@
+package HttpServer
+
+import (
+	"errors"
+	"github.com/aerosystems/auth-service/internal/models"
+	"github.com/aerosystems/auth-service/internal/presenters/http/handlers"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeTokenUsecase keeps access entries in memory instead of Redis, methods which are not overridden panic
+type fakeTokenUsecase struct {
+	handlers.TokenUsecase
+	claims map[string]*models.AccessTokenClaims
+	cache  map[string]string
+}
+
+func (f *fakeTokenUsecase) DecodeAccessToken(tokenString string) (*models.AccessTokenClaims, error) {
+	claims, ok := f.claims[tokenString]
+	if !ok {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+func (f *fakeTokenUsecase) GetCacheValue(Uuid string) (*string, error) {
+	value, ok := f.cache[Uuid]
+	if !ok {
+		return nil, errors.New("redis: nil")
+	}
+	return &value, nil
+}
+
+func serveWithToken(s *Server, token string) int {
+	req := httptest.NewRequest(http.MethodGet, "/v1/users", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	c := s.echo.NewContext(req, rec)
+	h := s.AuthTokenMiddleware(models.CustomerRole)(func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	})
+	if err := h(c); err != nil {
+		s.echo.HTTPErrorHandler(err, c)
+	}
+	return rec.Code
+}
+
+func TestAuthTokenMiddlewareChecksRole(t *testing.T) {
+	tokenUsecase := &fakeTokenUsecase{
+		claims: map[string]*models.AccessTokenClaims{
+			"token": {AccessUuid: "access-uuid", UserUuid: "user-uuid", UserRole: models.StaffRole.String()},
+		},
+		cache: map[string]string{"access-uuid": `{"userUuid":"user-uuid"}`},
+	}
+	s := &Server{echo: echo.New(), tokenUsecase: tokenUsecase}
+
+	if code := serveWithToken(s, "token"); code != http.StatusForbidden {
+		t.Fatalf("expected %d, got %d", http.StatusForbidden, code)
+	}
+	if code := serveWithToken(s, "unknown"); code != http.StatusUnauthorized {
+		t.Fatalf("expected %d, got %d", http.StatusUnauthorized, code)
+	}
+}
+
+func TestAuthTokenMiddlewareRejectsClientToken(t *testing.T) {
+	clientClaims := &models.AccessTokenClaims{AccessUuid: "access-uuid", UserUuid: "user-uuid", UserRole: models.CustomerRole.String(), Scope: "openid email"}
+	clientClaims.Audience = "client-id"
+	tokenUsecase := &fakeTokenUsecase{
+		claims: map[string]*models.AccessTokenClaims{"token": clientClaims},
+		cache:  map[string]string{"access-uuid": `{"userUuid":"user-uuid"}`},
+	}
+	s := &Server{echo: echo.New(), tokenUsecase: tokenUsecase}
+
+	if code := serveWithToken(s, "token"); code != http.StatusUnauthorized {
+		t.Fatalf("expected %d for a client token on a first-party endpoint, got %d", http.StatusUnauthorized, code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/userinfo", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	rec := httptest.NewRecorder()
+	h := s.OidcTokenMiddleware(models.CustomerRole)(func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	})
+	if err := h(s.echo.NewContext(req, rec)); err != nil {
+		t.Fatalf("expected the client token to be accepted by userinfo, got %v", err)
+	}
+}
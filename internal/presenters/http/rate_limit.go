@@ -0,0 +1,107 @@
+package HttpServer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/aerosystems/auth-service/internal/helpers"
+	RateLimiter "github.com/aerosystems/auth-service/pkg/rate_limiter"
+	"github.com/labstack/echo/v4"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// maxRateLimitBodySize limits how much of the body is read to find the email, sign in requests are much smaller
+const maxRateLimitBodySize = 64 << 10
+
+func (s *Server) addRateLimit() {
+	s.echo.Use(s.RateLimitMiddleware())
+}
+
+// RateLimitMiddleware limits requests of routes with rules by client IP and by email of the request body. Requests are
+// let through if Redis is not available, so the limiter never takes sign in down.
+func (s *Server) RateLimitMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			rules, ok := s.rateLimitRules[c.Path()]
+			if !ok {
+				return next(c)
+			}
+			var strictest *RateLimiter.Result
+			if rules.PerIp != nil {
+				strictest = s.allowRequest(c.Path()+":ip:"+c.RealIP(), *rules.PerIp)
+			}
+			// a request rejected by IP is not counted for the email
+			if rules.PerEmail != nil && (strictest == nil || strictest.Allowed) {
+				if email := requestEmail(c); email != "" {
+					result := s.allowRequest(c.Path()+":email:"+email, *rules.PerEmail)
+					if result != nil && (strictest == nil || !result.Allowed || result.Remaining < strictest.Remaining) {
+						strictest = result
+					}
+				}
+			}
+			if strictest == nil {
+				return next(c)
+			}
+			header := c.Response().Header()
+			header.Set("RateLimit-Limit", strconv.Itoa(strictest.Limit.Requests))
+			header.Set("RateLimit-Remaining", strconv.Itoa(strictest.Remaining))
+			header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(strictest.Reset.Seconds())))
+			header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", strictest.Limit.Requests, ceilSeconds(strictest.Limit.Window.Seconds())))
+			if !strictest.Allowed {
+				header.Set("Retry-After", strconv.Itoa(ceilSeconds(strictest.Reset.Seconds())))
+				return echo.NewHTTPError(http.StatusTooManyRequests, "too many requests")
+			}
+			return next(c)
+		}
+	}
+}
+
+func (s *Server) allowRequest(key string, limit RateLimiter.Limit) *RateLimiter.Result {
+	result, err := s.rateLimiter.Allow(key, limit)
+	if err != nil {
+		s.log.Errorf("could not check rate limit: %s", err.Error())
+		return nil
+	}
+	return result
+}
+
+// requestEmail finds the email in a JSON or form body and puts the body back for the handler
+func requestEmail(c echo.Context) string {
+	req := c.Request()
+	if req.Body == nil {
+		return ""
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxRateLimitBodySize))
+	if err != nil {
+		return ""
+	}
+	req.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), req.Body))
+	var email string
+	contentType := req.Header.Get(echo.HeaderContentType)
+	switch {
+	case strings.HasPrefix(contentType, echo.MIMEApplicationJSON):
+		var payload struct {
+			Email string `json:"email"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return ""
+		}
+		email = payload.Email
+	case strings.HasPrefix(contentType, echo.MIMEApplicationForm):
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return ""
+		}
+		email = values.Get("email")
+	}
+	return helpers.NormalizeEmail(email)
+}
+
+func ceilSeconds(seconds float64) int {
+	return int(math.Ceil(seconds))
+}
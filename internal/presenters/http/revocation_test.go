@@ -0,0 +1,144 @@
+package HttpServer
+
+import (
+	"encoding/base64"
+	"github.com/aerosystems/auth-service/internal/models"
+	"github.com/aerosystems/auth-service/internal/usecases"
+	"github.com/aerosystems/auth-service/pkg/encryptor"
+	"github.com/aerosystems/auth-service/pkg/jwk"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"testing"
+)
+
+// memorySigningKeyRepo keeps signing keys in memory
+type memorySigningKeyRepo struct {
+	keys []models.SigningKey
+}
+
+func (r *memorySigningKeyRepo) GetByKid(Kid string) (*models.SigningKey, error) {
+	for i := range r.keys {
+		if r.keys[i].Kid == Kid {
+			key := r.keys[i]
+			return &key, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *memorySigningKeyRepo) GetAll() ([]models.SigningKey, error) {
+	return append([]models.SigningKey(nil), r.keys...), nil
+}
+
+func (r *memorySigningKeyRepo) Create(key *models.SigningKey) error {
+	key.Id = len(r.keys) + 1
+	r.keys = append(r.keys, *key)
+	return nil
+}
+
+func (r *memorySigningKeyRepo) Update(key *models.SigningKey) error {
+	for i := range r.keys {
+		if r.keys[i].Id == key.Id {
+			r.keys[i] = *key
+		}
+	}
+	return nil
+}
+
+// memoryUserRepo keeps users in memory, methods which are not overridden panic
+type memoryUserRepo struct {
+	usecases.UserRepository
+	users map[uuid.UUID]*models.User
+}
+
+func (r *memoryUserRepo) GetByUuid(Uuid uuid.UUID) (*models.User, error) {
+	return r.users[Uuid], nil
+}
+
+func (r *memoryUserRepo) Update(user *models.User) error {
+	r.users[user.Uuid] = user
+	return nil
+}
+
+// memoryCodeRepo saves confirmed codes nowhere, methods which are not overridden panic
+type memoryCodeRepo struct {
+	usecases.CodeRepository
+}
+
+func (memoryCodeRepo) UpdateWithAssociations(code *models.Code) error {
+	return nil
+}
+
+// newTestTokenUsecase returns a TokenUsecase backed by miniredis with one active ES256 key
+func newTestTokenUsecase(t *testing.T) *usecases.TokenUsecase {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	cache := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	keyEncryptor, err := encryptor.NewEncryptor(base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenUsecase := usecases.NewTokenUsecase(log, cache, &memorySigningKeyRepo{}, keyEncryptor, 15, 60, 15)
+	key, err := jwk.Generate("test", "ES256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tokenUsecase.ImportSigningKeys([]*jwk.Key{key}, key.Kid); err != nil {
+		t.Fatal(err)
+	}
+	return tokenUsecase
+}
+
+// TestRevocationInvalidatesIssuedTokens checks that tokens issued before an event revoking sessions are rejected by the
+// middleware and could not be refreshed
+func TestRevocationInvalidatesIssuedTokens(t *testing.T) {
+	revocations := []struct {
+		name   string
+		revoke func(authUsecase *usecases.AuthUsecase, tokenUsecase *usecases.TokenUsecase, user *models.User) error
+	}{
+		{"sign out everywhere", func(authUsecase *usecases.AuthUsecase, tokenUsecase *usecases.TokenUsecase, user *models.User) error {
+			return tokenUsecase.RevokeSessions(user.Uuid.String())
+		}},
+		{"password reset", func(authUsecase *usecases.AuthUsecase, tokenUsecase *usecases.TokenUsecase, user *models.User) error {
+			return authUsecase.Confirm(&models.Code{Action: models.ResetPasswordCode, User: *user, Data: "new-password-hash"})
+		}},
+		{"role change", func(authUsecase *usecases.AuthUsecase, tokenUsecase *usecases.TokenUsecase, user *models.User) error {
+			return authUsecase.ChangeRole(user.Uuid.String(), models.StaffRole)
+		}},
+		{"deactivation", func(authUsecase *usecases.AuthUsecase, tokenUsecase *usecases.TokenUsecase, user *models.User) error {
+			return authUsecase.Deactivate(user.Uuid.String())
+		}},
+	}
+	for _, revocation := range revocations {
+		t.Run(revocation.name, func(t *testing.T) {
+			user := &models.User{Uuid: uuid.New(), Role: models.CustomerRole, IsActive: true}
+			userRepo := &memoryUserRepo{users: map[uuid.UUID]*models.User{user.Uuid: user}}
+			tokenUsecase := newTestTokenUsecase(t)
+			authUsecase := usecases.NewAuthUsecase(memoryCodeRepo{}, userRepo, nil, nil, nil, tokenUsecase, nil, 0, 6, "0123456789", make([]byte, 32), 0, 0)
+			s := &Server{echo: echo.New(), tokenUsecase: tokenUsecase}
+
+			td, err := tokenUsecase.CreateToken(user.Uuid.String(), user.Role.String(), "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code := serveWithToken(s, td.AccessToken); code != http.StatusNoContent {
+				t.Fatalf("expected %d before revocation, got %d", http.StatusNoContent, code)
+			}
+
+			if err := revocation.revoke(authUsecase, tokenUsecase, user); err != nil {
+				t.Fatal(err)
+			}
+			if code := serveWithToken(s, td.AccessToken); code != http.StatusUnauthorized {
+				t.Fatalf("expected %d for the access token after revocation, got %d", http.StatusUnauthorized, code)
+			}
+			if _, err := tokenUsecase.RefreshTokens(td.RefreshToken); err == nil {
+				t.Fatal("expected the refresh token to be rejected after revocation")
+			}
+		})
+	}
+}
@@ -7,11 +7,58 @@ import (
 func (s *Server) setupRoutes() {
 	s.echo.POST("/v1/sign-up", s.userHandler.SignUp)
 	s.echo.POST("/v1/sign-in", s.userHandler.SignIn)
+	s.echo.POST("/v1/sign-in/mfa", s.mfaHandler.SignInMfa)
+	s.echo.POST("/v1/sign-in/code", s.userHandler.SendSignInCode)
+	s.echo.POST("/v1/sign-in/code/verify", s.userHandler.SignInWithCode)
+	s.echo.POST("/v1/sign-in/passkey/options", s.passkeyHandler.BeginPasskeySignIn)
+	s.echo.POST("/v1/sign-in/passkey", s.passkeyHandler.PasskeySignIn)
+	s.echo.POST("/v1/sign-in/:provider", s.identityHandler.SignIn)
 	s.echo.POST("/v1/confirm", s.userHandler.Confirm)
+	s.echo.POST("/v1/confirm/resend", s.userHandler.ResendCode)
 	s.echo.POST("/v1/reset-password", s.userHandler.ResetPassword)
+	s.echo.GET("/v1/identity-providers", s.identityHandler.GetProviders)
 	s.echo.POST("/v1/token/refresh", s.tokenHandler.RefreshToken)
+	s.echo.GET("/.well-known/jwks.json", s.tokenHandler.JWKS)
+	s.echo.POST("/oauth/introspect", s.oauthHandler.Introspect)
+	s.echo.POST("/oauth/revoke", s.oauthHandler.Revoke)
+	s.echo.GET("/.well-known/openid-configuration", s.oauthHandler.OpenidConfiguration)
+	s.echo.GET("/authorize", s.oauthHandler.Authorize)
+	s.echo.POST("/authorize", s.oauthHandler.AuthorizeSubmit)
+	s.echo.POST("/token", s.oauthHandler.Token)
+	s.echo.GET("/userinfo", s.oauthHandler.UserInfo, s.OidcTokenMiddleware(models.CustomerRole, models.StaffRole))
+	s.echo.POST("/userinfo", s.oauthHandler.UserInfo, s.OidcTokenMiddleware(models.CustomerRole, models.StaffRole))
 
 	s.echo.GET("/v1/users", s.userHandler.GetUser, s.AuthTokenMiddleware(models.CustomerRole))
+	s.echo.GET("/v1/users/export", s.userHandler.ExportUser, s.AuthTokenMiddleware(models.CustomerRole, models.StaffRole))
+	s.echo.DELETE("/v1/users", s.userHandler.DeleteUser, s.AuthTokenMiddleware(models.CustomerRole, models.StaffRole))
+	s.echo.POST("/v1/users/email", s.userHandler.ChangeEmail, s.AuthTokenMiddleware(models.CustomerRole, models.StaffRole))
+	s.echo.PUT("/v1/users/password", s.userHandler.ChangePassword, s.AuthTokenMiddleware(models.CustomerRole, models.StaffRole))
+	s.echo.PUT("/v1/users/:uuid/role", s.userHandler.ChangeRole, s.AuthTokenMiddleware(models.StaffRole))
+	s.echo.POST("/v1/users/:uuid/deactivate", s.userHandler.Deactivate, s.AuthTokenMiddleware(models.StaffRole))
+	s.echo.POST("/v1/users/:uuid/unlock", s.userHandler.Unlock, s.AuthTokenMiddleware(models.StaffRole))
+	s.echo.GET("/v1/users/:uuid/export", s.userHandler.ExportUserByUuid, s.AuthTokenMiddleware(models.StaffRole))
 	s.echo.POST("/v1/sign-out", s.userHandler.SignOut, s.AuthTokenMiddleware(models.CustomerRole, models.StaffRole))
-	s.echo.GET("/v1/token/validate", s.tokenHandler.ValidateToken, s.AuthTokenMiddleware(models.CustomerRole, models.StaffRole))
+	s.echo.GET("/v1/token/validate", s.tokenHandler.ValidateToken, s.AuthTokenMiddleware(models.CustomerRole, models.StaffRole, models.ServiceRole))
+	s.echo.GET("/v1/users/identities", s.identityHandler.GetIdentities, s.AuthTokenMiddleware(models.CustomerRole, models.StaffRole))
+	s.echo.POST("/v1/users/identities/:provider", s.identityHandler.LinkIdentity, s.AuthTokenMiddleware(models.CustomerRole, models.StaffRole))
+	s.echo.DELETE("/v1/users/identities/:provider", s.identityHandler.UnlinkIdentity, s.AuthTokenMiddleware(models.CustomerRole, models.StaffRole))
+	s.echo.POST("/v1/users/mfa/totp", s.mfaHandler.EnrollTotp, s.AuthTokenMiddleware(models.CustomerRole, models.StaffRole))
+	s.echo.POST("/v1/users/mfa/totp/confirm", s.mfaHandler.ConfirmTotp, s.AuthTokenMiddleware(models.CustomerRole, models.StaffRole))
+	s.echo.DELETE("/v1/users/mfa/totp", s.mfaHandler.DisableTotp, s.AuthTokenMiddleware(models.CustomerRole, models.StaffRole))
+	s.echo.POST("/v1/users/mfa/recovery-codes", s.mfaHandler.RegenerateRecoveryCodes, s.AuthTokenMiddleware(models.CustomerRole, models.StaffRole))
+	s.echo.POST("/v1/users/passkeys/options", s.passkeyHandler.BeginPasskeyRegistration, s.AuthTokenMiddleware(models.CustomerRole, models.StaffRole))
+	s.echo.POST("/v1/users/passkeys", s.passkeyHandler.FinishPasskeyRegistration, s.AuthTokenMiddleware(models.CustomerRole, models.StaffRole))
+	s.echo.GET("/v1/users/passkeys", s.passkeyHandler.GetPasskeys, s.AuthTokenMiddleware(models.CustomerRole, models.StaffRole))
+	s.echo.DELETE("/v1/users/passkeys/:passkeyId", s.passkeyHandler.DeletePasskey, s.AuthTokenMiddleware(models.CustomerRole, models.StaffRole))
+	s.echo.GET("/v1/sessions", s.sessionHandler.GetSessions, s.AuthTokenMiddleware(models.CustomerRole, models.StaffRole))
+	s.echo.DELETE("/v1/sessions", s.sessionHandler.RevokeSessions, s.AuthTokenMiddleware(models.CustomerRole, models.StaffRole))
+	s.echo.DELETE("/v1/sessions/:id", s.sessionHandler.RevokeSession, s.AuthTokenMiddleware(models.CustomerRole, models.StaffRole))
+
+	s.echo.GET("/v1/keys", s.tokenHandler.GetSigningKeys, s.AuthTokenMiddleware(models.StaffRole))
+	s.echo.POST("/v1/keys", s.tokenHandler.AddSigningKey, s.AuthTokenMiddleware(models.StaffRole))
+	s.echo.POST("/v1/keys/:kid/promote", s.tokenHandler.PromoteSigningKey, s.AuthTokenMiddleware(models.StaffRole))
+
+	s.echo.GET("/v1/clients", s.oauthHandler.GetClients, s.AuthTokenMiddleware(models.StaffRole))
+	s.echo.POST("/v1/clients", s.oauthHandler.CreateClient, s.AuthTokenMiddleware(models.StaffRole))
+	s.echo.DELETE("/v1/clients/:clientId", s.oauthHandler.DeleteClient, s.AuthTokenMiddleware(models.StaffRole))
 }
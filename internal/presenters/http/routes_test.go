@@ -0,0 +1,58 @@
+package HttpServer
+
+import (
+	RateLimiter "github.com/aerosystems/auth-service/pkg/rate_limiter"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// TestPublicRoutesAreRateLimited walks routes.go and checks that every public route changing state, i.e. checking
+// credentials, sending emails or writing challenges, has a default rate limit
+func TestPublicRoutesAreRateLimited(t *testing.T) {
+	rules, err := RateLimiter.ParseRules(RateLimiter.DefaultRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := parser.ParseFile(token.NewFileSet(), "routes.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes := 0
+	ast.Inspect(file, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		selector, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || len(call.Args) < 2 {
+			return true
+		}
+		if echoField, ok := selector.X.(*ast.SelectorExpr); !ok || echoField.Sel.Name != "echo" {
+			return true
+		}
+		literal, ok := call.Args[0].(*ast.BasicLit)
+		if !ok {
+			t.Fatalf("route of %s is not a string literal", selector.Sel.Name)
+		}
+		path, err := strconv.Unquote(literal.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		routes++
+		// routes with middleware require a token
+		if selector.Sel.Name == http.MethodGet || len(call.Args) > 2 {
+			return true
+		}
+		if _, ok := rules[path]; !ok {
+			t.Errorf("public route %s %s has no default rate limit", selector.Sel.Name, path)
+		}
+		return true
+	})
+	if routes == 0 {
+		t.Fatal("no routes found in routes.go")
+	}
+}
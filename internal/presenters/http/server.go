@@ -3,32 +3,58 @@ package HttpServer
 import (
 	"fmt"
 	"github.com/aerosystems/auth-service/internal/presenters/http/handlers"
+	RateLimiter "github.com/aerosystems/auth-service/pkg/rate_limiter"
 	"github.com/labstack/echo/v4"
 	"github.com/sirupsen/logrus"
+	"net"
 )
 
 const webPort = 80
 
 type Server struct {
-	log          *logrus.Logger
-	echo         *echo.Echo
-	accessSecret string
-	userHandler  *handlers.UserHandler
-	tokenHandler *handlers.TokenHandler
+	log             *logrus.Logger
+	echo            *echo.Echo
+	tokenUsecase    handlers.TokenUsecase
+	userHandler     *handlers.UserHandler
+	tokenHandler    *handlers.TokenHandler
+	sessionHandler  *handlers.SessionHandler
+	oauthHandler    *handlers.OAuthHandler
+	identityHandler *handlers.IdentityHandler
+	mfaHandler      *handlers.MfaHandler
+	passkeyHandler  *handlers.PasskeyHandler
+	rateLimiter     *RateLimiter.Limiter
+	rateLimitRules  map[string]RateLimiter.Rules
+	trustedProxies  []*net.IPNet
 }
 
 func NewServer(
 	log *logrus.Logger,
-	accessSecret string,
+	tokenUsecase handlers.TokenUsecase,
 	userHandler *handlers.UserHandler,
 	tokenHandler *handlers.TokenHandler,
+	sessionHandler *handlers.SessionHandler,
+	oauthHandler *handlers.OAuthHandler,
+	identityHandler *handlers.IdentityHandler,
+	mfaHandler *handlers.MfaHandler,
+	passkeyHandler *handlers.PasskeyHandler,
+	rateLimiter *RateLimiter.Limiter,
+	rateLimitRules map[string]RateLimiter.Rules,
+	trustedProxies []*net.IPNet,
 ) *Server {
 	return &Server{
-		log:          log,
-		echo:         echo.New(),
-		accessSecret: accessSecret,
-		userHandler:  userHandler,
-		tokenHandler: tokenHandler,
+		log:             log,
+		echo:            echo.New(),
+		tokenUsecase:    tokenUsecase,
+		userHandler:     userHandler,
+		tokenHandler:    tokenHandler,
+		sessionHandler:  sessionHandler,
+		oauthHandler:    oauthHandler,
+		identityHandler: identityHandler,
+		mfaHandler:      mfaHandler,
+		passkeyHandler:  passkeyHandler,
+		rateLimiter:     rateLimiter,
+		rateLimitRules:  rateLimitRules,
+		trustedProxies:  trustedProxies,
 	}
 }
 
@@ -0,0 +1,121 @@
+package usecases
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aerosystems/auth-service/internal/models"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+	"time"
+)
+
+// reauthMaxAge is how long after signing in a user without a password could delete the account
+const reauthMaxAge = 10 * time.Minute
+
+// AccountUsecase deletes accounts on request of their users after a grace period
+type AccountUsecase struct {
+	log             *logrus.Logger
+	userRepo        UserRepository
+	mailAdapter     MailAdapter
+	customerAdapter CustomerAdapter
+	tokenUsecase    *TokenUsecase
+	gracePeriod     time.Duration
+}
+
+func NewAccountUsecase(log *logrus.Logger, userRepo UserRepository, mailAdapter MailAdapter, customerAdapter CustomerAdapter, tokenUsecase *TokenUsecase, graceDays int) *AccountUsecase {
+	return &AccountUsecase{
+		log:             log,
+		userRepo:        userRepo,
+		mailAdapter:     mailAdapter,
+		customerAdapter: customerAdapter,
+		tokenUsecase:    tokenUsecase,
+		gracePeriod:     time.Duration(graceDays) * 24 * time.Hour,
+	}
+}
+
+// ScheduleDeletion marks the account for deletion after the grace period and signs the user out everywhere, so the
+// deletion is only cancelled by signing in again. The user confirms it with the password, or by a fresh sign in if the
+// account has no password, e.g. it signs in with external identities, passkeys or email codes only.
+func (au AccountUsecase) ScheduleDeletion(userUuid, accessUuid, password string) (time.Time, error) {
+	user, err := getUser(au.userRepo, userUuid)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := au.checkReauthentication(user, accessUuid, password); err != nil {
+		return time.Time{}, err
+	}
+	deleteAt := time.Now().Add(au.gracePeriod)
+	user.DeleteAt = &deleteAt
+	if err := au.userRepo.Update(user); err != nil {
+		return time.Time{}, fmt.Errorf("could not schedule account deletion: %s", err.Error())
+	}
+	if err := au.tokenUsecase.RevokeSessions(user.Uuid.String()); err != nil {
+		return time.Time{}, fmt.Errorf("could not revoke sessions: %s", err.Error())
+	}
+	// sending notification via RPC
+	if err := au.mailAdapter.SendEmail(user.Email, "Your account will be deleted🗯", fmt.Sprintf("Your account will be deleted on %s. Sign in before to keep it.", deleteAt.Format(time.RFC1123))); err != nil {
+		return time.Time{}, fmt.Errorf("could not send email: %s", err.Error())
+	}
+	return deleteAt, nil
+}
+
+func (au AccountUsecase) checkReauthentication(user *models.User, accessUuid, password string) error {
+	if user.PasswordHash != "" {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+			return models.ErrInvalidCredentials
+		}
+		return nil
+	}
+	authTime, err := au.tokenUsecase.GetAuthTime(accessUuid)
+	if err != nil {
+		return fmt.Errorf("could not get session: %s", err.Error())
+	}
+	if time.Since(authTime) > reauthMaxAge {
+		return fmt.Errorf("sign in again within %d minutes before deleting the account", int(reauthMaxAge.Minutes()))
+	}
+	return nil
+}
+
+// CancelDeletion keeps the account if it is scheduled for deletion
+func (au AccountUsecase) CancelDeletion(user *models.User) error {
+	if user.DeleteAt == nil {
+		return nil
+	}
+	user.DeleteAt = nil
+	if err := au.userRepo.Update(user); err != nil {
+		return fmt.Errorf("could not cancel account deletion: %s", err.Error())
+	}
+	return nil
+}
+
+// DeleteScheduledAccounts hard-deletes accounts whose grace period is over, with their login history. The customer
+// service is notified first and the user is deleted last, so an account which could not be cleaned up is retried from
+// the start on the next run. A failed account does not hold up the others, their errors are returned together.
+func (au AccountUsecase) DeleteScheduledAccounts() error {
+	users, err := au.userRepo.GetDueForDeletion(time.Now())
+	if err != nil {
+		return fmt.Errorf("could not get accounts scheduled for deletion: %s", err.Error())
+	}
+	var errs []error
+	for i := range users {
+		if err := au.deleteAccount(&users[i]); err != nil {
+			au.log.Errorf("could not delete account: %s", err.Error())
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// deleteAccount removes the data of the user, every step could be repeated if a later one fails
+func (au AccountUsecase) deleteAccount(user *models.User) error {
+	if err := au.customerAdapter.DeleteCustomer(user.Uuid); err != nil {
+		return fmt.Errorf("could not delete customer %s: %s", user.Uuid.String(), err.Error())
+	}
+	if err := au.tokenUsecase.DropLoginHistory(user.Uuid.String()); err != nil {
+		return fmt.Errorf("could not drop login history of user %s: %s", user.Uuid.String(), err.Error())
+	}
+	if err := au.userRepo.Delete(user); err != nil {
+		return fmt.Errorf("could not delete user %s: %s", user.Uuid.String(), err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package usecases
+
+import (
+	"errors"
+	"github.com/aerosystems/auth-service/internal/models"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"io"
+	"testing"
+	"time"
+)
+
+func newTestCache(t *testing.T) *redis.Client {
+	server := miniredis.RunT(t)
+	return redis.NewClient(&redis.Options{Addr: server.Addr()})
+}
+
+func newTestLogger() *logrus.Logger {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return log
+}
+
+// fakeUserRepo keeps users in memory, methods which are not overridden panic
+type fakeUserRepo struct {
+	UserRepository
+	users map[uuid.UUID]*models.User
+}
+
+func newFakeUserRepo(users ...*models.User) *fakeUserRepo {
+	repo := &fakeUserRepo{users: make(map[uuid.UUID]*models.User)}
+	for _, user := range users {
+		repo.users[user.Uuid] = user
+	}
+	return repo
+}
+
+func (r *fakeUserRepo) GetByUuid(Uuid uuid.UUID) (*models.User, error) {
+	return r.users[Uuid], nil
+}
+
+func (r *fakeUserRepo) Update(user *models.User) error {
+	r.users[user.Uuid] = user
+	return nil
+}
+
+func (r *fakeUserRepo) Delete(user *models.User) error {
+	delete(r.users, user.Uuid)
+	return nil
+}
+
+func (r *fakeUserRepo) GetDueForDeletion(before time.Time) ([]models.User, error) {
+	var users []models.User
+	for _, user := range r.users {
+		if user.DeleteAt != nil && user.DeleteAt.Before(before) {
+			users = append(users, *user)
+		}
+	}
+	return users, nil
+}
+
+// fakeCustomerAdapter fails to delete the customers in failing
+type fakeCustomerAdapter struct {
+	CustomerAdapter
+	failing map[uuid.UUID]bool
+	deleted []uuid.UUID
+}
+
+func (a *fakeCustomerAdapter) DeleteCustomer(uuid uuid.UUID) error {
+	if a.failing[uuid] {
+		return errors.New("customer service is unavailable")
+	}
+	a.deleted = append(a.deleted, uuid)
+	return nil
+}
+
+func newDueUser() *models.User {
+	deleteAt := time.Now().Add(-time.Hour)
+	return &models.User{Uuid: uuid.New(), DeleteAt: &deleteAt}
+}
+
+func TestDeleteScheduledAccountsSkipsFailedAccount(t *testing.T) {
+	failed, first, second := newDueUser(), newDueUser(), newDueUser()
+	userRepo := newFakeUserRepo(failed, first, second)
+	customerAdapter := &fakeCustomerAdapter{failing: map[uuid.UUID]bool{failed.Uuid: true}}
+	tokenUsecase := NewTokenUsecase(newTestLogger(), newTestCache(t), nil, nil, 15, 60, 15)
+	au := NewAccountUsecase(newTestLogger(), userRepo, nil, customerAdapter, tokenUsecase, 14)
+
+	if err := au.DeleteScheduledAccounts(); err == nil {
+		t.Fatal("expected the error of the failed account")
+	}
+	if _, ok := userRepo.users[failed.Uuid]; !ok {
+		t.Fatal("expected the failed account to be kept for the next run")
+	}
+	for _, user := range []*models.User{first, second} {
+		if _, ok := userRepo.users[user.Uuid]; ok {
+			t.Fatalf("expected account %s to be deleted despite the failed one", user.Uuid)
+		}
+	}
+
+	// the next run retries the failed account once the customer service is back
+	customerAdapter.failing = nil
+	if err := au.DeleteScheduledAccounts(); err != nil {
+		t.Fatalf("expected the retry to succeed, got %v", err)
+	}
+	if len(userRepo.users) != 0 {
+		t.Fatalf("expected every account to be deleted, %d left", len(userRepo.users))
+	}
+}
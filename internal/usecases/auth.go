@@ -1,42 +1,76 @@
 package usecases
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/aerosystems/auth-service/internal/helpers"
 	"github.com/aerosystems/auth-service/internal/models"
+	"github.com/aerosystems/auth-service/pkg/encryptor"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 	"log"
-	"math/rand"
-	"os"
-	"strings"
+	"math/big"
 	"time"
 )
 
+// codeMaxAttempts is the number of wrong guesses after which a code is invalidated
+const codeMaxAttempts = 5
+
+// codeCreateAttempts is the number of values generated for a code before giving up on collisions with active codes
+const codeCreateAttempts = 5
+
 type AuthUsecase struct {
 	codeRepo         CodeRepository
 	userRepo         UserRepository
 	checkmailAdapter CheckmailAdapter
 	mailAdapter      MailAdapter
 	customerAdapter  CustomerAdapter
+	tokenUsecase     *TokenUsecase
+	lockoutUsecase   *LockoutUsecase
 	codeExpMinutes   time.Duration
+	codeLength       int
+	codeAlphabet     []rune
+	codeDigestKey    []byte
+	codeDataKey      []byte
+	resendCooldown   time.Duration
+	resendDailyLimit int
 }
 
-func NewAuthUsecase(codeRepo CodeRepository, userRepo UserRepository, checkmailAdapter CheckmailAdapter, mailAdapter MailAdapter, customerAdapter CustomerAdapter, codeExpMinutes int) *AuthUsecase {
+func NewAuthUsecase(codeRepo CodeRepository, userRepo UserRepository, checkmailAdapter CheckmailAdapter, mailAdapter MailAdapter, customerAdapter CustomerAdapter, tokenUsecase *TokenUsecase, lockoutUsecase *LockoutUsecase, codeExpMinutes, codeLength int, codeAlphabet string, codeKey []byte, resendCooldownSeconds, resendDailyLimit int) *AuthUsecase {
 	return &AuthUsecase{
 		codeRepo:         codeRepo,
 		userRepo:         userRepo,
 		checkmailAdapter: checkmailAdapter,
 		mailAdapter:      mailAdapter,
 		customerAdapter:  customerAdapter,
+		tokenUsecase:     tokenUsecase,
+		lockoutUsecase:   lockoutUsecase,
 		codeExpMinutes:   time.Duration(codeExpMinutes) * time.Minute,
+		codeLength:       codeLength,
+		codeAlphabet:     []rune(codeAlphabet),
+		codeDigestKey:    deriveCodeKey(codeKey, "code digest"),
+		codeDataKey:      deriveCodeKey(codeKey, "code data"),
+		resendCooldown:   time.Duration(resendCooldownSeconds) * time.Second,
+		resendDailyLimit: resendDailyLimit,
 	}
 }
 
+// deriveCodeKey separates keys of digests and of data encryption derived from the same secret
+func deriveCodeKey(codeKey []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, codeKey)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
 func NewUser(Email, PasswordHash string) *models.User {
 	user := models.User{
-		Email:        normalizeEmail(Email),
+		Email:        helpers.NormalizeEmail(Email),
 		Uuid:         uuid.New(),
 		PasswordHash: PasswordHash,
 		IsActive:     false,
@@ -46,7 +80,7 @@ func NewUser(Email, PasswordHash string) *models.User {
 
 func NewCode(user models.User, Action models.KindCode, expireAt time.Time, Data string) *models.Code {
 	return &models.Code{
-		Code:     genCode(),
+		UserId:   user.Id,
 		User:     user,
 		Action:   Action,
 		Data:     Data,
@@ -67,6 +101,22 @@ func (as AuthUsecase) GetUserByUuid(uuidStr string) (*models.User, error) {
 	return user, nil
 }
 
+// getUser returns the existing user with the uuid, unlike GetUserByUuid a missing user is an error
+func getUser(userRepo UserRepository, userUuid string) (*models.User, error) {
+	parsedUuid, err := uuid.Parse(userUuid)
+	if err != nil {
+		return nil, errors.New("invalid uuid")
+	}
+	user, err := userRepo.GetByUuid(parsedUuid)
+	if err != nil {
+		return nil, errors.New("could not get user")
+	}
+	if user == nil {
+		return nil, models.ErrUserNotFound
+	}
+	return user, nil
+}
+
 func (as AuthUsecase) RegisterCustomer(email, password, clientIp string) error {
 	// hashing password
 	passwordHash, _ := as.hashPassword(password)
@@ -75,12 +125,12 @@ func (as AuthUsecase) RegisterCustomer(email, password, clientIp string) error {
 		log.Printf("could not check email in blacklist: %s", err)
 	}
 	// normalizing email
-	email = normalizeEmail(email)
+	email = helpers.NormalizeEmail(email)
 	// getting user by email in local repository
 	user, _ := as.userRepo.GetByEmail(email)
 	// if user with this email already exists
 	if user != nil {
-		if user.IsActive {
+		if user.IsActive || user.IsDeactivated() {
 			return errors.New("user with this email already exists")
 		} else {
 			// updating password for inactive user
@@ -88,23 +138,13 @@ func (as AuthUsecase) RegisterCustomer(email, password, clientIp string) error {
 			if err := as.userRepo.Update(user); err != nil {
 				return fmt.Errorf("could not update password for inactive user: %s", err.Error())
 			}
-			code, _ := as.codeRepo.GetLastIsActiveCode(user.Id, "registration")
-			if code == nil {
-				// generating confirmation code
-				expTime := time.Now().Add(as.codeExpMinutes)
-				codeObj := NewCode(*user, models.RegistrationCode, expTime, "")
-				if err := as.codeRepo.Create(codeObj); err != nil {
-					return errors.New("could not gen new code")
-				}
-			} else {
-				// extend expiration code and return previous active code
-				code.ExpireAt = time.Now().Add(as.codeExpMinutes)
-				if err := as.codeRepo.Update(code); err != nil {
-					return fmt.Errorf("could not extend expiration code: %s", err.Error())
-				}
+			// generating confirmation code, only its digest is stored, so the previous code could not be sent again
+			code, err := as.issueCode(user, models.RegistrationCode, "")
+			if err != nil {
+				return err
 			}
 			// sending confirmation code via RPC
-			if err := as.mailAdapter.SendEmail(email, "Confirm your email🗯", fmt.Sprintf("Your confirmation code is %s", code.Code)); err != nil {
+			if err := as.mailAdapter.SendEmail(email, "Confirm your email🗯", fmt.Sprintf("Your confirmation code is %s", code)); err != nil {
 				return fmt.Errorf("could not send email: %s", err.Error())
 			}
 			return nil
@@ -118,18 +158,22 @@ func (as AuthUsecase) RegisterCustomer(email, password, clientIp string) error {
 	}
 	// generating confirmation code
 	expTime := time.Now().Add(as.codeExpMinutes)
-	newCode := NewCode(*newUser, models.RegistrationCode, expTime, "")
-	if err := as.codeRepo.Create(newCode); err != nil {
+	newCode, err := as.createCode(NewCode(*newUser, models.RegistrationCode, expTime, ""))
+	if err != nil {
 		return errors.New("could not gen new code")
 	}
 	// sending confirmation code via RPC
-	if err := as.mailAdapter.SendEmail(email, "Confirm your email🗯", fmt.Sprintf("Your confirmation code is %s", newCode.Code)); err != nil {
+	if err := as.mailAdapter.SendEmail(email, "Confirm your email🗯", fmt.Sprintf("Your confirmation code is %s", newCode)); err != nil {
 		return fmt.Errorf("could not send email: %s", err.Error())
 	}
 	return nil
 }
 
 func (as AuthUsecase) Confirm(code *models.Code) error {
+	// a deactivated user could not activate the account again with a code sent before or after the deactivation
+	if code.User.IsDeactivated() {
+		return errors.New("user is deactivated")
+	}
 	switch code.Action {
 	case models.RegistrationCode:
 		uuid, err := as.customerAdapter.CreateCustomer()
@@ -153,9 +197,157 @@ func (as AuthUsecase) Confirm(code *models.Code) error {
 		}
 		code.IsUsed = true
 		code.User.PasswordHash = code.Data
+		code.Data = ""
 		if err := as.codeRepo.UpdateWithAssociations(code); err != nil {
 			return fmt.Errorf("could not confirm reset password: %s", err.Error())
 		}
+		// tokens issued with the previous password must not outlive it
+		if err := as.tokenUsecase.RevokeSessions(code.User.Uuid.String()); err != nil {
+			return fmt.Errorf("could not revoke sessions: %s", err.Error())
+		}
+	case models.ChangeEmailCode:
+		// the email could have been taken since the code was sent
+		email := helpers.NormalizeEmail(code.Data)
+		if err := as.checkEmailIsFree(email, code.User.Id); err != nil {
+			return err
+		}
+		code.IsUsed = true
+		code.User.Email = email
+		code.Data = ""
+		if err := as.codeRepo.UpdateWithAssociations(code); err != nil {
+			return fmt.Errorf("could not confirm email change: %s", err.Error())
+		}
+	default:
+		return errors.New("code could not be confirmed")
+	}
+	return nil
+}
+
+// ChangePassword sets a new password if the current one is correct, signs the user out of other sessions and notifies
+// the user by email. A wrong current password returns models.ErrInvalidCredentials and counts as a failed sign in of
+// the account, so guessing it with a stolen token is stopped by the lockout; a rejected attempt returns the time to wait.
+func (as AuthUsecase) ChangePassword(userUuid, accessUuid, clientIp, currentPassword, newPassword string) (time.Duration, error) {
+	user, err := getUser(as.userRepo, userUuid)
+	if err != nil {
+		return 0, err
+	}
+	if retryAfter, err := as.lockoutUsecase.CheckSignIn(user.Email, clientIp); err != nil {
+		return retryAfter, err
+	}
+	if _, err := as.CheckPassword(user, currentPassword); err != nil {
+		if err := as.lockoutUsecase.RegisterFailure(user.Email, clientIp); err != nil {
+			log.Printf("could not register failed sign in: %s", err)
+		}
+		return 0, err
+	}
+	as.lockoutUsecase.RegisterSuccess(user.Email)
+	passwordHash, err := as.hashPassword(newPassword)
+	if err != nil {
+		return 0, errors.New("could not hash password")
+	}
+	user.PasswordHash = passwordHash
+	if err := as.userRepo.Update(user); err != nil {
+		return 0, fmt.Errorf("could not update password: %s", err.Error())
+	}
+	// a pending reset would replace the new password with the one requested before
+	if err := as.invalidateCode(user, models.ResetPasswordCode); err != nil {
+		return 0, err
+	}
+	// tokens issued with the previous password must not outlive it, except the session which changed it
+	sessionUuid, err := as.tokenUsecase.GetSessionUuid(accessUuid)
+	if err != nil {
+		return 0, fmt.Errorf("could not get session: %s", err.Error())
+	}
+	if err := as.tokenUsecase.RevokeOtherSessions(user.Uuid.String(), sessionUuid); err != nil {
+		return 0, fmt.Errorf("could not revoke sessions: %s", err.Error())
+	}
+	// sending notification via RPC
+	if err := as.mailAdapter.SendEmail(user.Email, "Your password was changed🗯", "The password of your account was changed and other sessions were signed out. If it was not you, reset your password."); err != nil {
+		return 0, fmt.Errorf("could not send email: %s", err.Error())
+	}
+	return 0, nil
+}
+
+// ChangeEmail sends a confirmation code to the new email and a security notice to the current one, the email is
+// changed once the code is confirmed
+func (as AuthUsecase) ChangeEmail(userUuid, newEmail, clientIp string) error {
+	user, err := getUser(as.userRepo, userUuid)
+	if err != nil {
+		return err
+	}
+	// checking email in blacklist via RPC
+	if _, err := as.checkmailAdapter.IsTrustEmail(newEmail, clientIp); err != nil {
+		log.Printf("could not check email in blacklist: %s", err)
+	}
+	// normalizing email
+	newEmail = helpers.NormalizeEmail(newEmail)
+	if newEmail == user.Email {
+		return errors.New("new email is the same as the current one")
+	}
+	if err := as.checkEmailIsFree(newEmail, user.Id); err != nil {
+		return err
+	}
+	code, err := as.issueCode(user, models.ChangeEmailCode, newEmail)
+	if err != nil {
+		return err
+	}
+	// sending confirmation code via RPC
+	if err := as.mailAdapter.SendEmail(newEmail, "Confirm your new email🗯", fmt.Sprintf("Your confirmation code is %s", code)); err != nil {
+		return fmt.Errorf("could not send email: %s", err.Error())
+	}
+	// sending security notice via RPC
+	if err := as.mailAdapter.SendEmail(user.Email, "Your email is being changed🗯", fmt.Sprintf("A change of the email of your account to %s was requested. If it was not you, reset your password.", newEmail)); err != nil {
+		return fmt.Errorf("could not send email: %s", err.Error())
+	}
+	return nil
+}
+
+// checkEmailIsFree returns an error if the normalized email belongs to another user
+func (as AuthUsecase) checkEmailIsFree(email string, userId int) error {
+	user, err := as.userRepo.GetByEmail(email)
+	if err != nil {
+		return errors.New("could not get user")
+	}
+	if user != nil && user.Id != userId {
+		return errors.New("user with this email already exists")
+	}
+	return nil
+}
+
+// ChangeRole sets a new role and revokes all sessions, because their tokens carry the previous role
+func (as AuthUsecase) ChangeRole(userUuid string, role models.KindRole) error {
+	if role == models.UnknownRole {
+		return errors.New("unknown role")
+	}
+	user, err := getUser(as.userRepo, userUuid)
+	if err != nil {
+		return err
+	}
+	user.Role = role
+	if err := as.userRepo.Update(user); err != nil {
+		return fmt.Errorf("could not update user role: %s", err.Error())
+	}
+	if err := as.tokenUsecase.RevokeSessions(user.Uuid.String()); err != nil {
+		return fmt.Errorf("could not revoke sessions: %s", err.Error())
+	}
+	return nil
+}
+
+// Deactivate disables the user and revokes all sessions. Unlike an unconfirmed registration, a deactivated user could
+// not be activated again by a code or an external identity
+func (as AuthUsecase) Deactivate(userUuid string) error {
+	user, err := getUser(as.userRepo, userUuid)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	user.IsActive = false
+	user.DeactivatedAt = &now
+	if err := as.userRepo.Update(user); err != nil {
+		return fmt.Errorf("could not deactivate user: %s", err.Error())
+	}
+	if err := as.tokenUsecase.RevokeSessions(user.Uuid.String()); err != nil {
+		return fmt.Errorf("could not revoke sessions: %s", err.Error())
 	}
 	return nil
 }
@@ -164,50 +356,145 @@ func (as AuthUsecase) ResetPassword(email, password string) error {
 	// hashing password
 	passwordHash, _ := as.hashPassword(password)
 	// normalizing email
-	email = normalizeEmail(email)
+	email = helpers.NormalizeEmail(email)
 	// getting user by email in local repository
 	user, err := as.userRepo.GetByEmail(email)
 	if err != nil {
 		return errors.New("could not get user")
 	}
-	code, err := as.codeRepo.GetLastIsActiveCode(user.Id, "reset_password")
+	if user == nil {
+		return errors.New("user does not exist")
+	}
+	if user.IsDeactivated() {
+		return errors.New("user is deactivated")
+	}
+	// the new password hash waits for the confirmation in the code, encrypted with a key derived from the code
+	code, err := as.issueCode(user, models.ResetPasswordCode, passwordHash)
 	if err != nil {
-		return errors.New("could not get last active code")
+		return err
 	}
-	if code == nil {
-		expTime := time.Now().Add(as.codeExpMinutes)
-		code = NewCode(*user, models.ResetPasswordCode, expTime, passwordHash)
-		if err := as.codeRepo.Create(code); err != nil {
-			return errors.New("could not gen new code")
+	// sending confirmation code via RPC
+	if err := as.mailAdapter.SendEmail(email, "Reset your password🗯", fmt.Sprintf("Your confirmation code is %s", code)); err != nil {
+		return errors.New("could not send email")
+	}
+	return nil
+}
+
+// SendLoginCode emails a one-time sign in code. Unknown and inactive emails are ignored, so the response does not
+// reveal whether the email is registered.
+func (as AuthUsecase) SendLoginCode(email string) error {
+	// normalizing email
+	email = helpers.NormalizeEmail(email)
+	user, err := as.userRepo.GetByEmail(email)
+	if err != nil {
+		return errors.New("could not get user")
+	}
+	if user == nil || !user.IsActive || user.IsDeactivated() {
+		return nil
+	}
+	code, err := as.issueCode(user, models.LoginCode, "")
+	if err != nil {
+		return err
+	}
+	// sending sign in code via RPC
+	if err := as.mailAdapter.SendEmail(email, "Sign in to your account🗯", fmt.Sprintf("Your sign in code is %s", code)); err != nil {
+		return errors.New("could not send email")
+	}
+	return nil
+}
+
+// ResendCode sends a new registration code to an inactive user or a new sign in code to an active one. Unknown emails,
+// the cooldown after the previous code and the daily limit are silent, so the response does not reveal whether the
+// email is registered.
+func (as AuthUsecase) ResendCode(email string, action models.KindCode) error {
+	// normalizing email
+	email = helpers.NormalizeEmail(email)
+	user, err := as.userRepo.GetByEmail(email)
+	if err != nil {
+		return errors.New("could not get user")
+	}
+	if user == nil || user.IsDeactivated() {
+		return nil
+	}
+	var subject, message string
+	switch action {
+	case models.RegistrationCode:
+		if user.IsActive {
+			return nil
+		}
+		subject, message = "Confirm your email🗯", "Your confirmation code is %s"
+	case models.LoginCode:
+		if !user.IsActive {
+			return nil
 		}
+		subject, message = "Sign in to your account🗯", "Your sign in code is %s"
+	default:
+		return errors.New("code could not be resent")
 	}
-	// extend expiration code and return previous active code
-	code.Data = passwordHash
-	code.ExpireAt = time.Now().Add(as.codeExpMinutes)
-	if err := as.codeRepo.Update(code); err != nil {
-		return errors.New("could not extend expiration code")
+	lastCode, err := as.codeRepo.GetLastIsActiveCode(user.Id, action.String())
+	if err != nil {
+		return errors.New("could not get last active code")
 	}
-	// sending confirmation code via RPC
-	if err := as.mailAdapter.SendEmail(email, "Reset your password🗯", fmt.Sprintf("Your confirmation code is %s", code.Code)); err != nil {
+	if lastCode != nil && time.Since(lastCode.CreatedAt) < as.resendCooldown {
+		return nil
+	}
+	count, err := as.codeRepo.CountCreatedSince(user.Id, action.String(), time.Now().Add(-24*time.Hour))
+	if err != nil {
+		return errors.New("could not count sent codes")
+	}
+	if count >= int64(as.resendDailyLimit) {
+		return nil
+	}
+	code, err := as.issueCode(user, action, "")
+	if err != nil {
+		return err
+	}
+	// sending code via RPC
+	if err := as.mailAdapter.SendEmail(email, subject, fmt.Sprintf(message, code)); err != nil {
 		return errors.New("could not send email")
 	}
 	return nil
 }
 
+// SignInWithCode returns the user if the code matches the last sign in code sent to the email, the code is single use
+func (as AuthUsecase) SignInWithCode(email, code string) (*models.User, error) {
+	// normalizing email
+	email = helpers.NormalizeEmail(email)
+	user, err := as.userRepo.GetByEmail(email)
+	if err != nil {
+		return nil, errors.New("could not get user")
+	}
+	if user == nil || !user.IsActive || user.IsDeactivated() {
+		return nil, errors.New("invalid code")
+	}
+	loginCode, err := as.verifyCode(user, code, models.LoginCode)
+	if err != nil {
+		return nil, err
+	}
+	loginCode.IsUsed = true
+	if err := as.codeRepo.Update(loginCode); err != nil {
+		return nil, errors.New("could not use code")
+	}
+	return user, nil
+}
+
 func (as AuthUsecase) CheckPassword(user *models.User, password string) (bool, error) {
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
-		return false, errors.New("invalid credentials")
+		return false, models.ErrInvalidCredentials
 	}
 	return true, nil
 }
 
 func (as AuthUsecase) GetActiveUserByEmail(email string) (*models.User, error) {
 	// normalizing email
-	email = normalizeEmail(email)
+	email = helpers.NormalizeEmail(email)
 	user, err := as.userRepo.GetByEmail(email)
 	if err != nil {
 		return nil, errors.New("could not get user")
 	}
+	if user == nil {
+		return nil, models.ErrUserNotFound
+	}
 	if user.IsActive == false {
 		return nil, errors.New("user is not active")
 	}
@@ -222,58 +509,153 @@ func (as AuthUsecase) hashPassword(password string) (string, error) {
 	return string(hash), nil
 }
 
-func normalizeEmail(data string) string {
-	addr := strings.ToLower(data)
-
-	arrAddr := strings.Split(addr, "@")
-	username := arrAddr[0]
-	domain := arrAddr[1]
+// GetCode returns the active registration, reset password or change email code of the user with the email
+func (as AuthUsecase) GetCode(email, code string) (*models.Code, error) {
+	// normalizing email
+	email = helpers.NormalizeEmail(email)
+	user, err := as.userRepo.GetByEmail(email)
+	if err != nil {
+		return nil, errors.New("could not get user")
+	}
+	if user == nil {
+		return nil, errors.New("invalid code")
+	}
+	return as.verifyCode(user, code, models.RegistrationCode, models.ResetPasswordCode, models.ChangeEmailCode)
+}
 
-	googleDomains := strings.Split(os.Getenv("GOOGLEMAIL_DOMAINS"), ",")
+// verifyCode finds the active code of the user with one of the actions. A miss counts against every active code of
+// these actions, so a code is invalidated after codeMaxAttempts wrong guesses.
+func (as AuthUsecase) verifyCode(user *models.User, value string, actions ...models.KindCode) (*models.Code, error) {
+	codes, err := as.codeRepo.GetActiveByUserId(user.Id)
+	if err != nil {
+		return nil, errors.New("could not get active codes")
+	}
+	candidates := make([]models.Code, 0, len(codes))
+	for _, code := range codes {
+		for _, action := range actions {
+			if code.Action == action {
+				candidates = append(candidates, code)
+			}
+		}
+	}
+	digest := as.codeDigest(value)
+	for i := range candidates {
+		if subtle.ConstantTimeCompare([]byte(candidates[i].Code), []byte(digest)) == 1 {
+			if candidates[i].Data != "" {
+				data, err := as.openCodeData(value, candidates[i].Data)
+				if err != nil {
+					return nil, errors.New("could not decrypt code data")
+				}
+				candidates[i].Data = data
+			}
+			return &candidates[i], nil
+		}
+	}
+	for i := range candidates {
+		if err := as.codeRepo.IncrementAttempts(&candidates[i], codeMaxAttempts); err != nil {
+			return nil, errors.New("could not count code attempt")
+		}
+	}
+	return nil, errors.New("invalid code")
+}
 
-	//checking Google mail aliases
-	if helpers.Contains(googleDomains, domain) {
-		//removing all dots from username mail
-		username = strings.ReplaceAll(username, ".", "")
-		//removing all characters after +
-		if strings.Contains(username, "+") {
-			res := strings.Split(username, "+")
-			username = res[0]
+// genCode returns codeLength characters picked uniformly from codeAlphabet with crypto/rand
+func (as AuthUsecase) genCode() (string, error) {
+	max := big.NewInt(int64(len(as.codeAlphabet)))
+	code := make([]rune, as.codeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
 		}
-		addr = username + "@gmail.com"
+		code[i] = as.codeAlphabet[n.Int64()]
 	}
+	return string(code), nil
+}
 
-	return addr
+// createCode stores the code with a new value and returns the value. Only the HMAC digest of the value is stored and
+// Data is encrypted with a key derived from the value. Digests are unique among active codes, so the value is generated
+// again on a collision, after codes which have expired meanwhile are invalidated.
+func (as AuthUsecase) createCode(code *models.Code) (string, error) {
+	data := code.Data
+	for i := 0; i < codeCreateAttempts; i++ {
+		value, err := as.genCode()
+		if err != nil {
+			return "", err
+		}
+		code.Code = as.codeDigest(value)
+		if data != "" {
+			if code.Data, err = as.sealCodeData(value, data); err != nil {
+				return "", err
+			}
+		}
+		err = as.codeRepo.Create(code)
+		if err == nil {
+			return value, nil
+		}
+		if !errors.Is(err, models.ErrCodeNotUnique) {
+			return "", err
+		}
+		if err := as.codeRepo.InvalidateExpired(); err != nil {
+			return "", err
+		}
+	}
+	return "", models.ErrCodeNotUnique
 }
 
-func (as AuthUsecase) GetCode(code string) (*models.Code, error) {
-	codeObj, err := as.codeRepo.GetByCode(code)
-	if err != nil {
-		return nil, errors.New("could not get data by code")
+// issueCode replaces the active code of the action with a new one and returns the value to send to the user
+func (as AuthUsecase) issueCode(user *models.User, action models.KindCode, data string) (string, error) {
+	if err := as.invalidateCode(user, action); err != nil {
+		return "", err
 	}
-	if codeObj == nil {
-		return nil, errors.New("code does not exist")
+	value, err := as.createCode(NewCode(*user, action, time.Now().Add(as.codeExpMinutes), data))
+	if err != nil {
+		return "", errors.New("could not gen new code")
 	}
-	if codeObj.ExpireAt.Before(time.Now()) {
-		return nil, errors.New("code is expired")
+	return value, nil
+}
+
+// invalidateCode makes the active code of the action unusable, if the user has one
+func (as AuthUsecase) invalidateCode(user *models.User, action models.KindCode) error {
+	previous, err := as.codeRepo.GetLastIsActiveCode(user.Id, action.String())
+	if err != nil {
+		return errors.New("could not get last active code")
 	}
-	if codeObj.IsUsed {
-		return nil, errors.New("code is already used")
+	if previous != nil {
+		previous.IsUsed = true
+		if err := as.codeRepo.Update(previous); err != nil {
+			return errors.New("could not invalidate previous code")
+		}
 	}
-	return codeObj, nil
+	return nil
+}
+
+// codeDigest returns the hex encoded HMAC-SHA256 of the code value, codes are stored and looked up by it
+func (as AuthUsecase) codeDigest(value string) string {
+	mac := hmac.New(sha256.New, as.codeDigestKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
 }
 
-func genCode() string {
-	rand.Seed(time.Now().UnixNano())
-	var availableNumbers [3]int
-	for i := 0; i < 3; i++ {
-		availableNumbers[i] = rand.Intn(9)
+// codeDataEncryptor returns the encryptor of Data of the code, its key is derived from the code value
+func (as AuthUsecase) codeDataEncryptor(value string) (*encryptor.Encryptor, error) {
+	mac := hmac.New(sha256.New, as.codeDataKey)
+	mac.Write([]byte(value))
+	return encryptor.NewEncryptor(base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+func (as AuthUsecase) sealCodeData(value, data string) (string, error) {
+	e, err := as.codeDataEncryptor(value)
+	if err != nil {
+		return "", err
 	}
-	var code string
-	for i := 0; i < 6; i++ {
-		randNum := availableNumbers[rand.Intn(len(availableNumbers))]
+	return e.Encrypt(data)
+}
 
-		code = fmt.Sprintf("%s%d", code, randNum)
+func (as AuthUsecase) openCodeData(value, sealed string) (string, error) {
+	e, err := as.codeDataEncryptor(value)
+	if err != nil {
+		return "", err
 	}
-	return code
+	return e.Decrypt(sealed)
 }
@@ -0,0 +1,68 @@
+package usecases
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenCodeLengthAndAlphabet(t *testing.T) {
+	for _, alphabet := range []string{"0123456789", "ABCDEFGHJKMNPQRSTVWXYZ23456789", "αβγδ"} {
+		for _, length := range []int{1, 6, 12} {
+			as := NewAuthUsecase(nil, nil, nil, nil, nil, nil, nil, 0, length, alphabet, make([]byte, 32), 0, 0)
+			for i := 0; i < 1000; i++ {
+				code, err := as.genCode()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n := len([]rune(code)); n != length {
+					t.Fatalf("expected a code of %d characters from %q, got %q", length, alphabet, code)
+				}
+				for _, c := range code {
+					if !strings.ContainsRune(alphabet, c) {
+						t.Fatalf("code %q has %q which is not in %q", code, c, alphabet)
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestGenCodeDistribution checks with Pearson's chi-squared test that every position is uniform over the alphabet, the
+// threshold is far above the 0.1% critical value of 27.88 for 9 degrees of freedom, so the test does not flake
+func TestGenCodeDistribution(t *testing.T) {
+	const (
+		alphabet  = "0123456789"
+		length    = 6
+		samples   = 20000
+		threshold = 45.0
+	)
+	as := NewAuthUsecase(nil, nil, nil, nil, nil, nil, nil, 0, length, alphabet, make([]byte, 32), 0, 0)
+	counts := make([]map[rune]int, length)
+	for i := range counts {
+		counts[i] = make(map[rune]int)
+	}
+	for i := 0; i < samples; i++ {
+		code, err := as.genCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for position, c := range []rune(code) {
+			counts[position][c]++
+		}
+	}
+	expected := float64(samples) / float64(len(alphabet))
+	for position := range counts {
+		// the last character of the alphabet must not be cut off by an off-by-one in the random range
+		if counts[position]['9'] == 0 {
+			t.Fatalf("digit 9 never appears at position %d", position)
+		}
+		chiSquared := 0.0
+		for _, c := range alphabet {
+			diff := float64(counts[position][c]) - expected
+			chiSquared += diff * diff / expected
+		}
+		if chiSquared > threshold {
+			t.Fatalf("position %d is not uniform, chi-squared %.2f: %v", position, chiSquared, counts[position])
+		}
+	}
+}
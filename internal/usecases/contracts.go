@@ -3,25 +3,75 @@ package usecases
 import (
 	"github.com/aerosystems/auth-service/internal/models"
 	"github.com/google/uuid"
+	"time"
 )
 
 type UserRepository interface {
 	GetByUuid(Uuid uuid.UUID) (*models.User, error)
 	GetByEmail(Email string) (*models.User, error)
+	GetById(Id int) (*models.User, error)
 	Create(user *models.User) error
 	Update(user *models.User) error
 	Delete(user *models.User) error
+	GetDueForDeletion(before time.Time) ([]models.User, error)
 }
 
 type CodeRepository interface {
 	GetById(Id int) (*models.Code, error)
-	GetByCode(value string) (*models.Code, error)
+	GetByCode(digest string) (*models.Code, error)
 	GetLastIsActiveCode(UserId int, Action string) (*models.Code, error)
+	InvalidateExpired() error
+	CountCreatedSince(UserId int, Action string, since time.Time) (int64, error)
+	GetActiveByUserId(UserId int) ([]models.Code, error)
+	IncrementAttempts(code *models.Code, maxAttempts int) error
 	Create(code *models.Code) error
 	UpdateWithAssociations(code *models.Code) error
 	Update(code *models.Code) error
 }
 
+type SigningKeyRepository interface {
+	GetByKid(Kid string) (*models.SigningKey, error)
+	GetAll() ([]models.SigningKey, error)
+	Create(key *models.SigningKey) error
+	Update(key *models.SigningKey) error
+}
+
+type UserIdentityRepository interface {
+	GetByProviderSubject(Provider, Subject string) (*models.UserIdentity, error)
+	GetByUserId(UserId int) ([]models.UserIdentity, error)
+	Create(identity *models.UserIdentity) error
+	Delete(identity *models.UserIdentity) error
+}
+
+type TotpRepository interface {
+	GetByUserId(UserId int) (*models.Totp, error)
+	Create(totp *models.Totp) error
+	Update(totp *models.Totp) error
+	UpdateLastUsedCounter(totp *models.Totp, counter int64) (bool, error)
+	Delete(totp *models.Totp) error
+}
+
+type RecoveryCodeRepository interface {
+	GetUnusedByUserId(UserId int) ([]models.RecoveryCode, error)
+	Replace(UserId int, codes []models.RecoveryCode) error
+	MarkUsed(code *models.RecoveryCode) (bool, error)
+}
+
+type PasskeyRepository interface {
+	GetByCredentialId(CredentialId []byte) (*models.Passkey, error)
+	GetByUserId(UserId int) ([]models.Passkey, error)
+	Create(passkey *models.Passkey) error
+	UpdateSignCount(passkey *models.Passkey, signCount uint32) (bool, error)
+	Delete(passkey *models.Passkey) error
+}
+
+type ClientRepository interface {
+	GetByClientId(ClientId string) (*models.Client, error)
+	GetAll() ([]models.Client, error)
+	Create(client *models.Client) error
+	Delete(client *models.Client) error
+}
+
 type CheckmailAdapter interface {
 	IsTrustEmail(email, clientIp string) (bool, error)
 }
@@ -32,4 +82,11 @@ type MailAdapter interface {
 
 type CustomerAdapter interface {
 	CreateCustomer() (uuid.UUID, error)
+	DeleteCustomer(uuid uuid.UUID) error
+}
+
+// IdentityProvider verifies credentials issued by an external identity provider like Google or GitHub
+type IdentityProvider interface {
+	Name() string
+	Authenticate(credential *models.ExternalCredential) (*models.ExternalIdentity, error)
 }
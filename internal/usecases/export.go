@@ -0,0 +1,56 @@
+package usecases
+
+import (
+	"fmt"
+	"github.com/aerosystems/auth-service/internal/models"
+	"time"
+)
+
+// ExportUsecase collects the personal data of a user from PostgreSQL and Redis
+type ExportUsecase struct {
+	userRepo         UserRepository
+	codeRepo         CodeRepository
+	userIdentityRepo UserIdentityRepository
+	tokenUsecase     *TokenUsecase
+}
+
+func NewExportUsecase(userRepo UserRepository, codeRepo CodeRepository, userIdentityRepo UserIdentityRepository, tokenUsecase *TokenUsecase) *ExportUsecase {
+	return &ExportUsecase{
+		userRepo:         userRepo,
+		codeRepo:         codeRepo,
+		userIdentityRepo: userIdentityRepo,
+		tokenUsecase:     tokenUsecase,
+	}
+}
+
+// ExportUser returns the profile, linked identities, active sessions, login history and pending codes of the user
+func (eu ExportUsecase) ExportUser(userUuid string) (*models.UserExport, error) {
+	user, err := getUser(eu.userRepo, userUuid)
+	if err != nil {
+		return nil, err
+	}
+	identities, err := eu.userIdentityRepo.GetByUserId(user.Id)
+	if err != nil {
+		return nil, fmt.Errorf("could not get identities: %s", err.Error())
+	}
+	sessions, err := eu.tokenUsecase.GetSessions(user.Uuid.String())
+	if err != nil {
+		return nil, fmt.Errorf("could not get sessions: %s", err.Error())
+	}
+	loginHistory, err := eu.tokenUsecase.GetLoginHistory(user.Uuid.String())
+	if err != nil {
+		return nil, fmt.Errorf("could not get login history: %s", err.Error())
+	}
+	codes, err := eu.codeRepo.GetActiveByUserId(user.Id)
+	if err != nil {
+		return nil, fmt.Errorf("could not get codes: %s", err.Error())
+	}
+	return &models.UserExport{
+		User:         *user,
+		Identities:   identities,
+		Sessions:     sessions,
+		LoginHistory: loginHistory,
+		PendingCodes: codes,
+		ExportedAt:   time.Now(),
+	}, nil
+}
@@ -0,0 +1,202 @@
+package usecases
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aerosystems/auth-service/internal/helpers"
+	"github.com/aerosystems/auth-service/internal/models"
+	"sort"
+)
+
+type IdentityUsecase struct {
+	userRepo        UserRepository
+	identityRepo    UserIdentityRepository
+	customerAdapter CustomerAdapter
+	providers       map[string]IdentityProvider
+}
+
+func NewIdentityUsecase(userRepo UserRepository, identityRepo UserIdentityRepository, customerAdapter CustomerAdapter, providers []IdentityProvider) *IdentityUsecase {
+	providersMap := make(map[string]IdentityProvider, len(providers))
+	for _, provider := range providers {
+		providersMap[provider.Name()] = provider
+	}
+	return &IdentityUsecase{
+		userRepo:        userRepo,
+		identityRepo:    identityRepo,
+		customerAdapter: customerAdapter,
+		providers:       providersMap,
+	}
+}
+
+// GetProviders returns names of the configured identity providers
+func (iu IdentityUsecase) GetProviders() []string {
+	names := make([]string, 0, len(iu.providers))
+	for name := range iu.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SignIn returns the user of an external identity found by linked identity, otherwise a new customer is created with the
+// verified email. The provider has verified the email, so an unconfirmed registration with this email is activated,
+// unless it was deactivated by staff. Active accounts with this email are not linked automatically.
+func (iu IdentityUsecase) SignIn(providerName string, credential *models.ExternalCredential) (*models.User, error) {
+	identity, err := iu.authenticate(providerName, credential)
+	if err != nil {
+		return nil, err
+	}
+	userIdentity, err := iu.identityRepo.GetByProviderSubject(identity.Provider, identity.Subject)
+	if err != nil {
+		return nil, errors.New("could not get identity")
+	}
+	if userIdentity != nil {
+		user, err := iu.userRepo.GetById(userIdentity.UserId)
+		if err != nil || user == nil {
+			return nil, errors.New("could not get user")
+		}
+		if !user.IsActive || user.IsDeactivated() {
+			return nil, errors.New("user is not active")
+		}
+		return user, nil
+	}
+	if !identity.EmailVerified || identity.Email == "" {
+		return nil, fmt.Errorf("%s account email is not verified", identity.Provider)
+	}
+	email := helpers.NormalizeEmail(identity.Email)
+	user, err := iu.userRepo.GetByEmail(email)
+	if err != nil {
+		return nil, errors.New("could not get user")
+	}
+	if user != nil && user.IsDeactivated() {
+		return nil, errors.New("user is not active")
+	}
+	// an existing account is linked only by its owner with LinkIdentity, a matching email is not a proof of ownership
+	// when the email claim is controlled by another party
+	if user != nil && user.IsActive {
+		return nil, fmt.Errorf("user with this email already exists, sign in and link the %s account", identity.Provider)
+	}
+	if user == nil {
+		customerUuid, err := iu.customerAdapter.CreateCustomer()
+		if err != nil {
+			return nil, fmt.Errorf("could not create customer: %s", err.Error())
+		}
+		user = NewUser(email, "")
+		user.Uuid = customerUuid
+		user.Role = models.CustomerRole
+		user.IsActive = true
+		if err := iu.userRepo.Create(user); err != nil {
+			return nil, fmt.Errorf("could not create new user: %s", err.Error())
+		}
+	} else if !user.IsActive {
+		customerUuid, err := iu.customerAdapter.CreateCustomer()
+		if err != nil {
+			return nil, fmt.Errorf("could not activate user: %s", err.Error())
+		}
+		user.Uuid = customerUuid
+		user.IsActive = true
+		// the password of an unconfirmed registration was never proven by the email owner
+		user.PasswordHash = ""
+		if err := iu.userRepo.Update(user); err != nil {
+			return nil, fmt.Errorf("could not activate user: %s", err.Error())
+		}
+	}
+	if _, err := iu.link(user, identity); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (iu IdentityUsecase) GetIdentities(userUuid string) ([]models.UserIdentity, error) {
+	user, err := getUser(iu.userRepo, userUuid)
+	if err != nil {
+		return nil, err
+	}
+	identities, err := iu.identityRepo.GetByUserId(user.Id)
+	if err != nil {
+		return nil, errors.New("could not get identities")
+	}
+	return identities, nil
+}
+
+// LinkIdentity links an account of the identity provider to the authenticated user
+func (iu IdentityUsecase) LinkIdentity(userUuid, providerName string, credential *models.ExternalCredential) (*models.UserIdentity, error) {
+	identity, err := iu.authenticate(providerName, credential)
+	if err != nil {
+		return nil, err
+	}
+	user, err := getUser(iu.userRepo, userUuid)
+	if err != nil {
+		return nil, err
+	}
+	userIdentity, err := iu.identityRepo.GetByProviderSubject(identity.Provider, identity.Subject)
+	if err != nil {
+		return nil, errors.New("could not get identity")
+	}
+	if userIdentity != nil {
+		if userIdentity.UserId != user.Id {
+			return nil, fmt.Errorf("%s account is linked to another user", identity.Provider)
+		}
+		return userIdentity, nil
+	}
+	return iu.link(user, identity)
+}
+
+// UnlinkIdentity removes the link to the identity provider, unless it is the only way for the user to sign in
+func (iu IdentityUsecase) UnlinkIdentity(userUuid, providerName string) error {
+	user, err := getUser(iu.userRepo, userUuid)
+	if err != nil {
+		return err
+	}
+	identities, err := iu.identityRepo.GetByUserId(user.Id)
+	if err != nil {
+		return errors.New("could not get identities")
+	}
+	for i := range identities {
+		if identities[i].Provider != providerName {
+			continue
+		}
+		if user.PasswordHash == "" && len(identities) == 1 {
+			return errors.New("could not unlink the only sign in method, set a password first")
+		}
+		if err := iu.identityRepo.Delete(&identities[i]); err != nil {
+			return fmt.Errorf("could not unlink identity: %s", err.Error())
+		}
+		return nil
+	}
+	return fmt.Errorf("%s account is not linked", providerName)
+}
+
+func (iu IdentityUsecase) authenticate(providerName string, credential *models.ExternalCredential) (*models.ExternalIdentity, error) {
+	provider, ok := iu.providers[providerName]
+	if !ok {
+		return nil, errors.New("unknown identity provider")
+	}
+	identity, err := provider.Authenticate(credential)
+	if err != nil {
+		return nil, fmt.Errorf("could not authenticate with %s: %s", providerName, err.Error())
+	}
+	return identity, nil
+}
+
+func (iu IdentityUsecase) link(user *models.User, identity *models.ExternalIdentity) (*models.UserIdentity, error) {
+	identities, err := iu.identityRepo.GetByUserId(user.Id)
+	if err != nil {
+		return nil, errors.New("could not get identities")
+	}
+	for _, userIdentity := range identities {
+		if userIdentity.Provider == identity.Provider {
+			return nil, fmt.Errorf("user is already linked to another %s account", identity.Provider)
+		}
+	}
+	userIdentity := &models.UserIdentity{
+		UserId:   user.Id,
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	}
+	if err := iu.identityRepo.Create(userIdentity); err != nil {
+		return nil, fmt.Errorf("could not link identity: %s", err.Error())
+	}
+	return userIdentity, nil
+}
@@ -0,0 +1,152 @@
+package usecases
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aerosystems/auth-service/internal/models"
+	"github.com/aerosystems/auth-service/pkg/encryptor"
+	"github.com/aerosystems/auth-service/pkg/jwk"
+	"sync"
+	"time"
+)
+
+const (
+	keyRingReloadInterval = time.Minute
+	keyRingMissInterval   = 10 * time.Second
+)
+
+// keyRing caches the signing keys stored in the repository. It holds one active key which signs new tokens, pending keys
+// which are already published but not used yet, and retired keys which still verify tokens until their grace period ends.
+type keyRing struct {
+	mutex     sync.RWMutex
+	repo      SigningKeyRepository
+	encryptor *encryptor.Encryptor
+	keySet    *jwk.KeySet
+	expireAt  map[string]time.Time
+	parsed    map[string]*jwk.Key
+	loadedAt  time.Time
+}
+
+func newKeyRing(repo SigningKeyRepository, encryptor *encryptor.Encryptor) *keyRing {
+	return &keyRing{
+		repo:      repo,
+		encryptor: encryptor,
+		parsed:    make(map[string]*jwk.Key),
+	}
+}
+
+// Active returns the key used to sign new tokens
+func (kr *keyRing) Active() (*jwk.Key, error) {
+	keySet, err := kr.current(false)
+	if err != nil {
+		return nil, err
+	}
+	return keySet.Active(), nil
+}
+
+// Lookup returns the key with the given kid while it is allowed to verify tokens
+func (kr *keyRing) Lookup(kid string) (*jwk.Key, error) {
+	keySet, err := kr.current(false)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := keySet.Lookup(kid)
+	if !ok {
+		// the key could be added by another instance since the last reload
+		if keySet, err = kr.current(true); err != nil {
+			return nil, err
+		}
+		if key, ok = keySet.Lookup(kid); !ok {
+			return nil, fmt.Errorf("unknown signing key %s", kid)
+		}
+	}
+	kr.mutex.RLock()
+	expireAt, retired := kr.expireAt[kid]
+	kr.mutex.RUnlock()
+	if retired && expireAt.Before(time.Now()) {
+		return nil, fmt.Errorf("signing key %s is expired", kid)
+	}
+	return key, nil
+}
+
+// Public returns the keys allowed to verify tokens as a JWKS document
+func (kr *keyRing) Public() (jwk.Set, error) {
+	keySet, err := kr.current(false)
+	if err != nil {
+		return jwk.Set{}, err
+	}
+	return keySet.Public(), nil
+}
+
+// Invalidate forces the next call to reload keys from the repository
+func (kr *keyRing) Invalidate() {
+	kr.mutex.Lock()
+	defer kr.mutex.Unlock()
+	kr.loadedAt = time.Time{}
+}
+
+func (kr *keyRing) current(miss bool) (*jwk.KeySet, error) {
+	kr.mutex.RLock()
+	keySet, loadedAt := kr.keySet, kr.loadedAt
+	kr.mutex.RUnlock()
+	interval := keyRingReloadInterval
+	if miss {
+		interval = keyRingMissInterval
+	}
+	if keySet != nil && time.Since(loadedAt) < interval {
+		return keySet, nil
+	}
+	return kr.reload()
+}
+
+func (kr *keyRing) reload() (*jwk.KeySet, error) {
+	kr.mutex.Lock()
+	defer kr.mutex.Unlock()
+	signingKeys, err := kr.repo.GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("could not get signing keys: %s", err.Error())
+	}
+	var active *models.SigningKey
+	keys := make([]*jwk.Key, 0, len(signingKeys))
+	expireAt := make(map[string]time.Time)
+	for i, signingKey := range signingKeys {
+		switch signingKey.Status {
+		case models.ActiveKeyStatus:
+			// during a promotion two keys could be active, the newest one wins
+			if active == nil || signingKey.CreatedAt.After(active.CreatedAt) {
+				active = &signingKeys[i]
+			}
+		case models.RetiredKeyStatus:
+			if signingKey.ExpireAt.Before(time.Now()) {
+				continue
+			}
+			expireAt[signingKey.Kid] = signingKey.ExpireAt
+		case models.PendingKeyStatus:
+		default:
+			continue
+		}
+		key, ok := kr.parsed[signingKey.Kid]
+		if !ok {
+			privateKey, err := kr.encryptor.Decrypt(signingKey.EncryptedPrivateKey)
+			if err != nil {
+				return nil, fmt.Errorf("could not decrypt signing key %s: %s", signingKey.Kid, err.Error())
+			}
+			if key, err = jwk.ParsePrivateKeyPEM(signingKey.Kid, []byte(privateKey)); err != nil {
+				return nil, fmt.Errorf("could not parse signing key %s: %s", signingKey.Kid, err.Error())
+			}
+			kr.parsed[signingKey.Kid] = key
+		}
+		keys = append(keys, key)
+	}
+	if active == nil {
+		return nil, errors.New("there is no active signing key")
+	}
+	keySet, err := jwk.NewKeySet(keys, active.Kid)
+	if err != nil {
+		return nil, err
+	}
+	kr.keySet = keySet
+	kr.expireAt = expireAt
+	kr.loadedAt = time.Now()
+	return keySet, nil
+}
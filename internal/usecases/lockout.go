@@ -0,0 +1,158 @@
+package usecases
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aerosystems/auth-service/internal/helpers"
+	"github.com/go-redis/redis/v7"
+	"time"
+)
+
+const (
+	signInBaseDelay = time.Second
+	signInMaxDelay  = time.Minute
+)
+
+var (
+	ErrAccountLocked  = errors.New("account is temporarily locked after too many failed sign in attempts")
+	ErrSignInDelayed  = errors.New("too many failed sign in attempts, try again later")
+	errUnknownLockout = errors.New("could not check failed sign in attempts")
+)
+
+// LockoutUsecase counts failed sign in attempts by email and by client IP. After delayAfter failures every next attempt
+// has to wait twice as long as the previous one, after lockThreshold failures of the email the account is locked for
+// lockDuration. Failures of the IP only delay attempts, they never lock an account.
+type LockoutUsecase struct {
+	cache         *redis.Client
+	userRepo      UserRepository
+	mailAdapter   MailAdapter
+	delayAfter    int64
+	lockThreshold int64
+	lockDuration  time.Duration
+}
+
+func NewLockoutUsecase(cache *redis.Client, userRepo UserRepository, mailAdapter MailAdapter, delayAfter, lockThreshold, lockMinutes int) *LockoutUsecase {
+	return &LockoutUsecase{
+		cache:         cache,
+		userRepo:      userRepo,
+		mailAdapter:   mailAdapter,
+		delayAfter:    int64(delayAfter),
+		lockThreshold: int64(lockThreshold),
+		lockDuration:  time.Duration(lockMinutes) * time.Minute,
+	}
+}
+
+// CheckSignIn returns an error and the time to wait if the attempt has to be rejected without checking credentials
+func (lu LockoutUsecase) CheckSignIn(email, clientIp string) (time.Duration, error) {
+	email = helpers.NormalizeEmail(email)
+	pipe := lu.cache.Pipeline()
+	lock := pipe.PTTL(signInLockKey(email))
+	emailDelay := pipe.PTTL(signInDelayKey("email", email))
+	ipDelay := pipe.PTTL(signInDelayKey("ip", clientIp))
+	if _, err := pipe.Exec(); err != nil && err != redis.Nil {
+		return 0, errUnknownLockout
+	}
+	if lock.Val() > 0 {
+		return lock.Val(), ErrAccountLocked
+	}
+	if delay := maxDuration(emailDelay.Val(), ipDelay.Val()); delay > 0 {
+		return delay, ErrSignInDelayed
+	}
+	return 0, nil
+}
+
+// RegisterFailure counts the failed attempt, sets the delay for the next one and locks the account at the threshold
+func (lu LockoutUsecase) RegisterFailure(email, clientIp string) error {
+	email = helpers.NormalizeEmail(email)
+	pipe := lu.cache.TxPipeline()
+	emailFailures := pipe.Incr(signInFailuresKey("email", email))
+	pipe.Expire(signInFailuresKey("email", email), lu.lockDuration)
+	ipFailures := pipe.Incr(signInFailuresKey("ip", clientIp))
+	pipe.Expire(signInFailuresKey("ip", clientIp), lu.lockDuration)
+	if _, err := pipe.Exec(); err != nil {
+		return fmt.Errorf("could not count failed sign in attempt: %s", err.Error())
+	}
+	if emailFailures.Val() >= lu.lockThreshold {
+		return lu.lock(email)
+	}
+	if delay := lu.delay(emailFailures.Val()); delay > 0 {
+		lu.cache.Set(signInDelayKey("email", email), 1, delay)
+	}
+	// an IP is shared by many users behind NAT, so its delays start only after the lock threshold of a single email
+	if delay := lu.delay(ipFailures.Val() - lu.lockThreshold + lu.delayAfter); delay > 0 {
+		lu.cache.Set(signInDelayKey("ip", clientIp), 1, delay)
+	}
+	return nil
+}
+
+// RegisterSuccess forgets failed attempts of the email, failures of the IP expire on their own
+func (lu LockoutUsecase) RegisterSuccess(email string) {
+	email = helpers.NormalizeEmail(email)
+	lu.cache.Del(signInFailuresKey("email", email), signInDelayKey("email", email))
+}
+
+// Unlock lets staff lift the lock of the account before it expires
+func (lu LockoutUsecase) Unlock(userUuid string) error {
+	user, err := getUser(lu.userRepo, userUuid)
+	if err != nil {
+		return err
+	}
+	email := helpers.NormalizeEmail(user.Email)
+	if err := lu.cache.Del(signInLockKey(email), signInFailuresKey("email", email), signInDelayKey("email", email)).Err(); err != nil {
+		return fmt.Errorf("could not unlock user: %s", err.Error())
+	}
+	return nil
+}
+
+func (lu LockoutUsecase) lock(email string) error {
+	locked, err := lu.cache.SetNX(signInLockKey(email), 1, lu.lockDuration).Result()
+	if err != nil {
+		return fmt.Errorf("could not lock user: %s", err.Error())
+	}
+	lu.cache.Del(signInFailuresKey("email", email), signInDelayKey("email", email))
+	if !locked {
+		return nil
+	}
+	user, err := lu.userRepo.GetByEmail(email)
+	if err != nil || user == nil || !user.IsActive {
+		return nil
+	}
+	body := fmt.Sprintf("Your account was locked for %d minutes after too many failed sign in attempts. If it was not you, consider changing your password.", int(lu.lockDuration.Minutes()))
+	if err := lu.mailAdapter.SendEmail(user.Email, "Your account was locked🗯", body); err != nil {
+		return fmt.Errorf("could not send email: %s", err.Error())
+	}
+	return nil
+}
+
+func (lu LockoutUsecase) delay(failures int64) time.Duration {
+	if failures < lu.delayAfter {
+		return 0
+	}
+	delay := signInBaseDelay
+	for i := lu.delayAfter; i < failures && delay < signInMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > signInMaxDelay {
+		delay = signInMaxDelay
+	}
+	return delay
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func signInFailuresKey(kind, value string) string {
+	return "sign-in-failures:" + kind + ":" + value
+}
+
+func signInDelayKey(kind, value string) string {
+	return "sign-in-delay:" + kind + ":" + value
+}
+
+func signInLockKey(email string) string {
+	return "sign-in-lock:" + email
+}
@@ -0,0 +1,351 @@
+package usecases
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aerosystems/auth-service/internal/models"
+	"github.com/aerosystems/auth-service/pkg/encryptor"
+	"github.com/aerosystems/auth-service/pkg/totp"
+	"github.com/go-redis/redis/v7"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	mfaChallengeExp         = 5 * time.Minute
+	mfaChallengeMaxAttempts = 5
+	recoveryCodesCount      = 10
+	recoveryCodeLength      = 10
+	recoveryCodeAlphabet    = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+var ErrInvalidMfaCode = errors.New("invalid authentication code")
+
+type MfaUsecase struct {
+	cache            *redis.Client
+	totpRepo         TotpRepository
+	recoveryCodeRepo RecoveryCodeRepository
+	userRepo         UserRepository
+	mailAdapter      MailAdapter
+	encryptor        *encryptor.Encryptor
+	totpIssuer       string
+}
+
+func NewMfaUsecase(cache *redis.Client, totpRepo TotpRepository, recoveryCodeRepo RecoveryCodeRepository, userRepo UserRepository, mailAdapter MailAdapter, encryptor *encryptor.Encryptor, totpIssuer string) *MfaUsecase {
+	return &MfaUsecase{
+		cache:            cache,
+		totpRepo:         totpRepo,
+		recoveryCodeRepo: recoveryCodeRepo,
+		userRepo:         userRepo,
+		mailAdapter:      mailAdapter,
+		encryptor:        encryptor,
+		totpIssuer:       totpIssuer,
+	}
+}
+
+// EnrollTotp generates a new secret for the authenticator app. It is not required on sign in until it is confirmed
+// with the first code, an unconfirmed secret is replaced by the next enrollment.
+func (mu MfaUsecase) EnrollTotp(userUuid string) (*models.TotpEnrollment, error) {
+	user, err := getUser(mu.userRepo, userUuid)
+	if err != nil {
+		return nil, err
+	}
+	userTotp, err := mu.totpRepo.GetByUserId(user.Id)
+	if err != nil {
+		return nil, errors.New("could not get totp")
+	}
+	if userTotp != nil && userTotp.IsConfirmed {
+		return nil, errors.New("totp is already enabled")
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	encryptedSecret, err := mu.encryptor.Encrypt(secret)
+	if err != nil {
+		return nil, fmt.Errorf("could not encrypt totp secret: %s", err.Error())
+	}
+	if userTotp == nil {
+		userTotp = &models.Totp{UserId: user.Id}
+	}
+	userTotp.EncryptedSecret = encryptedSecret
+	userTotp.LastUsedCounter = 0
+	if userTotp.Id == 0 {
+		err = mu.totpRepo.Create(userTotp)
+	} else {
+		err = mu.totpRepo.Update(userTotp)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not save totp: %s", err.Error())
+	}
+	return &models.TotpEnrollment{
+		Secret: secret,
+		Uri:    totp.URI(mu.totpIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTotp enables the second factor once the user proves the authenticator app is set up. It returns the recovery
+// codes, they are shown only once.
+func (mu MfaUsecase) ConfirmTotp(userUuid, code string) ([]string, error) {
+	user, err := getUser(mu.userRepo, userUuid)
+	if err != nil {
+		return nil, err
+	}
+	userTotp, err := mu.totpRepo.GetByUserId(user.Id)
+	if err != nil {
+		return nil, errors.New("could not get totp")
+	}
+	if userTotp == nil {
+		return nil, errors.New("totp is not enrolled")
+	}
+	if userTotp.IsConfirmed {
+		return nil, errors.New("totp is already enabled")
+	}
+	if err := mu.checkTotp(userTotp, code); err != nil {
+		return nil, err
+	}
+	recoveryCodes, err := mu.createRecoveryCodes(user)
+	if err != nil {
+		return nil, err
+	}
+	userTotp.IsConfirmed = true
+	if err := mu.totpRepo.Update(userTotp); err != nil {
+		return nil, fmt.Errorf("could not enable totp: %s", err.Error())
+	}
+	return recoveryCodes, nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user, the previous ones are no longer valid
+func (mu MfaUsecase) RegenerateRecoveryCodes(userUuid, code string) ([]string, error) {
+	user, err := getUser(mu.userRepo, userUuid)
+	if err != nil {
+		return nil, err
+	}
+	userTotp, err := mu.totpRepo.GetByUserId(user.Id)
+	if err != nil {
+		return nil, errors.New("could not get totp")
+	}
+	if userTotp == nil || !userTotp.IsConfirmed {
+		return nil, errors.New("totp is not enabled")
+	}
+	if err := mu.checkTotp(userTotp, code); err != nil {
+		return nil, err
+	}
+	return mu.createRecoveryCodes(user)
+}
+
+// DisableTotp turns the second factor off, the user proves it with a current code
+func (mu MfaUsecase) DisableTotp(userUuid, code string) error {
+	user, err := getUser(mu.userRepo, userUuid)
+	if err != nil {
+		return err
+	}
+	userTotp, err := mu.totpRepo.GetByUserId(user.Id)
+	if err != nil {
+		return errors.New("could not get totp")
+	}
+	if userTotp == nil || !userTotp.IsConfirmed {
+		return errors.New("totp is not enabled")
+	}
+	if err := mu.checkTotp(userTotp, code); err != nil {
+		return err
+	}
+	if err := mu.totpRepo.Delete(userTotp); err != nil {
+		return fmt.Errorf("could not disable totp: %s", err.Error())
+	}
+	if err := mu.recoveryCodeRepo.Replace(user.Id, nil); err != nil {
+		return fmt.Errorf("could not delete recovery codes: %s", err.Error())
+	}
+	return nil
+}
+
+// IsMfaEnabled tells whether sign in of the user requires the second factor
+func (mu MfaUsecase) IsMfaEnabled(user *models.User) (bool, error) {
+	userTotp, err := mu.totpRepo.GetByUserId(user.Id)
+	if err != nil {
+		return false, errors.New("could not get totp")
+	}
+	return userTotp != nil && userTotp.IsConfirmed, nil
+}
+
+// CreateMfaChallenge starts the second step of sign in, the returned token is exchanged for JWT tokens together with the code
+func (mu MfaUsecase) CreateMfaChallenge(user *models.User) (string, error) {
+	mfaToken, err := genSecret()
+	if err != nil {
+		return "", err
+	}
+	challengeJSON, err := json.Marshal(models.MfaChallenge{UserUuid: user.Uuid.String()})
+	if err != nil {
+		return "", err
+	}
+	if err := mu.cache.Set(mfaChallengeKey(mfaToken), challengeJSON, mfaChallengeExp).Err(); err != nil {
+		return "", err
+	}
+	return mfaToken, nil
+}
+
+// VerifyMfaChallenge returns the user of the challenge if the code is valid, the code is either from the authenticator
+// app or one of the recovery codes. The challenge is single use and is dropped after too many invalid codes, so the
+// password has to be entered again.
+func (mu MfaUsecase) VerifyMfaChallenge(mfaToken, code string) (*models.User, error) {
+	challengeJSON, err := mu.cache.Get(mfaChallengeKey(mfaToken)).Result()
+	if err != nil {
+		return nil, errors.New("mfa token is invalid or expired")
+	}
+	challenge := new(models.MfaChallenge)
+	if err := json.Unmarshal([]byte(challengeJSON), challenge); err != nil {
+		return nil, err
+	}
+	user, err := getUser(mu.userRepo, challenge.UserUuid)
+	if err != nil {
+		return nil, err
+	}
+	userTotp, err := mu.totpRepo.GetByUserId(user.Id)
+	if err != nil {
+		return nil, errors.New("could not get totp")
+	}
+	if userTotp == nil || !userTotp.IsConfirmed {
+		return nil, errors.New("totp is not enabled")
+	}
+	if isTotpCode(code) {
+		err = mu.checkTotp(userTotp, code)
+	} else {
+		err = mu.useRecoveryCode(user, code)
+	}
+	if err != nil {
+		if errors.Is(err, ErrInvalidMfaCode) {
+			mu.countFailedAttempt(mfaToken)
+		}
+		return nil, err
+	}
+	if deleted, err := mu.cache.Del(mfaChallengeKey(mfaToken), mfaAttemptsKey(mfaToken)).Result(); err != nil || deleted == 0 {
+		return nil, errors.New("mfa token is invalid or expired")
+	}
+	return user, nil
+}
+
+func (mu MfaUsecase) countFailedAttempt(mfaToken string) {
+	pipe := mu.cache.TxPipeline()
+	attempts := pipe.Incr(mfaAttemptsKey(mfaToken))
+	pipe.Expire(mfaAttemptsKey(mfaToken), mfaChallengeExp)
+	if _, err := pipe.Exec(); err != nil {
+		return
+	}
+	if attempts.Val() >= mfaChallengeMaxAttempts {
+		mu.cache.Del(mfaChallengeKey(mfaToken), mfaAttemptsKey(mfaToken))
+	}
+}
+
+// checkTotp validates the code and rejects codes which were already used
+func (mu MfaUsecase) checkTotp(userTotp *models.Totp, code string) error {
+	secret, err := mu.encryptor.Decrypt(userTotp.EncryptedSecret)
+	if err != nil {
+		return fmt.Errorf("could not decrypt totp secret: %s", err.Error())
+	}
+	counter, ok := totp.Validate(secret, code, time.Now())
+	if !ok || counter <= userTotp.LastUsedCounter {
+		return ErrInvalidMfaCode
+	}
+	updated, err := mu.totpRepo.UpdateLastUsedCounter(userTotp, counter)
+	if err != nil {
+		return fmt.Errorf("could not update totp: %s", err.Error())
+	}
+	if !updated {
+		return ErrInvalidMfaCode
+	}
+	userTotp.LastUsedCounter = counter
+	return nil
+}
+
+func (mu MfaUsecase) createRecoveryCodes(user *models.User) ([]string, error) {
+	plainCodes := make([]string, 0, recoveryCodesCount)
+	codes := make([]models.RecoveryCode, 0, recoveryCodesCount)
+	for i := 0; i < recoveryCodesCount; i++ {
+		plainCode, err := genRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(normalizeRecoveryCode(plainCode)), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		plainCodes = append(plainCodes, plainCode)
+		codes = append(codes, models.RecoveryCode{UserId: user.Id, CodeHash: string(hash)})
+	}
+	if err := mu.recoveryCodeRepo.Replace(user.Id, codes); err != nil {
+		return nil, fmt.Errorf("could not save recovery codes: %s", err.Error())
+	}
+	return plainCodes, nil
+}
+
+// useRecoveryCode spends the matching recovery code and notifies the user, so an unexpected use does not go unnoticed
+func (mu MfaUsecase) useRecoveryCode(user *models.User, code string) error {
+	codes, err := mu.recoveryCodeRepo.GetUnusedByUserId(user.Id)
+	if err != nil {
+		return errors.New("could not get recovery codes")
+	}
+	normalizedCode := normalizeRecoveryCode(code)
+	for i := range codes {
+		if bcrypt.CompareHashAndPassword([]byte(codes[i].CodeHash), []byte(normalizedCode)) != nil {
+			continue
+		}
+		used, err := mu.recoveryCodeRepo.MarkUsed(&codes[i])
+		if err != nil {
+			return fmt.Errorf("could not use recovery code: %s", err.Error())
+		}
+		if !used {
+			return ErrInvalidMfaCode
+		}
+		body := fmt.Sprintf("A recovery code was used to sign in to your account. You have %d recovery codes left. If it was not you, change your password and regenerate recovery codes.", len(codes)-1)
+		// the code is spent already, a failed notice must not keep the user from signing in with it
+		if err := mu.mailAdapter.SendEmail(user.Email, "Recovery code was used🗯", body); err != nil {
+			log.Printf("could not send recovery code notice: %s", err)
+		}
+		return nil
+	}
+	return ErrInvalidMfaCode
+}
+
+func mfaChallengeKey(mfaToken string) string {
+	return "mfa-challenge:" + mfaToken
+}
+
+func mfaAttemptsKey(mfaToken string) string {
+	return "mfa-attempts:" + mfaToken
+}
+
+func isTotpCode(code string) bool {
+	if len(code) != 6 {
+		return false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func genRecoveryCode() (string, error) {
+	var sb strings.Builder
+	for i := 0; i < recoveryCodeLength; i++ {
+		if i == recoveryCodeLength/2 {
+			sb.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(recoveryCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		sb.WriteByte(recoveryCodeAlphabet[n.Int64()])
+	}
+	return sb.String(), nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
@@ -0,0 +1,57 @@
+package usecases
+
+import (
+	"errors"
+	"github.com/aerosystems/auth-service/internal/models"
+	"golang.org/x/crypto/bcrypt"
+	"testing"
+)
+
+// fakeRecoveryCodeRepo keeps recovery codes in memory, methods which are not overridden panic
+type fakeRecoveryCodeRepo struct {
+	RecoveryCodeRepository
+	codes []models.RecoveryCode
+}
+
+func (r *fakeRecoveryCodeRepo) GetUnusedByUserId(UserId int) ([]models.RecoveryCode, error) {
+	var codes []models.RecoveryCode
+	for _, code := range r.codes {
+		if code.UserId == UserId && !code.IsUsed {
+			codes = append(codes, code)
+		}
+	}
+	return codes, nil
+}
+
+func (r *fakeRecoveryCodeRepo) MarkUsed(code *models.RecoveryCode) (bool, error) {
+	for i := range r.codes {
+		if r.codes[i].Id == code.Id && !r.codes[i].IsUsed {
+			r.codes[i].IsUsed = true
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type failingMailAdapter struct{}
+
+func (failingMailAdapter) SendEmail(to, subject, body string) error {
+	return errors.New("mail service is unavailable")
+}
+
+func TestUseRecoveryCodeWhenNoticeFails(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("abcd1234"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Id: 1, Email: "user@example.com"}
+	recoveryCodeRepo := &fakeRecoveryCodeRepo{codes: []models.RecoveryCode{{Id: 1, UserId: user.Id, CodeHash: string(hash)}}}
+	mu := NewMfaUsecase(nil, nil, recoveryCodeRepo, nil, failingMailAdapter{}, nil, "")
+
+	if err := mu.useRecoveryCode(user, "ABCD-1234"); err != nil {
+		t.Fatalf("expected the spent code to sign the user in despite the failed notice, got %v", err)
+	}
+	if err := mu.useRecoveryCode(user, "ABCD-1234"); !errors.Is(err, ErrInvalidMfaCode) {
+		t.Fatalf("expected the code to be single use, got %v", err)
+	}
+}
@@ -0,0 +1,376 @@
+package usecases
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aerosystems/auth-service/internal/helpers"
+	"github.com/aerosystems/auth-service/internal/models"
+	"github.com/go-redis/redis/v7"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const authorizationCodeExp = time.Minute
+
+var (
+	ErrInvalidClient    = errors.New("invalid client credentials")
+	SupportedScopes     = []string{"openid", "email"}
+	SupportedGrantTypes = []string{models.AuthorizationCodeGrantType, models.RefreshTokenGrantType, models.ClientCredentialsGrantType}
+	serviceScopePattern = regexp.MustCompile(`^[a-z0-9_.:-]+$`)
+	defaultGrantTypes   = []string{models.AuthorizationCodeGrantType, models.RefreshTokenGrantType}
+)
+
+type OAuthUsecase struct {
+	cache            *redis.Client
+	clientRepo       ClientRepository
+	userRepo         UserRepository
+	tokenUsecase     *TokenUsecase
+	clients          map[string]string
+	issuer           string
+	accessExpMinutes int
+}
+
+func NewOAuthUsecase(cache *redis.Client, clientRepo ClientRepository, userRepo UserRepository, tokenUsecase *TokenUsecase, clients map[string]string, issuer string, accessExpMinutes int) *OAuthUsecase {
+	return &OAuthUsecase{
+		cache:            cache,
+		clientRepo:       clientRepo,
+		userRepo:         userRepo,
+		tokenUsecase:     tokenUsecase,
+		clients:          clients,
+		issuer:           strings.TrimSuffix(issuer, "/"),
+		accessExpMinutes: accessExpMinutes,
+	}
+}
+
+func (ou OAuthUsecase) GetIssuer() string {
+	return ou.issuer
+}
+
+// AuthenticateClient checks credentials of a resource server calling OAuth endpoints. It is either one of the clients
+// from config, which keeps hex encoded SHA-256 hashes of their secrets, or a service client registered with the
+// client_credentials grant.
+func (ou OAuthUsecase) AuthenticateClient(clientId, clientSecret string) error {
+	if clientId == "" {
+		return ErrInvalidClient
+	}
+	if secretHash, ok := ou.clients[clientId]; ok {
+		sum := sha256.Sum256([]byte(clientSecret))
+		if subtle.ConstantTimeCompare([]byte(secretHash), []byte(hex.EncodeToString(sum[:]))) != 1 {
+			return ErrInvalidClient
+		}
+		return nil
+	}
+	client, err := ou.authenticateOidcClient(clientId, clientSecret)
+	if err != nil || client.IsPublic() || !client.HasGrantType(models.ClientCredentialsGrantType) {
+		return ErrInvalidClient
+	}
+	return nil
+}
+
+// Introspect returns the state of the token for an authenticated client
+func (ou OAuthUsecase) Introspect(token, tokenTypeHint string) *models.TokenIntrospection {
+	return ou.tokenUsecase.Introspect(token, tokenTypeHint)
+}
+
+// Revoke signs out the session which the token belongs to (RFC 7009). A client sending its credentials is authenticated
+// and revokes only tokens issued to it. Tokens of confidential clients are revoked only by their client, first-party
+// tokens and tokens of public clients by anyone holding them, like signing out. Invalid tokens are ignored.
+func (ou OAuthUsecase) Revoke(clientId, clientSecret, token, tokenTypeHint string) error {
+	if clientId != "" {
+		if _, err := ou.authenticateOidcClient(clientId, clientSecret); err != nil {
+			return err
+		}
+	}
+	introspection := ou.tokenUsecase.Introspect(token, tokenTypeHint)
+	if !introspection.Active {
+		return nil
+	}
+	// service tokens carry the client in the subject
+	issuedTo := introspection.ClientId
+	if introspection.Role == models.ServiceRole.String() {
+		issuedTo = introspection.Subject
+	}
+	if clientId != "" {
+		if issuedTo != clientId {
+			return models.NewOAuthError("invalid_grant", "token was issued to another client")
+		}
+	} else if issuedTo != "" {
+		client, err := ou.clientRepo.GetByClientId(issuedTo)
+		if err != nil {
+			return errors.New("could not get client")
+		}
+		if client != nil && !client.IsPublic() {
+			return models.NewOAuthError("invalid_client", "client authentication is required")
+		}
+	}
+	return ou.tokenUsecase.Revoke(token, tokenTypeHint)
+}
+
+// CreateClient registers an OIDC client or a service client using the client_credentials grant. The secret is returned
+// only once, public clients get no secret.
+func (ou OAuthUsecase) CreateClient(name string, redirectUris []string, scopes []string, grantTypes []string, isPublic bool) (*models.Client, string, error) {
+	if len(grantTypes) == 0 {
+		grantTypes = defaultGrantTypes
+	}
+	for _, grantType := range grantTypes {
+		if !helpers.Contains(SupportedGrantTypes, grantType) {
+			return nil, "", fmt.Errorf("grant type %s is not supported", grantType)
+		}
+	}
+	isService := helpers.Contains(grantTypes, models.ClientCredentialsGrantType)
+	if isService && isPublic {
+		return nil, "", errors.New("client_credentials grant requires a confidential client")
+	}
+	if helpers.Contains(grantTypes, models.AuthorizationCodeGrantType) && len(redirectUris) == 0 {
+		return nil, "", errors.New("authorization_code grant requires redirect uris")
+	}
+	for _, scope := range scopes {
+		// services define their own scopes, e.g. users:read
+		if !helpers.Contains(SupportedScopes, scope) && !(isService && serviceScopePattern.MatchString(scope)) {
+			return nil, "", fmt.Errorf("scope %s is not supported", scope)
+		}
+	}
+	client := &models.Client{
+		ClientId:     uuid.New().String(),
+		Name:         name,
+		RedirectUris: redirectUris,
+		Scopes:       scopes,
+		GrantTypes:   grantTypes,
+	}
+	var secret string
+	if !isPublic {
+		var err error
+		if secret, err = genSecret(); err != nil {
+			return nil, "", err
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, "", errors.New("could not hash client secret")
+		}
+		client.SecretHash = string(hash)
+	}
+	if err := ou.clientRepo.Create(client); err != nil {
+		return nil, "", fmt.Errorf("could not create client: %s", err.Error())
+	}
+	return client, secret, nil
+}
+
+func (ou OAuthUsecase) GetClients() ([]models.Client, error) {
+	clients, err := ou.clientRepo.GetAll()
+	if err != nil {
+		return nil, errors.New("could not get clients")
+	}
+	return clients, nil
+}
+
+func (ou OAuthUsecase) DeleteClient(clientId string) error {
+	client, err := ou.clientRepo.GetByClientId(clientId)
+	if err != nil {
+		return errors.New("could not get client")
+	}
+	if client == nil {
+		return errors.New("client does not exist")
+	}
+	return ou.clientRepo.Delete(client)
+}
+
+// GetClient returns the client if the redirect uri is registered for it. Until it succeeds, errors could not be sent to the redirect uri.
+func (ou OAuthUsecase) GetClient(clientId, redirectUri string) (*models.Client, error) {
+	client, err := ou.clientRepo.GetByClientId(clientId)
+	if err != nil {
+		return nil, errors.New("could not get client")
+	}
+	if client == nil {
+		return nil, errors.New("client does not exist")
+	}
+	if !client.HasGrantType(models.AuthorizationCodeGrantType) {
+		return nil, errors.New("client is not allowed to use authorization code flow")
+	}
+	if !helpers.Contains(client.RedirectUris, redirectUri) {
+		return nil, errors.New("redirect uri is not registered for the client")
+	}
+	return client, nil
+}
+
+// CheckAuthorizationRequest validates parameters of the authorization request, PKCE with S256 is mandatory for every client
+func (ou OAuthUsecase) CheckAuthorizationRequest(client *models.Client, responseType, scope, codeChallenge, codeChallengeMethod string) error {
+	if responseType != "code" {
+		return models.NewOAuthError("unsupported_response_type", "only code response type is supported")
+	}
+	scopes := strings.Fields(scope)
+	if !helpers.Contains(scopes, "openid") {
+		return models.NewOAuthError("invalid_scope", "openid scope is required")
+	}
+	for _, s := range scopes {
+		if !helpers.Contains(SupportedScopes, s) || (len(client.Scopes) > 0 && !helpers.Contains(client.Scopes, s)) {
+			return models.NewOAuthError("invalid_scope", fmt.Sprintf("scope %s is not allowed", s))
+		}
+	}
+	if codeChallenge == "" || codeChallengeMethod != "S256" {
+		return models.NewOAuthError("invalid_request", "code_challenge with S256 method is required")
+	}
+	return nil
+}
+
+// CreateAuthorizationCode stores the authorization of the user for a short time and returns a one-time code
+func (ou OAuthUsecase) CreateAuthorizationCode(authorizationCode *models.AuthorizationCode) (string, error) {
+	code, err := genSecret()
+	if err != nil {
+		return "", err
+	}
+	authorizationCodeJSON, err := json.Marshal(authorizationCode)
+	if err != nil {
+		return "", err
+	}
+	if err := ou.cache.Set(authorizationCodeKey(code), authorizationCodeJSON, authorizationCodeExp).Err(); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// ExchangeAuthorizationCode implements the authorization_code grant and returns tokens together with an ID token
+func (ou OAuthUsecase) ExchangeAuthorizationCode(clientId, clientSecret, code, redirectUri, codeVerifier, userAgent, ip string) (*models.TokenDetails, error) {
+	client, err := ou.authenticateOidcClient(clientId, clientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if !client.HasGrantType(models.AuthorizationCodeGrantType) {
+		return nil, models.NewOAuthError("unauthorized_client", "client is not allowed to use authorization_code grant")
+	}
+	// the code is single use, so it is dropped right away
+	pipe := ou.cache.TxPipeline()
+	get := pipe.Get(authorizationCodeKey(code))
+	pipe.Del(authorizationCodeKey(code))
+	if _, err := pipe.Exec(); err != nil {
+		return nil, models.NewOAuthError("invalid_grant", "authorization code is invalid or expired")
+	}
+	authorizationCode := new(models.AuthorizationCode)
+	if err := json.Unmarshal([]byte(get.Val()), authorizationCode); err != nil {
+		return nil, err
+	}
+	if authorizationCode.ClientId != client.ClientId || authorizationCode.RedirectUri != redirectUri {
+		return nil, models.NewOAuthError("invalid_grant", "authorization code was issued to another client")
+	}
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	if base64.RawURLEncoding.EncodeToString(challenge[:]) != authorizationCode.CodeChallenge {
+		return nil, models.NewOAuthError("invalid_grant", "code verifier does not match code challenge")
+	}
+	userUuid, err := uuid.Parse(authorizationCode.UserUuid)
+	if err != nil {
+		return nil, err
+	}
+	user, err := ou.userRepo.GetByUuid(userUuid)
+	if err != nil || user == nil || !user.IsActive {
+		return nil, models.NewOAuthError("invalid_grant", "user is not active")
+	}
+	td, err := ou.tokenUsecase.CreateClientToken(user.Uuid.String(), user.Role.String(), client.ClientId, authorizationCode.Scope, userAgent, ip)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	idTokenClaims := &models.IdTokenClaims{
+		Nonce:    authorizationCode.Nonce,
+		AuthTime: authorizationCode.AuthTime.Unix(),
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    ou.issuer,
+			Subject:   user.Uuid.String(),
+			Audience:  client.ClientId,
+			ExpiresAt: now.Add(time.Duration(ou.accessExpMinutes) * time.Minute).Unix(),
+			IssuedAt:  now.Unix(),
+		},
+	}
+	if helpers.Contains(strings.Fields(authorizationCode.Scope), "email") {
+		idTokenClaims.Email = user.Email
+		idTokenClaims.EmailVerified = true
+	}
+	if td.IdToken, err = ou.tokenUsecase.CreateIdToken(idTokenClaims); err != nil {
+		return nil, err
+	}
+	return td, nil
+}
+
+// RefreshTokens implements the refresh_token grant
+func (ou OAuthUsecase) RefreshTokens(clientId, clientSecret, refreshToken string) (*models.TokenDetails, error) {
+	client, err := ou.authenticateOidcClient(clientId, clientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if !client.HasGrantType(models.RefreshTokenGrantType) {
+		return nil, models.NewOAuthError("unauthorized_client", "client is not allowed to use refresh_token grant")
+	}
+	td, err := ou.tokenUsecase.RefreshClientTokens(client.ClientId, refreshToken)
+	if err != nil {
+		return nil, models.NewOAuthError("invalid_grant", err.Error())
+	}
+	return td, nil
+}
+
+// IssueServiceToken implements the client_credentials grant. Without the scope parameter the token gets every scope of
+// the client.
+func (ou OAuthUsecase) IssueServiceToken(clientId, clientSecret, scope string) (*models.TokenDetails, error) {
+	client, err := ou.authenticateOidcClient(clientId, clientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if client.IsPublic() {
+		return nil, models.NewOAuthError("invalid_client", "public clients could not use client_credentials grant")
+	}
+	if !client.HasGrantType(models.ClientCredentialsGrantType) {
+		return nil, models.NewOAuthError("unauthorized_client", "client is not allowed to use client_credentials grant")
+	}
+	scopes := strings.Fields(scope)
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+	for _, s := range scopes {
+		if !helpers.Contains(client.Scopes, s) {
+			return nil, models.NewOAuthError("invalid_scope", fmt.Sprintf("scope %s is not allowed", s))
+		}
+	}
+	return ou.tokenUsecase.CreateServiceToken(client.ClientId, strings.Join(scopes, " "))
+}
+
+// GetUserInfo returns the user for the userinfo endpoint
+func (ou OAuthUsecase) GetUserInfo(userUuid string) (*models.User, error) {
+	return getUser(ou.userRepo, userUuid)
+}
+
+// authenticateOidcClient checks the secret of confidential clients, public clients are identified by client id only
+func (ou OAuthUsecase) authenticateOidcClient(clientId, clientSecret string) (*models.Client, error) {
+	client, err := ou.clientRepo.GetByClientId(clientId)
+	if err != nil {
+		return nil, errors.New("could not get client")
+	}
+	if client == nil {
+		return nil, models.NewOAuthError("invalid_client", "client does not exist")
+	}
+	if client.IsPublic() {
+		return client, nil
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(client.SecretHash), []byte(clientSecret)); err != nil {
+		return nil, models.NewOAuthError("invalid_client", "invalid client credentials")
+	}
+	return client, nil
+}
+
+func authorizationCodeKey(code string) string {
+	return "authorization-code:" + code
+}
+
+func genSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,95 @@
+package usecases
+
+import (
+	"errors"
+	"github.com/aerosystems/auth-service/internal/models"
+	"github.com/google/uuid"
+	"testing"
+)
+
+// fakeClientRepo keeps clients in memory
+type fakeClientRepo struct {
+	ClientRepository
+	clients map[string]*models.Client
+}
+
+func (r *fakeClientRepo) GetByClientId(ClientId string) (*models.Client, error) {
+	return r.clients[ClientId], nil
+}
+
+func (r *fakeClientRepo) Create(client *models.Client) error {
+	r.clients[client.ClientId] = client
+	return nil
+}
+
+func newTestOAuthUsecase(t *testing.T) (*OAuthUsecase, *TokenUsecase) {
+	tokenUsecase := newTestTokenUsecase(t)
+	clientRepo := &fakeClientRepo{clients: make(map[string]*models.Client)}
+	return NewOAuthUsecase(nil, clientRepo, nil, tokenUsecase, nil, "https://auth.example.com", 15), tokenUsecase
+}
+
+func newTestClient(t *testing.T, ou *OAuthUsecase) (*models.Client, string) {
+	client, secret, err := ou.CreateClient("app", []string{"https://app.example.com/callback"}, []string{"openid"}, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, secret
+}
+
+func expectOAuthError(t *testing.T, err error, code string) {
+	t.Helper()
+	var oauthErr *models.OAuthError
+	if !errors.As(err, &oauthErr) || oauthErr.Code != code {
+		t.Fatalf("expected %s error, got %v", code, err)
+	}
+}
+
+func TestRevokeTokenOfConfidentialClientRequiresItsCredentials(t *testing.T) {
+	ou, tokenUsecase := newTestOAuthUsecase(t)
+	client, secret := newTestClient(t, ou)
+	td, err := tokenUsecase.CreateClientToken(uuid.NewString(), models.CustomerRole.String(), client.ClientId, "openid", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectOAuthError(t, ou.Revoke("", "", td.AccessToken, ""), "invalid_client")
+	expectOAuthError(t, ou.Revoke(client.ClientId, "wrong", td.RefreshToken, models.RefreshTokenType), "invalid_client")
+	if !tokenUsecase.Introspect(td.AccessToken, "").Active {
+		t.Fatal("expected the token to stay active without client authentication")
+	}
+
+	if err := ou.Revoke(client.ClientId, secret, td.RefreshToken, models.RefreshTokenType); err != nil {
+		t.Fatalf("expected the client to revoke its token, got %v", err)
+	}
+	if tokenUsecase.Introspect(td.AccessToken, "").Active {
+		t.Fatal("expected the session to be revoked")
+	}
+}
+
+func TestRevokeRejectsTokenOfAnotherClient(t *testing.T) {
+	ou, tokenUsecase := newTestOAuthUsecase(t)
+	owner, _ := newTestClient(t, ou)
+	other, otherSecret := newTestClient(t, ou)
+	td, err := tokenUsecase.CreateClientToken(uuid.NewString(), models.CustomerRole.String(), owner.ClientId, "openid", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstParty, err := tokenUsecase.CreateToken(uuid.NewString(), models.CustomerRole.String(), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectOAuthError(t, ou.Revoke(other.ClientId, otherSecret, td.AccessToken, ""), "invalid_grant")
+	expectOAuthError(t, ou.Revoke(other.ClientId, otherSecret, firstParty.AccessToken, ""), "invalid_grant")
+	if !tokenUsecase.Introspect(td.AccessToken, "").Active || !tokenUsecase.Introspect(firstParty.AccessToken, "").Active {
+		t.Fatal("expected tokens of others to stay active")
+	}
+
+	// first-party tokens are revoked by their holder, like signing out
+	if err := ou.Revoke("", "", firstParty.AccessToken, ""); err != nil {
+		t.Fatalf("expected a first-party token to be revoked without client credentials, got %v", err)
+	}
+	if tokenUsecase.Introspect(firstParty.AccessToken, "").Active {
+		t.Fatal("expected the first-party session to be revoked")
+	}
+}
@@ -0,0 +1,230 @@
+package usecases
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aerosystems/auth-service/internal/models"
+	"github.com/aerosystems/auth-service/pkg/webauthn"
+	"github.com/go-redis/redis/v7"
+	"strings"
+	"time"
+)
+
+const passkeyChallengeExp = 5 * time.Minute
+
+type PasskeyUsecase struct {
+	cache        *redis.Client
+	passkeyRepo  PasskeyRepository
+	userRepo     UserRepository
+	relyingParty *webauthn.RelyingParty
+}
+
+func NewPasskeyUsecase(cache *redis.Client, passkeyRepo PasskeyRepository, userRepo UserRepository, rpId, rpName string, rpOrigins []string) *PasskeyUsecase {
+	return &PasskeyUsecase{
+		cache:        cache,
+		passkeyRepo:  passkeyRepo,
+		userRepo:     userRepo,
+		relyingParty: webauthn.NewRelyingParty(rpId, rpName, rpOrigins, int(passkeyChallengeExp.Milliseconds())),
+	}
+}
+
+// BeginRegistration returns options for navigator.credentials.create(), passkeys of the user are excluded so the same
+// authenticator is not registered twice
+func (pu PasskeyUsecase) BeginRegistration(userUuid string) (*webauthn.CreationOptions, error) {
+	user, err := getUser(pu.userRepo, userUuid)
+	if err != nil {
+		return nil, err
+	}
+	passkeys, err := pu.passkeyRepo.GetByUserId(user.Id)
+	if err != nil {
+		return nil, errors.New("could not get passkeys")
+	}
+	challenge, err := pu.createSession(models.WebAuthnSession{Ceremony: webauthn.CeremonyCreate, UserUuid: user.Uuid.String()})
+	if err != nil {
+		return nil, err
+	}
+	return pu.relyingParty.CreationOptions(challenge, user.Uuid[:], user.Email, user.Email, credentialDescriptors(passkeys)), nil
+}
+
+// FinishRegistration verifies the new credential and stores it for the user
+func (pu PasskeyUsecase) FinishRegistration(userUuid, name string, response *webauthn.AttestationResponse, transports []string) (*models.Passkey, error) {
+	user, err := getUser(pu.userRepo, userUuid)
+	if err != nil {
+		return nil, err
+	}
+	session, challenge, err := pu.takeSession(response.ClientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+	if session.Ceremony != webauthn.CeremonyCreate || session.UserUuid != user.Uuid.String() {
+		return nil, errors.New("challenge is invalid or expired")
+	}
+	credential, err := pu.relyingParty.VerifyRegistration(challenge, response)
+	if err != nil {
+		return nil, err
+	}
+	existingPasskey, err := pu.passkeyRepo.GetByCredentialId(credential.Id)
+	if err != nil {
+		return nil, errors.New("could not get passkey")
+	}
+	if existingPasskey != nil {
+		return nil, errors.New("passkey is already registered")
+	}
+	if name == "" {
+		name = "Passkey"
+	}
+	passkey := &models.Passkey{
+		UserId:       user.Id,
+		CredentialId: credential.Id,
+		PublicKey:    credential.PublicKey,
+		SignCount:    credential.SignCount,
+		Transports:   transports,
+		Name:         name,
+	}
+	if err := pu.passkeyRepo.Create(passkey); err != nil {
+		return nil, fmt.Errorf("could not save passkey: %s", err.Error())
+	}
+	return passkey, nil
+}
+
+// BeginLogin returns options for navigator.credentials.get(). Passkeys are discoverable, so the user is not known
+// until the assertion is received.
+func (pu PasskeyUsecase) BeginLogin() (*webauthn.RequestOptions, error) {
+	challenge, err := pu.createSession(models.WebAuthnSession{Ceremony: webauthn.CeremonyGet})
+	if err != nil {
+		return nil, err
+	}
+	return pu.relyingParty.RequestOptions(challenge, nil), nil
+}
+
+// FinishLogin verifies the assertion and returns the owner of the passkey
+func (pu PasskeyUsecase) FinishLogin(response *webauthn.AssertionResponse) (*models.User, error) {
+	session, challenge, err := pu.takeSession(response.ClientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+	if session.Ceremony != webauthn.CeremonyGet {
+		return nil, errors.New("challenge is invalid or expired")
+	}
+	passkey, err := pu.passkeyRepo.GetByCredentialId(response.CredentialId)
+	if err != nil {
+		return nil, errors.New("could not get passkey")
+	}
+	if passkey == nil {
+		return nil, errors.New("passkey is not registered")
+	}
+	user, err := pu.userRepo.GetById(passkey.UserId)
+	if err != nil || user == nil {
+		return nil, errors.New("could not get user")
+	}
+	if len(response.UserHandle) != 0 && string(response.UserHandle) != string(user.Uuid[:]) {
+		return nil, errors.New("user handle mismatch")
+	}
+	signCount, err := pu.relyingParty.VerifyAssertion(challenge, response, &webauthn.Credential{
+		Id:        passkey.CredentialId,
+		PublicKey: passkey.PublicKey,
+		SignCount: passkey.SignCount,
+	})
+	if err != nil {
+		return nil, err
+	}
+	updated, err := pu.passkeyRepo.UpdateSignCount(passkey, signCount)
+	if err != nil {
+		return nil, fmt.Errorf("could not update passkey: %s", err.Error())
+	}
+	if !updated {
+		return nil, webauthn.ErrClonedCredential
+	}
+	if !user.IsActive || user.IsDeactivated() {
+		return nil, errors.New("user is not active")
+	}
+	return user, nil
+}
+
+func (pu PasskeyUsecase) GetPasskeys(userUuid string) ([]models.Passkey, error) {
+	user, err := getUser(pu.userRepo, userUuid)
+	if err != nil {
+		return nil, err
+	}
+	passkeys, err := pu.passkeyRepo.GetByUserId(user.Id)
+	if err != nil {
+		return nil, errors.New("could not get passkeys")
+	}
+	return passkeys, nil
+}
+
+func (pu PasskeyUsecase) DeletePasskey(userUuid string, passkeyId int) error {
+	user, err := getUser(pu.userRepo, userUuid)
+	if err != nil {
+		return err
+	}
+	passkeys, err := pu.passkeyRepo.GetByUserId(user.Id)
+	if err != nil {
+		return errors.New("could not get passkeys")
+	}
+	for i := range passkeys {
+		if passkeys[i].Id == passkeyId {
+			if err := pu.passkeyRepo.Delete(&passkeys[i]); err != nil {
+				return fmt.Errorf("could not delete passkey: %s", err.Error())
+			}
+			return nil
+		}
+	}
+	return errors.New("passkey not found")
+}
+
+func (pu PasskeyUsecase) createSession(session models.WebAuthnSession) ([]byte, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+	sessionJSON, err := json.Marshal(session)
+	if err != nil {
+		return nil, err
+	}
+	if err := pu.cache.Set(webAuthnSessionKey(challenge), sessionJSON, passkeyChallengeExp).Err(); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// takeSession finds the ceremony by the challenge signed by the authenticator, each challenge is used only once
+func (pu PasskeyUsecase) takeSession(clientDataJSON []byte) (*models.WebAuthnSession, []byte, error) {
+	clientData, err := webauthn.ParseClientData(clientDataJSON)
+	if err != nil {
+		return nil, nil, err
+	}
+	challenge, err := webauthn.Encoding.DecodeString(strings.TrimRight(clientData.Challenge, "="))
+	if err != nil || len(challenge) != webauthn.ChallengeSize {
+		return nil, nil, errors.New("challenge is invalid or expired")
+	}
+	sessionJSON, err := pu.cache.Get(webAuthnSessionKey(challenge)).Result()
+	if err != nil {
+		return nil, nil, errors.New("challenge is invalid or expired")
+	}
+	if deleted, err := pu.cache.Del(webAuthnSessionKey(challenge)).Result(); err != nil || deleted == 0 {
+		return nil, nil, errors.New("challenge is invalid or expired")
+	}
+	session := new(models.WebAuthnSession)
+	if err := json.Unmarshal([]byte(sessionJSON), session); err != nil {
+		return nil, nil, err
+	}
+	return session, challenge, nil
+}
+
+func credentialDescriptors(passkeys []models.Passkey) []webauthn.CredentialDescriptor {
+	descriptors := make([]webauthn.CredentialDescriptor, 0, len(passkeys))
+	for _, passkey := range passkeys {
+		descriptors = append(descriptors, webauthn.CredentialDescriptor{
+			Type:       webauthn.PublicKeyType,
+			Id:         webauthn.Encoding.EncodeToString(passkey.CredentialId),
+			Transports: passkey.Transports,
+		})
+	}
+	return descriptors
+}
+
+func webAuthnSessionKey(challenge []byte) string {
+	return "webauthn-challenge:" + webauthn.Encoding.EncodeToString(challenge)
+}
@@ -0,0 +1,189 @@
+package usecases
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/aerosystems/auth-service/internal/models"
+	"github.com/go-redis/redis/v7"
+	"sort"
+	"time"
+)
+
+const (
+	loginHistoryLength = 100
+	loginHistoryTtl    = 90 * 24 * time.Hour
+)
+
+// GetSessions returns active sessions of the user, the most recently refreshed first
+func (r *TokenUsecase) GetSessions(userUuid string) ([]models.Session, error) {
+	sessionUuids, err := r.cache.SMembers(userSessionsKey(userUuid)).Result()
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]models.Session, 0, len(sessionUuids))
+	for _, sessionUuid := range sessionUuids {
+		session, err := r.getSession(sessionUuid)
+		if errors.Is(err, redis.Nil) {
+			// the session is expired, clean up the index
+			r.cache.SRem(userSessionsKey(userUuid), sessionUuid)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastRefreshAt.After(sessions[j].LastRefreshAt)
+	})
+	return sessions, nil
+}
+
+// GetSessionUuid returns uuid of the session which the access token belongs to
+func (r *TokenUsecase) GetSessionUuid(accessUuid string) (string, error) {
+	cacheJSON, err := r.GetCacheValue(accessUuid)
+	if err != nil {
+		return "", err
+	}
+	accessTokenCache := new(AccessTokenCache)
+	if err := json.Unmarshal([]byte(*cacheJSON), accessTokenCache); err != nil {
+		return "", err
+	}
+	return accessTokenCache.FamilyUuid, nil
+}
+
+// GetAuthTime returns when the user signed in to the session which the access token belongs to, refreshing tokens does
+// not change it
+func (r *TokenUsecase) GetAuthTime(accessUuid string) (time.Time, error) {
+	sessionUuid, err := r.GetSessionUuid(accessUuid)
+	if err != nil {
+		return time.Time{}, err
+	}
+	session, err := r.getSession(sessionUuid)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return session.CreatedAt, nil
+}
+
+// RevokeSession signs the user out of one session
+func (r *TokenUsecase) RevokeSession(userUuid string, sessionUuid string) error {
+	session, err := r.getSession(sessionUuid)
+	if err != nil || session.UserUuid != userUuid {
+		return errors.New("session does not exist")
+	}
+	return r.revokeSession(userUuid, sessionUuid)
+}
+
+// RevokeSessions signs the user out everywhere
+func (r *TokenUsecase) RevokeSessions(userUuid string) error {
+	sessionUuids, err := r.cache.SMembers(userSessionsKey(userUuid)).Result()
+	if err != nil {
+		return err
+	}
+	for _, sessionUuid := range sessionUuids {
+		if err := r.revokeSession(userUuid, sessionUuid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RevokeOtherSessions signs the user out everywhere except the session which is kept
+func (r *TokenUsecase) RevokeOtherSessions(userUuid string, keepSessionUuid string) error {
+	sessionUuids, err := r.cache.SMembers(userSessionsKey(userUuid)).Result()
+	if err != nil {
+		return err
+	}
+	for _, sessionUuid := range sessionUuids {
+		if sessionUuid == keepSessionUuid {
+			continue
+		}
+		if err := r.revokeSession(userUuid, sessionUuid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetLoginHistory returns the latest sign ins of the user, the most recent first
+func (r *TokenUsecase) GetLoginHistory(userUuid string) ([]models.LoginEvent, error) {
+	eventsJSON, err := r.cache.LRange(loginHistoryKey(userUuid), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	events := make([]models.LoginEvent, 0, len(eventsJSON))
+	for _, eventJSON := range eventsJSON {
+		var event models.LoginEvent
+		if err := json.Unmarshal([]byte(eventJSON), &event); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// DropLoginHistory forgets sign ins of the user
+func (r *TokenUsecase) DropLoginHistory(userUuid string) error {
+	return r.cache.Del(loginHistoryKey(userUuid)).Err()
+}
+
+func (r *TokenUsecase) getSession(sessionUuid string) (*models.Session, error) {
+	sessionJSON, err := r.cache.Get(sessionKey(sessionUuid)).Result()
+	if err != nil {
+		return nil, err
+	}
+	session := new(models.Session)
+	if err := json.Unmarshal([]byte(sessionJSON), session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// saveSession stores the session for the lifetime of its latest refresh token and indexes it by user uuid
+func (r *TokenUsecase) saveSession(session *models.Session) error {
+	sessionJSON, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	ttl := time.Minute * time.Duration(r.refreshExpMinutes)
+	pipe := r.cache.TxPipeline()
+	pipe.Set(sessionKey(session.Uuid.String()), sessionJSON, ttl)
+	pipe.SAdd(userSessionsKey(session.UserUuid), session.Uuid.String())
+	pipe.Expire(userSessionsKey(session.UserUuid), ttl)
+	_, err = pipe.Exec()
+	return err
+}
+
+// recordLogin adds the sign in to the login history of the user, which keeps the last loginHistoryLength sign ins of
+// the last loginHistoryTtl
+func (r *TokenUsecase) recordLogin(session *models.Session) error {
+	eventJSON, err := json.Marshal(models.LoginEvent{
+		SessionUuid: session.Uuid,
+		UserAgent:   session.UserAgent,
+		Ip:          session.Ip,
+		CreatedAt:   session.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	pipe := r.cache.TxPipeline()
+	pipe.LPush(loginHistoryKey(session.UserUuid), eventJSON)
+	pipe.LTrim(loginHistoryKey(session.UserUuid), 0, loginHistoryLength-1)
+	pipe.Expire(loginHistoryKey(session.UserUuid), loginHistoryTtl)
+	_, err = pipe.Exec()
+	return err
+}
+
+// revokeSession drops the session and all tokens of its family from Redis cache
+func (r *TokenUsecase) revokeSession(userUuid string, sessionUuid string) error {
+	members, err := r.cache.SMembers(familyKey(sessionUuid)).Result()
+	if err != nil {
+		return err
+	}
+	pipe := r.cache.TxPipeline()
+	pipe.Del(append(members, familyKey(sessionUuid), sessionKey(sessionUuid))...)
+	pipe.SRem(userSessionsKey(userUuid), sessionUuid)
+	_, err = pipe.Exec()
+	return err
+}
@@ -0,0 +1,150 @@
+package usecases
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aerosystems/auth-service/internal/models"
+	"github.com/aerosystems/auth-service/pkg/jwk"
+	"github.com/google/uuid"
+	"strings"
+	"time"
+)
+
+// GetSigningKeys returns all known signing keys
+func (r *TokenUsecase) GetSigningKeys() ([]models.SigningKey, error) {
+	keys, err := r.signingKeyRepo.GetAll()
+	if err != nil {
+		return nil, errors.New("could not get signing keys")
+	}
+	return keys, nil
+}
+
+// AddSigningKey generates a new pending key. It is published in JWKS right away, so verifiers could cache it before it is promoted
+func (r *TokenUsecase) AddSigningKey(alg string) (*models.SigningKey, error) {
+	key, err := jwk.Generate(uuid.New().String(), alg)
+	if err != nil {
+		return nil, err
+	}
+	return r.createSigningKey(key, models.PendingKeyStatus)
+}
+
+// PromoteSigningKey makes the key active and retires the previous active key. The retired key still verifies tokens
+// until the longest living token signed with it expires.
+func (r *TokenUsecase) PromoteSigningKey(kid string) error {
+	key, err := r.signingKeyRepo.GetByKid(kid)
+	if err != nil {
+		return errors.New("could not get signing key")
+	}
+	if key == nil {
+		return errors.New("signing key does not exist")
+	}
+	if key.Status != models.PendingKeyStatus {
+		return fmt.Errorf("signing key is %s, only pending keys could be promoted", key.Status.String())
+	}
+	keys, err := r.signingKeyRepo.GetAll()
+	if err != nil {
+		return errors.New("could not get signing keys")
+	}
+	// activate the new key first, so there is always a key to sign tokens with
+	key.Status = models.ActiveKeyStatus
+	if err := r.signingKeyRepo.Update(key); err != nil {
+		return fmt.Errorf("could not activate signing key: %s", err.Error())
+	}
+	for i := range keys {
+		if keys[i].Status != models.ActiveKeyStatus {
+			continue
+		}
+		keys[i].Status = models.RetiredKeyStatus
+		keys[i].ExpireAt = time.Now().Add(r.keyGracePeriod())
+		if err := r.signingKeyRepo.Update(&keys[i]); err != nil {
+			return fmt.Errorf("could not retire signing key: %s", err.Error())
+		}
+	}
+	r.keyRing.Invalidate()
+	return nil
+}
+
+// EncryptSigningKeys encrypts private keys which were stored as plaintext PEM before they were encrypted at rest
+func (r *TokenUsecase) EncryptSigningKeys() error {
+	signingKeys, err := r.signingKeyRepo.GetAll()
+	if err != nil {
+		return errors.New("could not get signing keys")
+	}
+	for i := range signingKeys {
+		if !strings.HasPrefix(signingKeys[i].EncryptedPrivateKey, "-----BEGIN") {
+			continue
+		}
+		encryptedPrivateKey, err := r.keyEncryptor.Encrypt(signingKeys[i].EncryptedPrivateKey)
+		if err != nil {
+			return fmt.Errorf("could not encrypt signing key: %s", err.Error())
+		}
+		signingKeys[i].EncryptedPrivateKey = encryptedPrivateKey
+		if err := r.signingKeyRepo.Update(&signingKeys[i]); err != nil {
+			return fmt.Errorf("could not update signing key: %s", err.Error())
+		}
+	}
+	r.keyRing.Invalidate()
+	return nil
+}
+
+// ImportSigningKeys stores keys which are not known yet, and activates activeKid if there is no active key
+func (r *TokenUsecase) ImportSigningKeys(keys []*jwk.Key, activeKid string) error {
+	signingKeys, err := r.signingKeyRepo.GetAll()
+	if err != nil {
+		return errors.New("could not get signing keys")
+	}
+	known := make(map[string]bool, len(signingKeys))
+	hasActive := false
+	for _, signingKey := range signingKeys {
+		known[signingKey.Kid] = true
+		if signingKey.Status == models.ActiveKeyStatus {
+			hasActive = true
+		}
+	}
+	for _, key := range keys {
+		if known[key.Kid] {
+			continue
+		}
+		if _, err := r.createSigningKey(key, models.PendingKeyStatus); err != nil {
+			return err
+		}
+	}
+	if !hasActive {
+		return r.PromoteSigningKey(activeKid)
+	}
+	r.keyRing.Invalidate()
+	return nil
+}
+
+func (r *TokenUsecase) createSigningKey(key *jwk.Key, status models.KindKeyStatus) (*models.SigningKey, error) {
+	privateKey, err := key.MarshalPEM()
+	if err != nil {
+		return nil, err
+	}
+	encryptedPrivateKey, err := r.keyEncryptor.Encrypt(string(privateKey))
+	if err != nil {
+		return nil, fmt.Errorf("could not encrypt signing key: %s", err.Error())
+	}
+	signingKey := &models.SigningKey{
+		Kid:                 key.Kid,
+		Alg:                 key.Alg,
+		EncryptedPrivateKey: encryptedPrivateKey,
+		Status:              status,
+	}
+	if err := r.signingKeyRepo.Create(signingKey); err != nil {
+		return nil, fmt.Errorf("could not create signing key: %s", err.Error())
+	}
+	return signingKey, nil
+}
+
+// keyGracePeriod is the lifetime of the longest living token
+func (r *TokenUsecase) keyGracePeriod() time.Duration {
+	minutes := r.accessExpMinutes
+	if r.refreshExpMinutes > minutes {
+		minutes = r.refreshExpMinutes
+	}
+	if r.serviceExpMinutes > minutes {
+		minutes = r.serviceExpMinutes
+	}
+	return time.Duration(minutes) * time.Minute
+}
@@ -2,38 +2,62 @@ package usecases
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/aerosystems/auth-service/internal/models"
+	"github.com/aerosystems/auth-service/pkg/encryptor"
+	"github.com/aerosystems/auth-service/pkg/jwk"
 	"github.com/go-redis/redis/v7"
 	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
 	"time"
 )
 
+var (
+	ErrRefreshTokenReused = errors.New("refresh token was already used")
+	ErrRefreshTokenClient = errors.New("refresh token was issued to another client")
+)
+
 type TokenUsecase struct {
+	log               *logrus.Logger
 	cache             *redis.Client
-	accessSecret      string
-	refreshSecret     string
+	signingKeyRepo    SigningKeyRepository
+	keyRing           *keyRing
+	keyEncryptor      *encryptor.Encryptor
 	accessExpMinutes  int
 	refreshExpMinutes int
+	serviceExpMinutes int
 }
 
-func NewTokenUsecase(cache *redis.Client, accessSecret string, refreshSecret string, accessExpMinutes int, refreshExpMinutes int) *TokenUsecase {
+func NewTokenUsecase(log *logrus.Logger, cache *redis.Client, signingKeyRepo SigningKeyRepository, keyEncryptor *encryptor.Encryptor, accessExpMinutes int, refreshExpMinutes int, serviceExpMinutes int) *TokenUsecase {
 	return &TokenUsecase{
+		log:               log,
 		cache:             cache,
-		accessSecret:      accessSecret,
-		refreshSecret:     refreshSecret,
+		signingKeyRepo:    signingKeyRepo,
+		keyRing:           newKeyRing(signingKeyRepo, keyEncryptor),
+		keyEncryptor:      keyEncryptor,
 		accessExpMinutes:  accessExpMinutes,
 		refreshExpMinutes: refreshExpMinutes,
+		serviceExpMinutes: serviceExpMinutes,
 	}
 }
 
 type AccessTokenCache struct {
 	UserUuid    string `json:"userUuid"`
 	RefreshUuid string `json:"refreshUuid"`
+	FamilyUuid  string `json:"familyUuid"`
 }
 
-func (r *TokenUsecase) GetAccessSecret() string {
-	return r.accessSecret
+type RefreshTokenCache struct {
+	UserUuid   string `json:"userUuid"`
+	AccessUuid string `json:"accessUuid"`
+	FamilyUuid string `json:"familyUuid"`
+}
+
+// GetJWKS returns the public keys which verify issued tokens
+func (r *TokenUsecase) GetJWKS() (jwk.Set, error) {
+	return r.keyRing.Public()
 }
 
 // DropCacheKey function that will be used to drop the JWTs metadata from Redis
@@ -54,10 +78,155 @@ func (r *TokenUsecase) GetCacheValue(Uuid string) (*string, error) {
 	return &value, nil
 }
 
-// CreateToken returns JWT Token
-func (r *TokenUsecase) CreateToken(userUuid string, userRole string) (*models.TokenDetails, error) {
+// CreateToken returns JWT Token, that starts a new session with its own family of refresh tokens
+func (r *TokenUsecase) CreateToken(userUuid string, userRole string, userAgent string, ip string) (*models.TokenDetails, error) {
+	return r.startSession(userRole, &models.Session{
+		UserUuid:  userUuid,
+		UserAgent: userAgent,
+		Ip:        ip,
+	})
+}
+
+// CreateClientToken returns JWT Token of an OpenID Connect client. Its tokens carry the client id as audience and the
+// granted scope, so they are not accepted by first-party endpoints, and its family of refresh tokens is bound to the client.
+func (r *TokenUsecase) CreateClientToken(userUuid string, userRole string, clientId string, scope string, userAgent string, ip string) (*models.TokenDetails, error) {
+	return r.startSession(userRole, &models.Session{
+		UserUuid:  userUuid,
+		UserAgent: userAgent,
+		Ip:        ip,
+		ClientId:  clientId,
+		Scope:     scope,
+	})
+}
+
+func (r *TokenUsecase) startSession(userRole string, session *models.Session) (*models.TokenDetails, error) {
+	now := time.Now()
+	session.Uuid = uuid.New()
+	session.CreatedAt = now
+	session.LastRefreshAt = now
+	if err := r.saveSession(session); err != nil {
+		return nil, err
+	}
+	if err := r.recordLogin(session); err != nil {
+		return nil, err
+	}
+	return r.createToken(userRole, session)
+}
+
+// CreateServiceToken returns a short-lived access token of a service principal. It has no refresh token and no session,
+// the service gets a new token with its client credentials when this one expires.
+func (r *TokenUsecase) CreateServiceToken(clientId string, scope string) (*models.TokenDetails, error) {
+	td := &models.TokenDetails{
+		Scope:      scope,
+		AtExpires:  time.Now().Add(time.Minute * time.Duration(r.serviceExpMinutes)).Unix(),
+		AccessUuid: uuid.New(),
+	}
+	atClaims := jwt.MapClaims{}
+	atClaims["accessUuid"] = td.AccessUuid.String()
+	atClaims["userUuid"] = clientId
+	atClaims["userRole"] = models.ServiceRole.String()
+	atClaims["scope"] = scope
+	atClaims["exp"] = td.AtExpires
+	atClaims["iat"] = time.Now().Unix()
+	var err error
+	td.AccessToken, err = r.signToken(atClaims)
+	if err != nil {
+		return nil, err
+	}
+	accessCacheJSON, err := json.Marshal(AccessTokenCache{
+		UserUuid: clientId,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := r.cache.Set(td.AccessUuid.String(), accessCacheJSON, time.Until(time.Unix(td.AtExpires, 0))).Err(); err != nil {
+		return nil, err
+	}
+	return td, nil
+}
+
+// RefreshTokens exchanges a refresh token for a new pair of JWT tokens in the same family. Every refresh token could be
+// used only once, presenting an already used refresh token revokes the whole family, because one of its holders is an attacker.
+// Refresh tokens of OpenID Connect clients are refreshed by RefreshClientTokens only.
+func (r *TokenUsecase) RefreshTokens(refreshToken string) (*models.TokenDetails, error) {
+	return r.refreshTokens(refreshToken, "")
+}
+
+// RefreshClientTokens exchanges a refresh token of the OpenID Connect client the same way as RefreshTokens
+func (r *TokenUsecase) RefreshClientTokens(clientId string, refreshToken string) (*models.TokenDetails, error) {
+	return r.refreshTokens(refreshToken, clientId)
+}
+
+func (r *TokenUsecase) refreshTokens(refreshToken string, clientId string) (*models.TokenDetails, error) {
+	refreshTokenClaims, err := r.DecodeRefreshToken(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	// checked before the token is marked as used, so another client could not burn it
+	if refreshTokenClaims.Audience != clientId {
+		return nil, ErrRefreshTokenClient
+	}
+	ttl := time.Until(time.Unix(refreshTokenClaims.ExpiresAt, 0))
+	cacheJSON, err := r.GetCacheValue(refreshTokenClaims.RefreshUuid)
+	if err != nil {
+		if familyUuid, err := r.cache.Get(usedRefreshKey(refreshTokenClaims.RefreshUuid)).Result(); err == nil {
+			return nil, r.handleRefreshTokenReuse(refreshTokenClaims, familyUuid)
+		}
+		return nil, errors.New("refresh token is revoked")
+	}
+	refreshTokenCache := new(RefreshTokenCache)
+	if err := json.Unmarshal([]byte(*cacheJSON), refreshTokenCache); err != nil {
+		return nil, err
+	}
+	// mark the refresh token as used, only one of concurrent requests wins
+	ok, err := r.cache.SetNX(usedRefreshKey(refreshTokenClaims.RefreshUuid), refreshTokenCache.FamilyUuid, ttl).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, r.handleRefreshTokenReuse(refreshTokenClaims, refreshTokenCache.FamilyUuid)
+	}
+	session, err := r.getSession(refreshTokenCache.FamilyUuid)
+	if err != nil {
+		return nil, errors.New("session is revoked")
+	}
+	if session.ClientId != clientId {
+		return nil, ErrRefreshTokenClient
+	}
+	session.LastRefreshAt = time.Now()
+	if err := r.saveSession(session); err != nil {
+		return nil, err
+	}
+	// drop the previous pair, it is replaced by the new one
+	if err := r.cache.Del(refreshTokenCache.AccessUuid, refreshTokenClaims.RefreshUuid).Err(); err != nil {
+		return nil, err
+	}
+	if err := r.cache.SRem(familyKey(refreshTokenCache.FamilyUuid), refreshTokenCache.AccessUuid, refreshTokenClaims.RefreshUuid).Err(); err != nil {
+		return nil, err
+	}
+	return r.createToken(refreshTokenClaims.UserRole, session)
+}
+
+func (r *TokenUsecase) handleRefreshTokenReuse(refreshTokenClaims *models.RefreshTokenClaims, familyUuid string) error {
+	r.log.WithFields(logrus.Fields{
+		"userUuid":    refreshTokenClaims.UserUuid,
+		"refreshUuid": refreshTokenClaims.RefreshUuid,
+		"familyUuid":  familyUuid,
+	}).Warn("refresh token reuse detected, possible token theft, revoking token family")
+	if err := r.revokeSession(refreshTokenClaims.UserUuid, familyUuid); err != nil {
+		return fmt.Errorf("could not revoke token family: %s", err.Error())
+	}
+	return ErrRefreshTokenReused
+}
+
+// createToken issues a new pair of tokens in the family of the session
+func (r *TokenUsecase) createToken(userRole string, session *models.Session) (*models.TokenDetails, error) {
 	td := &models.TokenDetails{}
 	var err error
+	userUuid := session.UserUuid
+
+	td.FamilyUuid = session.Uuid
+	td.Scope = session.Scope
 
 	td.AtExpires = time.Now().Add(time.Minute * time.Duration(r.accessExpMinutes)).Unix()
 	td.AccessUuid = uuid.New()
@@ -70,8 +239,12 @@ func (r *TokenUsecase) CreateToken(userUuid string, userRole string) (*models.To
 	atClaims["userUuid"] = userUuid
 	atClaims["userRole"] = userRole
 	atClaims["exp"] = td.AtExpires
-	at := jwt.NewWithClaims(jwt.SigningMethodHS256, atClaims)
-	td.AccessToken, err = at.SignedString([]byte(r.accessSecret))
+	atClaims["iat"] = time.Now().Unix()
+	if session.ClientId != "" {
+		atClaims["aud"] = session.ClientId
+		atClaims["scope"] = session.Scope
+	}
+	td.AccessToken, err = r.signToken(atClaims)
 	if err != nil {
 		return nil, err
 	}
@@ -80,8 +253,11 @@ func (r *TokenUsecase) CreateToken(userUuid string, userRole string) (*models.To
 	rtClaims["userUuid"] = userUuid
 	rtClaims["userRole"] = userRole
 	rtClaims["exp"] = td.RtExpires
-	rt := jwt.NewWithClaims(jwt.SigningMethodHS256, rtClaims)
-	td.RefreshToken, err = rt.SignedString([]byte(r.refreshSecret))
+	rtClaims["iat"] = time.Now().Unix()
+	if session.ClientId != "" {
+		rtClaims["aud"] = session.ClientId
+	}
+	td.RefreshToken, err = r.signToken(rtClaims)
 	if err != nil {
 		return nil, err
 	}
@@ -93,45 +269,177 @@ func (r *TokenUsecase) CreateToken(userUuid string, userRole string) (*models.To
 }
 
 func (r *TokenUsecase) DecodeRefreshToken(tokenString string) (*models.RefreshTokenClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &models.RefreshTokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(r.refreshSecret), nil
-	})
-	if claims, ok := token.Claims.(*models.RefreshTokenClaims); ok && token.Valid {
-		return claims, nil
-	} else {
+	token, err := jwt.ParseWithClaims(tokenString, &models.RefreshTokenClaims{}, r.lookupKey)
+	if err != nil {
 		return nil, err
 	}
+	if claims, ok := token.Claims.(*models.RefreshTokenClaims); ok && token.Valid && claims.RefreshUuid != "" {
+		return claims, nil
+	}
+	return nil, errors.New("invalid refresh token")
 }
 
 func (r *TokenUsecase) DecodeAccessToken(tokenString string) (*models.AccessTokenClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &models.AccessTokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(r.accessSecret), nil
-	})
-	if claims, ok := token.Claims.(*models.AccessTokenClaims); ok && token.Valid {
+	token, err := jwt.ParseWithClaims(tokenString, &models.AccessTokenClaims{}, r.lookupKey)
+	if err != nil {
+		return nil, err
+	}
+	if claims, ok := token.Claims.(*models.AccessTokenClaims); ok && token.Valid && claims.AccessUuid != "" {
 		return claims, nil
-	} else {
+	}
+	return nil, errors.New("invalid access token")
+}
+
+// CreateIdToken signs an OpenID Connect ID token
+func (r *TokenUsecase) CreateIdToken(claims *models.IdTokenClaims) (string, error) {
+	return r.signToken(claims)
+}
+
+// Introspect returns the state of an access or refresh token. Invalid, expired and revoked tokens are inactive.
+func (r *TokenUsecase) Introspect(tokenString string, tokenTypeHint string) *models.TokenIntrospection {
+	if tokenTypeHint == models.RefreshTokenType {
+		if introspection := r.introspectRefreshToken(tokenString); introspection.Active {
+			return introspection
+		}
+		return r.introspectAccessToken(tokenString)
+	}
+	if introspection := r.introspectAccessToken(tokenString); introspection.Active {
+		return introspection
+	}
+	return r.introspectRefreshToken(tokenString)
+}
+
+// Revoke drops the session which the access or refresh token belongs to. Invalid and already revoked tokens are ignored.
+func (r *TokenUsecase) Revoke(tokenString string, tokenTypeHint string) error {
+	if tokenTypeHint == models.RefreshTokenType {
+		if revoked, err := r.revokeByRefreshToken(tokenString); revoked || err != nil {
+			return err
+		}
+		_, err := r.revokeByAccessToken(tokenString)
+		return err
+	}
+	if revoked, err := r.revokeByAccessToken(tokenString); revoked || err != nil {
+		return err
+	}
+	_, err := r.revokeByRefreshToken(tokenString)
+	return err
+}
+
+func (r *TokenUsecase) revokeByAccessToken(tokenString string) (bool, error) {
+	accessTokenClaims, err := r.DecodeAccessToken(tokenString)
+	if err != nil {
+		return false, nil
+	}
+	if _, err := r.GetCacheValue(accessTokenClaims.AccessUuid); err != nil {
+		return false, nil
+	}
+	return true, r.DropCacheTokens(accessTokenClaims.AccessUuid)
+}
+
+func (r *TokenUsecase) revokeByRefreshToken(tokenString string) (bool, error) {
+	refreshTokenClaims, err := r.DecodeRefreshToken(tokenString)
+	if err != nil {
+		return false, nil
+	}
+	cacheJSON, err := r.GetCacheValue(refreshTokenClaims.RefreshUuid)
+	if err != nil {
+		return false, nil
+	}
+	refreshTokenCache := new(RefreshTokenCache)
+	if err := json.Unmarshal([]byte(*cacheJSON), refreshTokenCache); err != nil {
+		return false, err
+	}
+	return true, r.revokeSession(refreshTokenCache.UserUuid, refreshTokenCache.FamilyUuid)
+}
+
+func (r *TokenUsecase) introspectAccessToken(tokenString string) *models.TokenIntrospection {
+	accessTokenClaims, err := r.DecodeAccessToken(tokenString)
+	if err != nil {
+		return &models.TokenIntrospection{}
+	}
+	if _, err := r.GetCacheValue(accessTokenClaims.AccessUuid); err != nil {
+		return &models.TokenIntrospection{}
+	}
+	return &models.TokenIntrospection{
+		Active:    true,
+		Subject:   accessTokenClaims.UserUuid,
+		Role:      accessTokenClaims.UserRole,
+		ExpiresAt: accessTokenClaims.ExpiresAt,
+		IssuedAt:  accessTokenClaims.IssuedAt,
+		Jti:       accessTokenClaims.AccessUuid,
+		Scope:     accessTokenClaims.Scope,
+		ClientId:  accessTokenClaims.Audience,
+		TokenType: models.AccessTokenType,
+	}
+}
+
+func (r *TokenUsecase) introspectRefreshToken(tokenString string) *models.TokenIntrospection {
+	refreshTokenClaims, err := r.DecodeRefreshToken(tokenString)
+	if err != nil {
+		return &models.TokenIntrospection{}
+	}
+	// used refresh tokens are dropped from cache, so they are inactive as well
+	if _, err := r.GetCacheValue(refreshTokenClaims.RefreshUuid); err != nil {
+		return &models.TokenIntrospection{}
+	}
+	return &models.TokenIntrospection{
+		Active:    true,
+		Subject:   refreshTokenClaims.UserUuid,
+		Role:      refreshTokenClaims.UserRole,
+		ExpiresAt: refreshTokenClaims.ExpiresAt,
+		IssuedAt:  refreshTokenClaims.IssuedAt,
+		Jti:       refreshTokenClaims.RefreshUuid,
+		ClientId:  refreshTokenClaims.Audience,
+		TokenType: models.RefreshTokenType,
+	}
+}
+
+// signToken signs claims with the active key and puts its kid in the token header
+func (r *TokenUsecase) signToken(claims jwt.Claims) (string, error) {
+	key, err := r.keyRing.Active()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.SigningMethod(), claims)
+	token.Header["kid"] = key.Kid
+	return token.SignedString(key.PrivateKey)
+}
+
+// lookupKey returns the public key referenced by the kid header of the token
+func (r *TokenUsecase) lookupKey(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, errors.New("token has no kid header")
+	}
+	key, err := r.keyRing.Lookup(kid)
+	if err != nil {
 		return nil, err
 	}
+	if token.Method.Alg() != key.Alg {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return key.PublicKey(), nil
 }
 
+// DropCacheTokens drops the pair of tokens and the rest of its family from Redis cache
 func (r *TokenUsecase) DropCacheTokens(accessUuid string) error {
-	cacheJSON, _ := r.GetCacheValue(accessUuid)
-	accessTokenCache := new(AccessTokenCache)
-	err := json.Unmarshal([]byte(*cacheJSON), accessTokenCache)
+	cacheJSON, err := r.GetCacheValue(accessUuid)
 	if err != nil {
 		return err
 	}
-	// drop refresh token from Redis cache
-	err = r.DropCacheKey(accessTokenCache.RefreshUuid)
-	if err != nil {
+	accessTokenCache := new(AccessTokenCache)
+	if err := json.Unmarshal([]byte(*cacheJSON), accessTokenCache); err != nil {
 		return err
 	}
-	// drop access token from Redis cache
-	err = r.DropCacheKey(accessUuid)
-	if err != nil {
+	// service tokens have neither refresh token nor session
+	if accessTokenCache.FamilyUuid == "" {
+		return r.cache.Del(accessUuid).Err()
+	}
+	// drop refresh and access tokens from Redis cache
+	if err := r.cache.Del(accessTokenCache.RefreshUuid, accessUuid).Err(); err != nil {
 		return err
 	}
-	return nil
+	return r.revokeSession(accessTokenCache.UserUuid, accessTokenCache.FamilyUuid)
 }
 
 // createCacheKey function that will be used to save the JWTs metadata in Redis
@@ -139,18 +447,48 @@ func (r *TokenUsecase) createCacheKey(userUuid string, td *models.TokenDetails)
 	at := time.Unix(td.AtExpires, 0) //converting Unix to UTC(to Time object)
 	rt := time.Unix(td.RtExpires, 0) //converting Unix to UTC(to Time object)
 	now := time.Now()
-	cacheJSON, err := json.Marshal(AccessTokenCache{
+	accessCacheJSON, err := json.Marshal(AccessTokenCache{
 		UserUuid:    userUuid,
 		RefreshUuid: td.RefreshUuid.String(),
+		FamilyUuid:  td.FamilyUuid.String(),
 	})
 	if err != nil {
 		return err
 	}
-	if err := r.cache.Set(td.AccessUuid.String(), cacheJSON, at.Sub(now)).Err(); err != nil {
-		return err
-	}
-	if err := r.cache.Set(td.RefreshUuid.String(), userUuid, rt.Sub(now)).Err(); err != nil {
+	refreshCacheJSON, err := json.Marshal(RefreshTokenCache{
+		UserUuid:   userUuid,
+		AccessUuid: td.AccessUuid.String(),
+		FamilyUuid: td.FamilyUuid.String(),
+	})
+	if err != nil {
 		return err
 	}
-	return nil
+	pipe := r.cache.TxPipeline()
+	pipe.Set(td.AccessUuid.String(), accessCacheJSON, at.Sub(now))
+	pipe.Set(td.RefreshUuid.String(), refreshCacheJSON, rt.Sub(now))
+	// the family lives as long as its latest refresh token
+	pipe.SAdd(familyKey(td.FamilyUuid.String()), td.AccessUuid.String(), td.RefreshUuid.String())
+	pipe.Expire(familyKey(td.FamilyUuid.String()), rt.Sub(now))
+	_, err = pipe.Exec()
+	return err
+}
+
+func familyKey(familyUuid string) string {
+	return "family:" + familyUuid
+}
+
+func usedRefreshKey(refreshUuid string) string {
+	return "used:" + refreshUuid
+}
+
+func sessionKey(sessionUuid string) string {
+	return "session:" + sessionUuid
+}
+
+func userSessionsKey(userUuid string) string {
+	return "user-sessions:" + userUuid
+}
+
+func loginHistoryKey(userUuid string) string {
+	return "login-history:" + userUuid
 }
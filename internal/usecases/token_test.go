@@ -0,0 +1,60 @@
+package usecases
+
+import (
+	"encoding/base64"
+	"github.com/aerosystems/auth-service/internal/models"
+	"github.com/aerosystems/auth-service/pkg/encryptor"
+	"github.com/aerosystems/auth-service/pkg/jwk"
+	"testing"
+)
+
+// fakeSigningKeyRepo keeps signing keys in memory
+type fakeSigningKeyRepo struct {
+	keys []models.SigningKey
+}
+
+func (r *fakeSigningKeyRepo) GetByKid(Kid string) (*models.SigningKey, error) {
+	for i := range r.keys {
+		if r.keys[i].Kid == Kid {
+			key := r.keys[i]
+			return &key, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeSigningKeyRepo) GetAll() ([]models.SigningKey, error) {
+	return append([]models.SigningKey(nil), r.keys...), nil
+}
+
+func (r *fakeSigningKeyRepo) Create(key *models.SigningKey) error {
+	key.Id = len(r.keys) + 1
+	r.keys = append(r.keys, *key)
+	return nil
+}
+
+func (r *fakeSigningKeyRepo) Update(key *models.SigningKey) error {
+	for i := range r.keys {
+		if r.keys[i].Id == key.Id {
+			r.keys[i] = *key
+		}
+	}
+	return nil
+}
+
+// newTestTokenUsecase returns a TokenUsecase backed by miniredis with one active ES256 key
+func newTestTokenUsecase(t *testing.T) *TokenUsecase {
+	keyEncryptor, err := encryptor.NewEncryptor(base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenUsecase := NewTokenUsecase(newTestLogger(), newTestCache(t), &fakeSigningKeyRepo{}, keyEncryptor, 15, 60, 15)
+	key, err := jwk.Generate("test", "ES256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tokenUsecase.ImportSigningKeys([]*jwk.Key{key}, key.Kid); err != nil {
+		t.Fatal(err)
+	}
+	return tokenUsecase
+}
@@ -0,0 +1,59 @@
+package encryptor
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+)
+
+// Encryptor protects secrets stored in the database with AES-256-GCM
+type Encryptor struct {
+	aead cipher.AEAD
+}
+
+// NewEncryptor takes a base64 encoded 32-byte key
+func NewEncryptor(key string) (*Encryptor, error) {
+	rawKey, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, errors.New("encryption key is not base64 encoded")
+	}
+	if len(rawKey) != 32 {
+		return nil, errors.New("encryption key should be 32 bytes long")
+	}
+	block, err := aes.NewCipher(rawKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Encryptor{aead: aead}, nil
+}
+
+// Encrypt returns the nonce and the ciphertext encoded with base64
+func (e *Encryptor) Encrypt(plaintext string) (string, error) {
+	nonce := make([]byte, e.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := e.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (e *Encryptor) Decrypt(ciphertext string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < e.aead.NonceSize() {
+		return "", errors.New("ciphertext is too short")
+	}
+	plaintext, err := e.aead.Open(nil, sealed[:e.aead.NonceSize()], sealed[e.aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("could not decrypt ciphertext")
+	}
+	return string(plaintext), nil
+}
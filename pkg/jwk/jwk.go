@@ -0,0 +1,184 @@
+package jwk
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Key is a private signing key identified by its kid
+type Key struct {
+	Kid        string
+	Alg        string
+	PrivateKey crypto.Signer
+}
+
+// JWK is the public part of a Key in the RFC 7517 JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// Set is the JSON Web Key Set document served to token verifiers
+type Set struct {
+	Keys []JWK `json:"keys"`
+}
+
+// ParsePrivateKeyPEM parses a PKCS#8, PKCS#1 or SEC 1 PEM encoded private key and detects its signing algorithm
+func ParsePrivateKeyPEM(kid string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("could not decode PEM block")
+	}
+	var privateKey any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		privateKey, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewKey(kid, privateKey)
+}
+
+// NewKey wraps a private key and detects its signing algorithm
+func NewKey(kid string, privateKey any) (*Key, error) {
+	switch k := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return &Key{Kid: kid, Alg: jwt.SigningMethodRS256.Alg(), PrivateKey: k}, nil
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return &Key{Kid: kid, Alg: jwt.SigningMethodES256.Alg(), PrivateKey: k}, nil
+		case elliptic.P384():
+			return &Key{Kid: kid, Alg: jwt.SigningMethodES384.Alg(), PrivateKey: k}, nil
+		case elliptic.P521():
+			return &Key{Kid: kid, Alg: jwt.SigningMethodES512.Alg(), PrivateKey: k}, nil
+		}
+		return nil, errors.New("unsupported elliptic curve")
+	case ed25519.PrivateKey:
+		return &Key{Kid: kid, Alg: jwt.SigningMethodEdDSA.Alg(), PrivateKey: k}, nil
+	}
+	return nil, fmt.Errorf("unsupported private key type %T", privateKey)
+}
+
+// Generate creates a new private key for the given signing algorithm
+func Generate(kid, alg string) (*Key, error) {
+	var privateKey any
+	var err error
+	switch alg {
+	case jwt.SigningMethodRS256.Alg():
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case jwt.SigningMethodES256.Alg():
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case jwt.SigningMethodES384.Alg():
+		privateKey, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case jwt.SigningMethodES512.Alg():
+		privateKey, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case jwt.SigningMethodEdDSA.Alg():
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %s", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewKey(kid, privateKey)
+}
+
+// LoadDir loads every *.pem file in dir, the file name without extension becomes the kid
+func LoadDir(dir string) ([]*Key, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]*Key, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		kid := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		key, err := ParsePrivateKeyPEM(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse key %s: %s", path, err.Error())
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// MarshalPEM encodes the private key as a PKCS#8 PEM block
+func (k *Key) MarshalPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// SigningMethod returns the JWT signing method matching the key algorithm
+func (k *Key) SigningMethod() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Alg)
+}
+
+// PublicKey returns the public key in the form expected by the JWT verifiers
+func (k *Key) PublicKey() crypto.PublicKey {
+	return k.PrivateKey.Public()
+}
+
+// PublicJWK returns the public part of the key as a JWK
+func (k *Key) PublicJWK() JWK {
+	j := JWK{
+		Use: "sig",
+		Kid: k.Kid,
+		Alg: k.Alg,
+	}
+	switch pub := k.PublicKey().(type) {
+	case *rsa.PublicKey:
+		j.Kty = "RSA"
+		j.N = encode(pub.N.Bytes())
+		j.E = encode(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		j.Kty = "EC"
+		j.Crv = pub.Curve.Params().Name
+		j.X = encode(pub.X.FillBytes(make([]byte, size)))
+		j.Y = encode(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		j.Kty = "OKP"
+		j.Crv = "Ed25519"
+		j.X = encode(pub)
+	}
+	return j
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,54 @@
+package jwk
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// KeySet holds the keys allowed to verify tokens and the one used to sign new tokens
+type KeySet struct {
+	active *Key
+	keys   map[string]*Key
+}
+
+func NewKeySet(keys []*Key, activeKid string) (*KeySet, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("key set is empty")
+	}
+	keySet := &KeySet{
+		keys: make(map[string]*Key, len(keys)),
+	}
+	for _, key := range keys {
+		keySet.keys[key.Kid] = key
+	}
+	active, ok := keySet.keys[activeKid]
+	if !ok {
+		return nil, fmt.Errorf("active key %s is not found", activeKid)
+	}
+	keySet.active = active
+	return keySet, nil
+}
+
+// Active returns the key used to sign new tokens
+func (s *KeySet) Active() *Key {
+	return s.active
+}
+
+// Lookup returns the key with the given kid
+func (s *KeySet) Lookup(kid string) (*Key, bool) {
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+// Public returns the public keys of the set as a JWKS document
+func (s *KeySet) Public() Set {
+	set := Set{Keys: make([]JWK, 0, len(s.keys))}
+	for _, key := range s.keys {
+		set.Keys = append(set.Keys, key.PublicJWK())
+	}
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].Kid < set.Keys[j].Kid
+	})
+	return set
+}
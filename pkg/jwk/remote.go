@@ -0,0 +1,158 @@
+package jwk
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const remoteSetMissInterval = 10 * time.Second
+
+// PublicKey parses the public key of the JWK
+func (j JWK) PublicKey() (crypto.PublicKey, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := decode(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(j.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported elliptic curve %s", j.Crv)
+		}
+		x, err := decode(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if j.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s", j.Crv)
+		}
+		x, err := decode(j.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", j.Kty)
+}
+
+// RemoteSet verifies tokens of an external identity provider with the keys from its JWKS endpoint. Keys are cached
+// and fetched again when they are stale or a token refers to an unknown kid.
+type RemoteSet struct {
+	url        string
+	ttl        time.Duration
+	httpClient *http.Client
+	mutex      sync.Mutex
+	keys       map[string]JWK
+	fetchedAt  time.Time
+}
+
+func NewRemoteSet(url string, ttl time.Duration) *RemoteSet {
+	return &RemoteSet{
+		url:        url,
+		ttl:        ttl,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Keyfunc returns the public key for the kid header of the token, it is meant for jwt.Parse
+func (s *RemoteSet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	j, err := s.lookup(kid)
+	if err != nil {
+		return nil, err
+	}
+	if j.Alg != "" && j.Alg != token.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return j.PublicKey()
+}
+
+func (s *RemoteSet) lookup(kid string) (JWK, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.keys == nil || time.Since(s.fetchedAt) > s.ttl {
+		if err := s.fetch(); err != nil {
+			return JWK{}, err
+		}
+	}
+	if j, ok := s.keys[kid]; ok {
+		return j, nil
+	}
+	// the provider could rotate its keys since the last fetch
+	if time.Since(s.fetchedAt) > remoteSetMissInterval {
+		if err := s.fetch(); err != nil {
+			return JWK{}, err
+		}
+		if j, ok := s.keys[kid]; ok {
+			return j, nil
+		}
+	}
+	return JWK{}, fmt.Errorf("unknown signing key %s", kid)
+}
+
+func (s *RemoteSet) fetch() error {
+	resp, err := s.httpClient.Get(s.url)
+	if err != nil {
+		return fmt.Errorf("could not fetch JWKS: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not fetch JWKS: unexpected status %d", resp.StatusCode)
+	}
+	var set Set
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("could not decode JWKS: %s", err.Error())
+	}
+	keys := make(map[string]JWK, len(set.Keys))
+	for _, j := range set.Keys {
+		if j.Use != "" && j.Use != "sig" {
+			continue
+		}
+		keys[j.Kid] = j
+	}
+	s.keys = keys
+	s.fetchedAt = time.Now()
+	return nil
+}
+
+func decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
@@ -14,7 +14,7 @@ func (l *Logger) Say(msg string) {
 	l.Info(msg)
 }
 func (l *Logger) Sayf(fmt string, args ...interface{}) {
-	l.Infof(fmt, args)
+	l.Infof(fmt, args...)
 }
 func (l *Logger) SayWithField(msg string, k string, v interface{}) {
 	l.WithField(k, v).Info(msg)
@@ -0,0 +1,79 @@
+package RateLimiter
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v7"
+	"github.com/google/uuid"
+	"time"
+)
+
+// slidingWindowScript keeps timestamps of requests in a sorted set, requests older than the window are dropped before
+// counting. It returns whether the request is allowed, the remaining number of requests and milliseconds until the
+// oldest request leaves the window.
+var slidingWindowScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[4])
+	redis.call('PEXPIRE', KEYS[1], window)
+	count = count + 1
+	allowed = 1
+end
+local reset = window
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+return {allowed, limit - count, reset}
+`)
+
+type Limit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// Result describes the state of the window after the request
+type Result struct {
+	Allowed   bool
+	Limit     Limit
+	Remaining int
+	// Reset is the time until the oldest request in the window expires, a rejected request could be retried after it
+	Reset time.Duration
+}
+
+type Limiter struct {
+	client *redis.Client
+}
+
+func NewLimiter(client *redis.Client) *Limiter {
+	return &Limiter{
+		client: client,
+	}
+}
+
+// Allow counts the request in the sliding window of the key if the limit is not reached yet
+func (l *Limiter) Allow(key string, limit Limit) (*Result, error) {
+	now := time.Now().UnixMilli()
+	res, err := slidingWindowScript.Run(l.client, []string{"rate-limit:" + key}, now, limit.Window.Milliseconds(), limit.Requests, fmt.Sprintf("%d-%s", now, uuid.New().String())).Result()
+	if err != nil {
+		return nil, err
+	}
+	values, ok := res.([]interface{})
+	if !ok || len(values) != 3 {
+		return nil, errors.New("unexpected rate limit script result")
+	}
+	allowed, _ := values[0].(int64)
+	remaining, _ := values[1].(int64)
+	reset, _ := values[2].(int64)
+	return &Result{
+		Allowed:   allowed == 1,
+		Limit:     limit,
+		Remaining: int(remaining),
+		Reset:     time.Duration(reset) * time.Millisecond,
+	}, nil
+}
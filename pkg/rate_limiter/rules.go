@@ -0,0 +1,90 @@
+package RateLimiter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultRules protect public routes which check credentials or send emails
+const DefaultRules = "/v1/sign-up:ip=10/1h,email=3/1h;" +
+	"/v1/sign-in:ip=30/1m,email=10/1m;" +
+	"/v1/sign-in/mfa:ip=30/1m;" +
+	"/v1/sign-in/code:ip=10/1h,email=3/15m;" +
+	"/v1/sign-in/code/verify:ip=30/1m,email=10/1m;" +
+	"/v1/sign-in/passkey/options:ip=30/1m;" +
+	"/v1/sign-in/passkey:ip=30/1m;" +
+	"/v1/sign-in/:provider:ip=30/1m;" +
+	"/v1/confirm:ip=20/15m,email=10/15m;" +
+	"/v1/confirm/resend:ip=10/1h,email=5/1h;" +
+	"/v1/reset-password:ip=10/1h,email=3/1h;" +
+	"/v1/token/refresh:ip=60/1m;" +
+	"/authorize:ip=60/1m,email=10/1m;" +
+	"/token:ip=60/1m;" +
+	"/oauth/introspect:ip=60/1m;" +
+	"/oauth/revoke:ip=60/1m"
+
+// Rules are limits of a route, a nil limit is not checked
+type Rules struct {
+	PerIp    *Limit
+	PerEmail *Limit
+}
+
+// ParseRules reads rules of routes in the form "/v1/sign-in:ip=30/1m,email=10/1m;/v1/confirm:ip=30/1m", the window is
+// a Go duration. Routes are echo paths, so they could have parameters like /v1/sign-in/:provider
+func ParseRules(s string) (map[string]Rules, error) {
+	rules := make(map[string]Rules)
+	for _, route := range strings.Split(s, ";") {
+		route = strings.TrimSpace(route)
+		if route == "" {
+			continue
+		}
+		// limits have no colons, unlike path parameters
+		i := strings.LastIndex(route, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid rate limit rule %q", route)
+		}
+		path, limits := route[:i], route[i+1:]
+		if path == "" {
+			return nil, fmt.Errorf("invalid rate limit rule %q", route)
+		}
+		var routeRules Rules
+		for _, rule := range strings.Split(limits, ",") {
+			name, value, ok := strings.Cut(strings.TrimSpace(rule), "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid rate limit rule %q", rule)
+			}
+			limit, err := parseLimit(value)
+			if err != nil {
+				return nil, err
+			}
+			switch name {
+			case "ip":
+				routeRules.PerIp = limit
+			case "email":
+				routeRules.PerEmail = limit
+			default:
+				return nil, fmt.Errorf("unknown rate limit key %q", name)
+			}
+		}
+		rules[strings.TrimSpace(path)] = routeRules
+	}
+	return rules, nil
+}
+
+func parseLimit(s string) (*Limit, error) {
+	requests, window, ok := strings.Cut(s, "/")
+	if !ok {
+		return nil, fmt.Errorf("invalid rate limit %q", s)
+	}
+	limit := new(Limit)
+	var err error
+	if limit.Requests, err = strconv.Atoi(requests); err != nil || limit.Requests <= 0 {
+		return nil, fmt.Errorf("invalid number of requests in rate limit %q", s)
+	}
+	if limit.Window, err = time.ParseDuration(window); err != nil || limit.Window < time.Second {
+		return nil, fmt.Errorf("invalid window in rate limit %q", s)
+	}
+	return limit, nil
+}
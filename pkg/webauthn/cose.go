@@ -0,0 +1,146 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers, https://www.iana.org/assignments/cose/cose.xhtml#algorithms
+const (
+	AlgES256 int64 = -7
+	AlgEdDSA int64 = -8
+	AlgES384 int64 = -35
+	AlgES512 int64 = -36
+	AlgRS256 int64 = -257
+)
+
+// SupportedAlgorithms are offered to authenticators in the order of preference
+var SupportedAlgorithms = []int64{AlgES256, AlgEdDSA, AlgES384, AlgES512, AlgRS256}
+
+const (
+	coseKeyType    int64 = 1
+	coseKeyAlg     int64 = 3
+	coseKeyCrv     int64 = -1
+	coseKeyX       int64 = -2
+	coseKeyY       int64 = -3
+	coseKeyRsaN    int64 = -1
+	coseKeyRsaE    int64 = -2
+	coseKtyOkp     int64 = 1
+	coseKtyEc2     int64 = 2
+	coseKtyRsa     int64 = 3
+	coseCrvP256    int64 = 1
+	coseCrvP384    int64 = 2
+	coseCrvP521    int64 = 3
+	coseCrvEd25519 int64 = 6
+)
+
+type publicKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+// parsePublicKey decodes a COSE_Key of the credential
+func parsePublicKey(coseKey []byte) (*publicKey, error) {
+	decoded, rest, err := decodeCbor(coseKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("unexpected data after public key")
+	}
+	params, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("public key is not a map")
+	}
+	kty, _ := params[coseKeyType].(int64)
+	alg, _ := params[coseKeyAlg].(int64)
+	switch kty {
+	case coseKtyEc2:
+		var curve elliptic.Curve
+		crv, _ := params[coseKeyCrv].(int64)
+		switch {
+		case crv == coseCrvP256 && alg == AlgES256:
+			curve = elliptic.P256()
+		case crv == coseCrvP384 && alg == AlgES384:
+			curve = elliptic.P384()
+		case crv == coseCrvP521 && alg == AlgES512:
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %d for algorithm %d", crv, alg)
+		}
+		x, _ := params[coseKeyX].([]byte)
+		y, _ := params[coseKeyY].([]byte)
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x) != size || len(y) != size {
+			return nil, errors.New("invalid ec point")
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("ec point is not on the curve")
+		}
+		return &publicKey{alg: alg, key: key}, nil
+	case coseKtyOkp:
+		crv, _ := params[coseKeyCrv].(int64)
+		x, _ := params[coseKeyX].([]byte)
+		if crv != coseCrvEd25519 || alg != AlgEdDSA || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("unsupported okp key for algorithm %d", alg)
+		}
+		return &publicKey{alg: alg, key: ed25519.PublicKey(x)}, nil
+	case coseKtyRsa:
+		if alg != AlgRS256 {
+			return nil, fmt.Errorf("unsupported rsa algorithm %d", alg)
+		}
+		n, _ := params[coseKeyRsaN].([]byte)
+		e, _ := params[coseKeyRsaE].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid rsa key")
+		}
+		exponent := new(big.Int).SetBytes(e)
+		return &publicKey{alg: alg, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %d", kty)
+	}
+}
+
+func (pk *publicKey) verify(message, signature []byte) error {
+	switch key := pk.key.(type) {
+	case *ecdsa.PublicKey:
+		var digest []byte
+		switch pk.alg {
+		case AlgES256:
+			sum := sha256.Sum256(message)
+			digest = sum[:]
+		case AlgES384:
+			sum := sha512.Sum384(message)
+			digest = sum[:]
+		default:
+			sum := sha512.Sum512(message)
+			digest = sum[:]
+		}
+		if !ecdsa.VerifyASN1(key, digest, signature) {
+			return ErrInvalidSignature
+		}
+		return nil
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, message, signature) {
+			return ErrInvalidSignature
+		}
+		return nil
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(message)
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return ErrInvalidSignature
+		}
+		return nil
+	default:
+		return errors.New("unsupported public key")
+	}
+}
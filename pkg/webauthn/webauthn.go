@@ -0,0 +1,332 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	ChallengeSize        = 32
+	PublicKeyType        = "public-key"
+	CeremonyCreate       = "webauthn.create"
+	CeremonyGet          = "webauthn.get"
+	flagUserPresent      = 0x01
+	flagUserVerified     = 0x04
+	flagAttestedCredData = 0x40
+	flagExtensionData    = 0x80
+	// authDataMinLength is the length of rpIdHash, flags and signCount
+	authDataMinLength = 37
+	aaguidLength      = 16
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrClonedCredential = errors.New("sign count did not increase, the credential may be cloned")
+)
+
+// Encoding is used for binary values in JSON, as in PublicKeyCredential.toJSON() of browsers
+var Encoding = base64.RawURLEncoding
+
+// RelyingParty verifies WebAuthn ceremonies of the service. User verification is always required, so a passkey is
+// a complete second factor by itself. Attestation is not requested, the authenticator model is not checked.
+type RelyingParty struct {
+	Id      string
+	Name    string
+	Origins []string
+	Timeout int
+}
+
+func NewRelyingParty(id, name string, origins []string, timeoutMs int) *RelyingParty {
+	return &RelyingParty{
+		Id:      id,
+		Name:    name,
+		Origins: origins,
+		Timeout: timeoutMs,
+	}
+}
+
+type Entity struct {
+	Id          string `json:"id,omitempty"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName,omitempty"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	Id         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions are passed to navigator.credentials.create() as publicKey
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	Rp                     Entity                 `json:"rp"`
+	User                   Entity                 `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int                    `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions are passed to navigator.credentials.get() as publicKey
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int                    `json:"timeout"`
+	RpId             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+type AttestationResponse struct {
+	ClientDataJSON    []byte
+	AttestationObject []byte
+}
+
+type AssertionResponse struct {
+	CredentialId      []byte
+	ClientDataJSON    []byte
+	AuthenticatorData []byte
+	Signature         []byte
+	UserHandle        []byte
+}
+
+// Credential is the result of registration, the public key is kept as a COSE_Key
+type Credential struct {
+	Id        []byte
+	PublicKey []byte
+	SignCount uint32
+}
+
+type CollectedClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+type authenticatorData struct {
+	rpIdHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialId []byte
+	publicKey    []byte
+}
+
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, ChallengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// ParseClientData decodes clientDataJSON, the challenge in it is used to find the ceremony before it is verified
+func ParseClientData(clientDataJSON []byte) (*CollectedClientData, error) {
+	clientData := new(CollectedClientData)
+	if err := json.Unmarshal(clientDataJSON, clientData); err != nil {
+		return nil, fmt.Errorf("invalid client data: %s", err.Error())
+	}
+	return clientData, nil
+}
+
+func (rp *RelyingParty) CreationOptions(challenge, userId []byte, userName, displayName string, exclude []CredentialDescriptor) *CreationOptions {
+	params := make([]CredentialParameter, 0, len(SupportedAlgorithms))
+	for _, alg := range SupportedAlgorithms {
+		params = append(params, CredentialParameter{Type: PublicKeyType, Alg: alg})
+	}
+	if exclude == nil {
+		exclude = []CredentialDescriptor{}
+	}
+	return &CreationOptions{
+		Challenge:          Encoding.EncodeToString(challenge),
+		Rp:                 Entity{Id: rp.Id, Name: rp.Name},
+		User:               Entity{Id: Encoding.EncodeToString(userId), Name: userName, DisplayName: displayName},
+		PubKeyCredParams:   params,
+		Timeout:            rp.Timeout,
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "required",
+			UserVerification: "required",
+		},
+		Attestation: "none",
+	}
+}
+
+func (rp *RelyingParty) RequestOptions(challenge []byte, allow []CredentialDescriptor) *RequestOptions {
+	if allow == nil {
+		allow = []CredentialDescriptor{}
+	}
+	return &RequestOptions{
+		Challenge:        Encoding.EncodeToString(challenge),
+		Timeout:          rp.Timeout,
+		RpId:             rp.Id,
+		AllowCredentials: allow,
+		UserVerification: "required",
+	}
+}
+
+// VerifyRegistration checks the response of navigator.credentials.create() and returns the new credential
+func (rp *RelyingParty) VerifyRegistration(challenge []byte, response *AttestationResponse) (*Credential, error) {
+	if err := rp.verifyClientData(response.ClientDataJSON, CeremonyCreate, challenge); err != nil {
+		return nil, err
+	}
+	decoded, rest, err := decodeCbor(response.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attestation object: %s", err.Error())
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("unexpected data after attestation object")
+	}
+	attestationObject, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("attestation object is not a map")
+	}
+	rawAuthData, ok := attestationObject["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("attestation object has no authenticator data")
+	}
+	authData, err := rp.verifyAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if authData.credentialId == nil {
+		return nil, errors.New("authenticator data has no attested credential")
+	}
+	if _, err := parsePublicKey(authData.publicKey); err != nil {
+		return nil, err
+	}
+	return &Credential{
+		Id:        authData.credentialId,
+		PublicKey: authData.publicKey,
+		SignCount: authData.signCount,
+	}, nil
+}
+
+// VerifyAssertion checks the response of navigator.credentials.get() signed by the credential and returns the new
+// sign count to be stored
+func (rp *RelyingParty) VerifyAssertion(challenge []byte, response *AssertionResponse, credential *Credential) (uint32, error) {
+	if !bytes.Equal(response.CredentialId, credential.Id) {
+		return 0, errors.New("credential id mismatch")
+	}
+	if err := rp.verifyClientData(response.ClientDataJSON, CeremonyGet, challenge); err != nil {
+		return 0, err
+	}
+	authData, err := rp.verifyAuthenticatorData(response.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	key, err := parsePublicKey(credential.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(response.ClientDataJSON)
+	signed := append(append([]byte(nil), response.AuthenticatorData...), clientDataHash[:]...)
+	if err := key.verify(signed, response.Signature); err != nil {
+		return 0, err
+	}
+	// authenticators without a counter always return zero, e.g. synced passkeys
+	if (authData.signCount != 0 || credential.SignCount != 0) && authData.signCount <= credential.SignCount {
+		return 0, ErrClonedCredential
+	}
+	return authData.signCount, nil
+}
+
+func (rp *RelyingParty) verifyClientData(clientDataJSON []byte, ceremony string, challenge []byte) error {
+	clientData, err := ParseClientData(clientDataJSON)
+	if err != nil {
+		return err
+	}
+	if clientData.Type != ceremony {
+		return fmt.Errorf("unexpected ceremony type %s", clientData.Type)
+	}
+	receivedChallenge, err := Encoding.DecodeString(strings.TrimRight(clientData.Challenge, "="))
+	if err != nil || subtle.ConstantTimeCompare(receivedChallenge, challenge) != 1 {
+		return errors.New("challenge mismatch")
+	}
+	if clientData.CrossOrigin {
+		return errors.New("cross origin ceremonies are not allowed")
+	}
+	for _, origin := range rp.Origins {
+		if clientData.Origin == origin {
+			return nil
+		}
+	}
+	return fmt.Errorf("unexpected origin %s", clientData.Origin)
+}
+
+func (rp *RelyingParty) verifyAuthenticatorData(data []byte) (*authenticatorData, error) {
+	authData, err := parseAuthenticatorData(data)
+	if err != nil {
+		return nil, err
+	}
+	rpIdHash := sha256.Sum256([]byte(rp.Id))
+	if subtle.ConstantTimeCompare(authData.rpIdHash, rpIdHash[:]) != 1 {
+		return nil, errors.New("rp id mismatch")
+	}
+	if authData.flags&flagUserPresent == 0 {
+		return nil, errors.New("user is not present")
+	}
+	if authData.flags&flagUserVerified == 0 {
+		return nil, errors.New("user is not verified")
+	}
+	return authData, nil
+}
+
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < authDataMinLength {
+		return nil, errors.New("authenticator data is too short")
+	}
+	authData := &authenticatorData{
+		rpIdHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rest := data[authDataMinLength:]
+	if authData.flags&flagAttestedCredData != 0 {
+		if len(rest) < aaguidLength+2 {
+			return nil, errors.New("attested credential data is too short")
+		}
+		idLength := int(binary.BigEndian.Uint16(rest[aaguidLength:]))
+		rest = rest[aaguidLength+2:]
+		if idLength == 0 || idLength > 1023 || len(rest) < idLength {
+			return nil, errors.New("invalid credential id")
+		}
+		authData.credentialId = append([]byte(nil), rest[:idLength]...)
+		rest = rest[idLength:]
+		var err error
+		keyRest := rest
+		if _, rest, err = decodeCbor(rest); err != nil {
+			return nil, fmt.Errorf("invalid credential public key: %s", err.Error())
+		}
+		authData.publicKey = append([]byte(nil), keyRest[:len(keyRest)-len(rest)]...)
+	}
+	if authData.flags&flagExtensionData != 0 {
+		var err error
+		if _, rest, err = decodeCbor(rest); err != nil {
+			return nil, fmt.Errorf("invalid extensions: %s", err.Error())
+		}
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("unexpected data after authenticator data")
+	}
+	return authData, nil
+}
@@ -0,0 +1,217 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+const (
+	testRpId   = "example.com"
+	testOrigin = "https://example.com"
+)
+
+// softAuthenticator is a software authenticator with one ES256 credential, it answers ceremonies the way a browser
+// and a platform authenticator do together
+type softAuthenticator struct {
+	t            *testing.T
+	key          *ecdsa.PrivateKey
+	credentialId []byte
+	rpId         string
+	origin       string
+	signCount    uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialId := make([]byte, 16)
+	if _, err := rand.Read(credentialId); err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{t: t, key: key, credentialId: credentialId, rpId: testRpId, origin: testOrigin}
+}
+
+func (a *softAuthenticator) clientData(ceremony string, challenge []byte) []byte {
+	clientDataJSON, err := json.Marshal(CollectedClientData{
+		Type:      ceremony,
+		Challenge: Encoding.EncodeToString(challenge),
+		Origin:    a.origin,
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return clientDataJSON
+}
+
+func (a *softAuthenticator) authData(flags byte, attested []byte) []byte {
+	rpIdHash := sha256.Sum256([]byte(a.rpId))
+	data := append([]byte(nil), rpIdHash[:]...)
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+// coseKey encodes the public key as a COSE_Key map {1: 2, 3: -7, -1: 1, -2: x, -3: y}
+func (a *softAuthenticator) coseKey() []byte {
+	x := a.key.PublicKey.X.FillBytes(make([]byte, 32))
+	y := a.key.PublicKey.Y.FillBytes(make([]byte, 32))
+	key := []byte{0xa5, 0x01, 0x02, 0x03, 0x26, 0x20, 0x01, 0x21, 0x58, 0x20}
+	key = append(key, x...)
+	key = append(key, 0x22, 0x58, 0x20)
+	return append(key, y...)
+}
+
+func (a *softAuthenticator) create(challenge []byte) *AttestationResponse {
+	attested := make([]byte, aaguidLength)
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialId)))
+	attested = append(attested, a.credentialId...)
+	attested = append(attested, a.coseKey()...)
+	authData := a.authData(flagUserPresent|flagUserVerified|flagAttestedCredData, attested)
+	// attestation object {"fmt": "none", "attStmt": {}, "authData": authData}
+	attestationObject := []byte{0xa3, 0x63, 'f', 'm', 't', 0x64, 'n', 'o', 'n', 'e', 0x67, 'a', 't', 't', 'S', 't', 'm', 't', 0xa0, 0x68, 'a', 'u', 't', 'h', 'D', 'a', 't', 'a', 0x58, byte(len(authData))}
+	attestationObject = append(attestationObject, authData...)
+	return &AttestationResponse{
+		ClientDataJSON:    a.clientData(CeremonyCreate, challenge),
+		AttestationObject: attestationObject,
+	}
+}
+
+func (a *softAuthenticator) get(challenge []byte) *AssertionResponse {
+	a.signCount++
+	clientDataJSON := a.clientData(CeremonyGet, challenge)
+	authData := a.authData(flagUserPresent|flagUserVerified, nil)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return &AssertionResponse{
+		CredentialId:      a.credentialId,
+		ClientDataJSON:    clientDataJSON,
+		AuthenticatorData: authData,
+		Signature:         signature,
+	}
+}
+
+func newTestChallenge(t *testing.T) []byte {
+	challenge, err := NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return challenge
+}
+
+func register(t *testing.T, rp *RelyingParty, authenticator *softAuthenticator) *Credential {
+	challenge := newTestChallenge(t)
+	credential, err := rp.VerifyRegistration(challenge, authenticator.create(challenge))
+	if err != nil {
+		t.Fatalf("registration failed: %v", err)
+	}
+	return credential
+}
+
+func TestRegistrationAndLogin(t *testing.T) {
+	rp := NewRelyingParty(testRpId, "Example", []string{testOrigin}, 60000)
+	authenticator := newSoftAuthenticator(t)
+
+	credential := register(t, rp, authenticator)
+	if string(credential.Id) != string(authenticator.credentialId) {
+		t.Fatal("credential id does not match the authenticator")
+	}
+
+	for i := 0; i < 2; i++ {
+		challenge := newTestChallenge(t)
+		signCount, err := rp.VerifyAssertion(challenge, authenticator.get(challenge), credential)
+		if err != nil {
+			t.Fatalf("login failed: %v", err)
+		}
+		if signCount != authenticator.signCount {
+			t.Fatalf("expected sign count %d, got %d", authenticator.signCount, signCount)
+		}
+		credential.SignCount = signCount
+	}
+}
+
+func TestRejectsAnotherChallenge(t *testing.T) {
+	rp := NewRelyingParty(testRpId, "Example", []string{testOrigin}, 60000)
+	authenticator := newSoftAuthenticator(t)
+	credential := register(t, rp, authenticator)
+
+	if _, err := rp.VerifyAssertion(newTestChallenge(t), authenticator.get(newTestChallenge(t)), credential); err == nil {
+		t.Fatal("expected an assertion of another challenge to be rejected")
+	}
+}
+
+func TestRejectsBadOrigin(t *testing.T) {
+	rp := NewRelyingParty(testRpId, "Example", []string{testOrigin}, 60000)
+	authenticator := newSoftAuthenticator(t)
+	authenticator.origin = "https://evil.example.org"
+
+	challenge := newTestChallenge(t)
+	if _, err := rp.VerifyRegistration(challenge, authenticator.create(challenge)); err == nil {
+		t.Fatal("expected registration from another origin to be rejected")
+	}
+
+	authenticator.origin = testOrigin
+	credential := register(t, rp, authenticator)
+	authenticator.origin = "https://evil.example.org"
+	challenge = newTestChallenge(t)
+	if _, err := rp.VerifyAssertion(challenge, authenticator.get(challenge), credential); err == nil {
+		t.Fatal("expected login from another origin to be rejected")
+	}
+}
+
+func TestRejectsBadRpId(t *testing.T) {
+	rp := NewRelyingParty(testRpId, "Example", []string{testOrigin}, 60000)
+	authenticator := newSoftAuthenticator(t)
+	authenticator.rpId = "evil.example.org"
+
+	challenge := newTestChallenge(t)
+	if _, err := rp.VerifyRegistration(challenge, authenticator.create(challenge)); err == nil {
+		t.Fatal("expected registration for another rp id to be rejected")
+	}
+
+	authenticator.rpId = testRpId
+	credential := register(t, rp, authenticator)
+	authenticator.rpId = "evil.example.org"
+	challenge = newTestChallenge(t)
+	if _, err := rp.VerifyAssertion(challenge, authenticator.get(challenge), credential); err == nil {
+		t.Fatal("expected login for another rp id to be rejected")
+	}
+}
+
+func TestRejectsSignCountRegression(t *testing.T) {
+	rp := NewRelyingParty(testRpId, "Example", []string{testOrigin}, 60000)
+	authenticator := newSoftAuthenticator(t)
+	credential := register(t, rp, authenticator)
+	// the stored counter is ahead of the authenticator, as if a clone had been used
+	credential.SignCount = 10
+	authenticator.signCount = 4
+
+	challenge := newTestChallenge(t)
+	if _, err := rp.VerifyAssertion(challenge, authenticator.get(challenge), credential); !errors.Is(err, ErrClonedCredential) {
+		t.Fatalf("expected %v, got %v", ErrClonedCredential, err)
+	}
+}
+
+func TestRejectsInvalidSignature(t *testing.T) {
+	rp := NewRelyingParty(testRpId, "Example", []string{testOrigin}, 60000)
+	authenticator := newSoftAuthenticator(t)
+	credential := register(t, rp, authenticator)
+
+	challenge := newTestChallenge(t)
+	response := authenticator.get(challenge)
+	response.Signature[len(response.Signature)-1] ^= 0xff
+	if _, err := rp.VerifyAssertion(challenge, response, credential); err == nil {
+		t.Fatal("expected a tampered signature to be rejected")
+	}
+}